@@ -5,11 +5,12 @@ import (
     "fmt"
     "os"
     "strings"
-    // "strconv"
+    "strconv"
     "errors"
     // "runtime"
 
     "goverse/core"
+    "goverse/internal/models"
 )
 
 //////////////////////////////
@@ -32,6 +33,66 @@ const (
     CAUTION = '⚠'
 )
 
+// lanes of log --graph cycle through these
+var LANE_COLORS = []string{
+    MAKE_RED, MAKE_GREEN, MAKE_YELLOW, MAKE_BLUE, "\033[35m", "\033[36m",
+}
+
+// Exit codes for single command mode, see exitCode
+const (
+    EXIT_OK                 = 0
+    EXIT_FAILURE            = 1
+    EXIT_USAGE              = 2
+    EXIT_NOT_A_REPOSITORY   = 3
+    EXIT_OBJECT_NOT_FOUND   = 4
+    EXIT_AMBIGUOUS_REVISION = 5
+    EXIT_DIRTY_WORK_TREE    = 6
+    EXIT_CONFLICT           = 7
+    EXIT_CORRUPT_OBJECT     = 8
+    EXIT_LOCKED             = 9
+    EXIT_REF_CHANGED        = 10
+    EXIT_DANGLING           = 11
+    EXIT_REJECTED           = 12
+    EXIT_UNSAFE_PATH        = 13
+    EXIT_NOT_FAST_FORWARD   = 14
+)
+
+var errUsage = errors.New("usage error")
+
+func exitCode(err error) (int) {
+    switch {
+    case err == nil:
+        return EXIT_OK
+    case errors.Is(err, errUsage):
+        return EXIT_USAGE
+    case errors.Is(err, core.ErrNotARepository):
+        return EXIT_NOT_A_REPOSITORY
+    case errors.Is(err, core.ErrObjectNotFound):
+        return EXIT_OBJECT_NOT_FOUND
+    case errors.Is(err, core.ErrAmbiguousRevision):
+        return EXIT_AMBIGUOUS_REVISION
+    case errors.Is(err, core.ErrDirtyWorkTree):
+        return EXIT_DIRTY_WORK_TREE
+    case errors.Is(err, core.ErrConflict):
+        return EXIT_CONFLICT
+    case errors.Is(err, core.ErrCorruptObject):
+        return EXIT_CORRUPT_OBJECT
+    case errors.Is(err, core.ErrLocked):
+        return EXIT_LOCKED
+    case errors.Is(err, core.ErrRefChanged):
+        return EXIT_REF_CHANGED
+    case errors.Is(err, core.ErrDangling):
+        return EXIT_DANGLING
+    case errors.Is(err, core.ErrRejected):
+        return EXIT_REJECTED
+    case errors.Is(err, core.ErrUnsafePath):
+        return EXIT_UNSAFE_PATH
+    case errors.Is(err, core.ErrNotFastForward):
+        return EXIT_NOT_FAST_FORWARD
+    }
+    return EXIT_FAILURE
+}
+
 func printGray(str string, bold bool) {
     if bold {
         fmt.Printf("\033[1m")
@@ -46,13 +107,22 @@ func printError(str string) {
 }
 
 func printErr(err error) {
-    // TODO: make this more simple by eliminating new random line problems
-    errStrings := strings.Split(string(err.Error()), "\n")
+    // one line per wrapped layer, the root cause ends up last
     trimmed := []string{}
-    for _, er := range errStrings {
+    for e := err; e != nil; e = errors.Unwrap(e) {
+        er := e.Error()
+        inner := errors.Unwrap(e)
+        if inner != nil && strings.HasSuffix(er, inner.Error()) {
+            er = strings.TrimSuffix(strings.TrimSuffix(er, inner.Error()), ": ")
+        } else {
+            // cause is not a suffix, so this message already says it all
+            e = nil
+        }
         if strings.TrimSpace(er) != "" {
             trimmed = append(trimmed, er)
-            // trimmed = append(trimmed, strings.TrimSpace(er))
+        }
+        if e == nil {
+            break
         }
     }
     for i, er := range trimmed {
@@ -166,6 +236,150 @@ func getFile(reader *bufio.Reader) (string) {
 }
 
 
+func getMessage(reader *bufio.Reader) (string) {
+    printGray("Message: ", false)
+    fmt.Print(MAKE_GREEN)
+    message, _ := reader.ReadString('\n')
+    fmt.Print(CLEAR_COLOR)
+    return message
+}
+
+// getConfirmation asks a yes/no question, anything but "y" or "yes" is no
+func getConfirmation(reader *bufio.Reader, prompt string) (bool) {
+    printGray(prompt + " [y/N] ", false)
+    fmt.Print(MAKE_GREEN)
+    answer, _ := reader.ReadString('\n')
+    fmt.Print(CLEAR_COLOR)
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return answer == "y" || answer == "yes"
+}
+
+// blame [-L <start>,<end>] [--porcelain] [<rev>] <path>
+func blame(args []string) (error) {
+    start, end, porcelain := 0, 0, false
+    positional := []string{}
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--porcelain" || args[i] == "-p":
+            porcelain = true
+        case args[i] == "-L":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%w: -L needs <start>,<end>", errUsage)
+            }
+            i++
+            from, to, _ := strings.Cut(args[i], ",")
+            var err error
+            start, err = strconv.Atoi(from)
+            if err == nil && to != "" {
+                end, err = strconv.Atoi(to)
+            }
+            if err != nil || start < 1 || (to != "" && end < start) {
+                return fmt.Errorf("%w: bad line range \"%s\"", errUsage, args[i])
+            }
+        default:
+            positional = append(positional, args[i])
+        }
+    }
+    rev := "HEAD"
+    switch len(positional) {
+    case 1:
+    case 2:
+        rev, positional = positional[0], positional[1:]
+    default:
+        return fmt.Errorf("%w: blame needs a path and at most one revision", errUsage)
+    }
+    lines, err := core.BlameFile(rev, positional[0], start, end)
+    if err != nil {
+        return err
+    }
+    if porcelain {
+        printBlamePorcelain(lines)
+    } else {
+        printBlame(lines)
+    }
+    return nil
+}
+
+// printBlame shows each line behind its commit, author and date
+func printBlame(lines []models.BlameLine) {
+    renamed := false
+    width := 0
+    for _, line := range lines {
+        renamed = renamed || line.Path != lines[0].Path
+        width = max(width, len(strconv.Itoa(line.FinalLine)))
+    }
+    for _, line := range lines {
+        author, _, _ := strings.Cut(line.Author, " <")
+        date, _, _ := strings.Cut(line.Timestamp, "T")
+        fmt.Print(MAKE_YELLOW + line.Hash[:8] + CLEAR_COLOR + " ")
+        if renamed {
+            fmt.Print(MAKE_BLUE + line.Path + CLEAR_COLOR + " ")
+        }
+        fmt.Printf("%s(%-16.16s %s %*d)%s ", MAKE_MEDIUM_GRAY, author, date, width, line.FinalLine, CLEAR_COLOR)
+        fmt.Print(strings.TrimSuffix(line.Content, "\n") + "\n")
+    }
+}
+
+// printBlamePorcelain is one block per line for scripts to read:
+// "<hash> <line in that commit> <line now>", author, author-time and
+// filename lines, then the content after a tab
+func printBlamePorcelain(lines []models.BlameLine) {
+    for _, line := range lines {
+        fmt.Printf("%s %d %d\n", line.Hash, line.OrigLine, line.FinalLine)
+        fmt.Printf("author %s\n", line.Author)
+        fmt.Printf("author-time %s\n", line.Timestamp)
+        fmt.Printf("filename %s\n", line.Path)
+        fmt.Printf("\t%s\n", strings.TrimSuffix(line.Content, "\n"))
+    }
+}
+// log [--graph] [--all] [<revision>...]
+func log(args []string) (error) {
+    graph := false
+    revs := []string{}
+    for _, arg := range args {
+        if arg == "--graph" {
+            graph = true
+        } else {
+            revs = append(revs, arg)
+        }
+    }
+    if !graph {
+        return core.Log(revs)
+    }
+    rows, err := core.LogGraph(revs)
+    if err != nil {
+        return err
+    }
+    printGraph(rows)
+    return nil
+}
+
+// printGraph draws each row's lanes in their colors, then the commit's
+// short hash, the refs on it and its subject
+func printGraph(rows []models.GraphRow) {
+    for _, row := range rows {
+        line := ""
+        for _, cell := range row.Cells {
+            if cell.Lane < 0 {
+                line += cell.Symbol
+                continue
+            }
+            line += LANE_COLORS[cell.Lane % len(LANE_COLORS)] + cell.Symbol + CLEAR_COLOR
+        }
+        if row.Commit.Hash == "" {
+            fmt.Println(line)
+            continue
+        }
+        line += " " + MAKE_YELLOW + row.Commit.Hash[:8] + CLEAR_COLOR
+        if len(row.Refs) > 0 {
+            line += " (" + MAKE_GREEN + strings.Join(row.Refs, CLEAR_COLOR + ", " + MAKE_GREEN) + CLEAR_COLOR + ")"
+        }
+        subject, _, _ := strings.Cut(row.Commit.Message, "\n")
+        fmt.Println(line + " " + subject)
+    }
+}
+
+
 func printHelp() {
     printGray("valid commands:\n", true)
     printGray("  i   init\tInit cwd as a repository\n", false)
@@ -176,13 +390,155 @@ func printHelp() {
     printGray("  s   status\tCheck repository status\n", false)
     printGray("  d   diff\tIdentify changes\n", false)
     printGray("  t   tag\tTag this commit with version\n", false)
-    printGray("  c   commit\tSend code to remote\n", false)
-    printGray("  l   log\tShow history log\n", false)
-    printGray("  f   flush\tDelete all goverse files\n", false)
+    printGray("  b   branch\tList, create or delete branches, -r lists remote-tracking ones\n", false)
+    printGray("  c   commit\tRecord the staged changes as a new commit\n", false)
+    printGray("  l   log\tShow history log, takes revisions and A..B ranges, --all, --graph\n", false)
+    printGray("  r   reflog\tShow where HEAD or a branch has been, \"expire\" prunes it\n", false)
+    printGray("      reset\tMove the branch, --soft, --mixed or --hard, or unstage paths\n", false)
+    printGray("      cherry-pick\tApply commits or A..B ranges onto HEAD, --continue, --skip or --abort\n", false)
+    printGray("      revert\tCommit the inverse of commits, --continue, --skip or --abort\n", false)
+    printGray("      rebase\tReplay the branch onto another commit, --onto, --todo <file>,\n", false)
+    printGray("          \t--continue, --skip or --abort\n", false)
+    printGray("      blame\tShow the commit that last changed each line, -L <start>,<end>, --porcelain\n", false)
+    printGray("      stash\tSave uncommitted work, then list, show, apply, pop, drop or clear it\n", false)
+    printGray("      remote\tList remotes, -v with urls, \"add <name> <url>\" or \"remove <name>\"\n", false)
+    printGray("      serve\tShare the repository over http for fetch, push and clone, --addr <host:port>,\n", false)
+    printGray("          \t--public to listen beyond loopback, nothing is authenticated\n", false)
+    printGray("      upload-pack\tSend objects over stdin/stdout to a fetching client, for ssh\n", false)
+    printGray("      receive-pack\tTake pushed objects over stdin/stdout, for ssh\n", false)
+    printGray("      clone\tCopy a repository into a new directory, <source> [<dir>], --branch <name>,\n", false)
+    printGray("          \t--depth <n>, --single-branch\n", false)
+    printGray("      fetch\tCopy a remote's branches and tags, [<remote>] [<branch>...], --prune\n", false)
+    printGray("      push\tSend branches to a remote, [<remote>] [<src>[:<dst>]...], --force, --tags, -u\n", false)
+    printGray("      pull\tFetch the upstream and integrate it, --ff-only, --merge, --rebase, --continue, --abort\n", false)
+    printGray("      fast-export\tWrite branches and tags as a git fast-import stream, [--marks <file>] [<ref>...]\n", false)
+    printGray("      fast-import\tRead a git fast-export stream, [--marks <file>] [--force] [<file>]\n", false)
+    printGray("      archive\tWrite a tree as tar, tar.gz or zip, [--format <fmt>] [--prefix <dir>/] [-o <file>] <rev> [<path>...]\n", false)
+    printGray("      bundle\tcreate <file> <ref|A..B|^rev|--all>..., verify <file>, list-heads <file>\n", false)
+    printGray("      format-patch\tWrite commits as mails, [-o <dir>] [--stdout] <-n|A..B|rev>\n", false)
+    printGray("      am\tCommit the patches in mails with their author and date, [--fuzz <n>] <mbox>...\n", false)
+    printGray("      apply\tApply a patch to the work tree, [--check] [--cached|--index] [--fuzz <n>] [-p<n>] <patch>...\n", false)
+    printGray("      gc\t\tDelete unreachable objects, --dry-run, --prune=<age>, --repack\n", false)
+    printGray("      fsck\tVerify every object, link and ref\n", false)
+    printGray("      config\tList, get or set repository settings\n", false)
+    printGray("      rev-parse\tPrint the hash a revision names\n", false)
+    printGray("      show\tShow a commit with its patch, a tree, a blob or a tag, <rev>:<path> names files\n", false)
+    printGray("      cat-object\tPrint an object's kind -t, size -s or content -p\n", false)
+    printGray("  f   flush\tDelete all goverse files after confirming, --force, --backup=<file>,\n", false)
+    printGray("          \tor only the --index, --reflogs or unreachable --objects\n", false)
     printGray("  h   help\tDisplay this message\n", false)
     printGray("  q   quit\tTerminate this interactive application\n", false)
 }
 
+// runCommand executes one command line, quit is true when the session should end
+func runCommand(reader *bufio.Reader, cmd string, args []string) (bool, error) {
+    switch(cmd) {
+    case "i", "init":
+        return false, core.InitGoverse()
+    case "p", "print":
+        printGoverse(core.BaseDir, 0, []bool{true}, true, false)
+    case "pg":
+        printGoverse(core.BaseDir + core.GOVERSE_DIR, 0, []bool{true}, true, false)
+    case "pr":
+        printGoverse(core.BaseDir, 0, []bool{false}, false, false)
+    case "a", "add":
+        if len(args) > 0 {
+            return false, core.Add(args[0])
+        }
+        return false, core.Add(getFile(reader))
+    case "s", "status":
+        return false, core.Status()
+    case "d", "diff":
+    case "t", "tag":
+        return false, core.Tag(args)
+    case "b", "branch":
+        return false, core.Branch(args)
+    case "c", "commit":
+        if len(args) > 0 {
+            return false, core.Commit(strings.Join(args, " "))
+        }
+        return false, core.Commit(getMessage(reader))
+    case "l", "log":
+        return false, log(args)
+    case "reset":
+        return false, core.Reset(args)
+    case "cherry-pick":
+        return false, core.CherryPick(args)
+    case "revert":
+        return false, core.Revert(args)
+    case "rebase":
+        return false, core.Rebase(args)
+    case "blame":
+        return false, blame(args)
+    case "stash":
+        return false, core.Stash(args)
+    case "r", "reflog":
+        return false, core.Reflog(args)
+    case "gc":
+        return false, core.Gc(args)
+    case "fsck":
+        return false, core.Fsck(args)
+    case "config":
+        return false, core.Config(args)
+    case "show":
+        return false, core.Show(args)
+    case "cat-object":
+        if len(args) != 2 || (args[0] != "-t" && args[0] != "-s" && args[0] != "-p") {
+            return false, fmt.Errorf("%w: cat-object needs -t, -s or -p and an object", errUsage)
+        }
+        return false, core.CatObject(args[0], args[1])
+    case "remote":
+        return false, core.Remote(args)
+    case "upload-pack":
+        return false, core.UploadPack()
+    case "receive-pack":
+        return false, core.ReceivePack()
+    case "serve":
+        return false, core.Serve(args)
+    case "clone":
+        return false, core.Clone(args)
+    case "fetch":
+        return false, core.Fetch(args)
+    case "push":
+        return false, core.Push(args)
+    case "pull":
+        return false, core.Pull(args)
+    case "fast-export":
+        return false, core.FastExport(args)
+    case "fast-import":
+        return false, core.FastImport(args)
+    case "archive":
+        return false, core.Archive(args)
+    case "bundle":
+        return false, core.Bundle(args)
+    case "format-patch":
+        return false, core.FormatPatch(args)
+    case "am":
+        return false, core.Am(args)
+    case "apply":
+        return false, core.Apply(args)
+    case "rev-parse":
+        if len(args) == 0 {
+            return false, fmt.Errorf("%w: rev-parse needs a revision", errUsage)
+        }
+        return false, core.RevParse(args)
+    case "f", "flush":
+        return false, core.Flush(args, func(prompt string) (bool) {
+            return getConfirmation(reader, prompt)
+        })
+    case "h", "help":
+        printHelp()
+    case "q", "quit":
+        printGray("    quitting...\n", false)
+        return true, nil
+    default:
+        printError("command invalid")
+        printHelp()
+        return false, fmt.Errorf("%w: Unknown command \"%s\"", errUsage, cmd)
+    }
+    return false, nil
+}
+
 func interactive() {
     running := true
     reader := bufio.NewReader(os.Stdin)
@@ -193,48 +549,22 @@ func interactive() {
         fmt.Print(CLEAR_COLOR)
 
         fmt.Print(MAKE_GREEN)
-        cmd, _ := reader.ReadString('\n')
+        line, err := reader.ReadString('\n')
         fmt.Print(CLEAR_COLOR)
+        if err != nil && line == "" {
+            return
+        }
 
-        cmd = strings.Trim(cmd, "\n")
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            continue
+        }
 
-        switch(cmd) {
-        case "i", "init":
-            err := core.InitGoverse()
-            if err != nil {
-                printErr(err)
-            }
-        case "p", "print":
-            printGoverse(core.BaseDir, 0, []bool{true}, true, false)
-        case "pg":
-            printGoverse(core.BaseDir + core.GOVERSE_DIR, 0, []bool{true}, true, false)
-        case "pr":
-            printGoverse(core.BaseDir, 0, []bool{false}, false, false)
-        case "a", "add":
-            err := core.Add(getFile(reader))
-            if err != nil {
-                printErr(err)
-            }
-        case "s", "status":
-            err := core.Status()
-            if err != nil {
-                printErr(err)
-            }
-        case "d", "diff":
-        case "t", "tag":
-        case "c", "commit":
-        case "l", "log":
-        case "f", "flush":
-            core.Flush()
-        case "h", "help":
-            printHelp()
-        case "q", "quit":
-            printGray("    quitting...\n", false)
-            running = false
-        default:
-            printError("command invalid")
-            printHelp()
+        quit, err := runCommand(reader, fields[0], fields[1:])
+        if err != nil {
+            printErr(err)
         }
+        running = !quit
     }
 }
 
@@ -250,21 +580,18 @@ var exit bool
 func parseArgs(args []string) ([]string, error) {
     exit = args[0] == "./goverse"
     if len(args) < 2 {
-        return args, errors.New("usage error: Not enough arguments")
-    } 
-    if len(args) > 2 {
-        return args, errors.New("usage error: Too many arguments")
+        return args, fmt.Errorf("%w: Not enough arguments", errUsage)
     }
     if args[1][len(args[1])-1] != '/' {
         entry, err := os.Stat(args[1])
         if err != nil {
-            return args, errors.New("usage error: First argument is not a valid directory")
+            return args, fmt.Errorf("%w: First argument is not a valid directory", errUsage)
         }
 
         if entry.IsDir() {
             args[1] = args[1] + "/"
         } else {
-            return args, errors.New("usage error: First argument is not a valid directory")
+            return args, fmt.Errorf("%w: First argument is not a valid directory", errUsage)
         }
     }
     return args, nil
@@ -274,10 +601,20 @@ func main() {
     args, err := parseArgs(os.Args)
     if err != nil {
         printErr(err)
-        if exit {os.Exit(1)}
+        if exit {os.Exit(exitCode(err))}
         return
     }
     core.BaseDir = args[1]
+
+    // goverse <dir> <command> [args...] runs a single command and exits
+    if len(args) > 2 {
+        _, err := runCommand(bufio.NewReader(os.Stdin), args[2], args[3:])
+        if err != nil {
+            printErr(err)
+        }
+        os.Exit(exitCode(err))
+    }
+
     fmt.Println("Base dir: ", core.BaseDir)
     interactive()
 
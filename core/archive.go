@@ -0,0 +1,294 @@
+package core
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bufio"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+/////////////
+// ARCHIVE //
+/////////////
+
+// Archive formats, "--format" picks one or the output file's extension does
+const (
+    FORMAT_TAR    = "tar"
+    FORMAT_TAR_GZ = "tar.gz"
+    FORMAT_ZIP    = "zip"
+)
+
+// extensions that name an archive format, longest first
+var ARCHIVE_EXTENSIONS = [][2]string{
+    {".tar.gz", FORMAT_TAR_GZ},
+    {".tgz", FORMAT_TAR_GZ},
+    {".tar", FORMAT_TAR},
+    {".zip", FORMAT_ZIP},
+}
+
+// archiveEntry is one file going into an archive, its blob is only read
+// when the entry is written
+type archiveEntry struct {
+    name string
+    mode string
+    hash string
+}
+
+// Archive writes the tree of a commit, tag or tree as a tar, tar.gz or zip
+// to stdout or to "-o <file>": "archive [--format <fmt>] [--prefix <dir>/]
+// [-o <file>] <rev> [<path>...]". Paths limit it to those files and
+// directories, prefix goes in front of every name
+func Archive(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    format, prefix, output := "", "", ""
+    positional := []string{}
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        switch {
+        case arg == "--format" || arg == "--prefix" || arg == "-o" || arg == "--output":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s needs a value", arg)
+            }
+            i++
+            switch arg {
+            case "--format":
+                format = args[i]
+            case "--prefix":
+                prefix = args[i]
+            default:
+                output = args[i]
+            }
+        case strings.HasPrefix(arg, "--format="):
+            format = strings.TrimPrefix(arg, "--format=")
+        case strings.HasPrefix(arg, "--prefix="):
+            prefix = strings.TrimPrefix(arg, "--prefix=")
+        case strings.HasPrefix(arg, "-"):
+            return fmt.Errorf("Unknown archive option \"%s\"", arg)
+        default:
+            positional = append(positional, arg)
+        }
+    }
+    if len(positional) == 0 {
+        return errors.New("Archive needs a revision")
+    }
+    if format == "" {
+        format = FORMAT_TAR
+        for _, ext := range ARCHIVE_EXTENSIONS {
+            if strings.HasSuffix(output, ext[0]) {
+                format = ext[1]
+                break
+            }
+        }
+    }
+    if format == "tgz" {
+        format = FORMAT_TAR_GZ
+    }
+    if format != FORMAT_TAR && format != FORMAT_TAR_GZ && format != FORMAT_ZIP {
+        return fmt.Errorf("Unknown archive format \"%s\", use tar, tar.gz or zip", format)
+    }
+
+    tree, modified, err := archiveTree(positional[0])
+    if err != nil {
+        return err
+    }
+    files, err := treeFiles(tree)
+    if err != nil {
+        return err
+    }
+    files, err = filterPaths(files, positional[1:])
+    if err != nil {
+        return err
+    }
+    entries := []archiveEntry{}
+    for _, entry := range filesIndex(files).Entries {
+        entries = append(entries, archiveEntry{prefix + entry.Path, entry.Mode, entry.Hash})
+    }
+
+    var out io.Writer = os.Stdout
+    var file *os.File
+    if output != "" {
+        file, err = os.Create(output)
+        if err != nil {
+            return &PathError{"create", output, err}
+        }
+        out = file
+    }
+    buffered := bufio.NewWriter(out)
+    switch format {
+    case FORMAT_ZIP:
+        err = writeZip(buffered, entries, modified)
+    case FORMAT_TAR_GZ:
+        gz := gzip.NewWriter(buffered)
+        err = writeTar(gz, entries, prefix, modified)
+        if err == nil {
+            err = gz.Close()
+        }
+    default:
+        err = writeTar(buffered, entries, prefix, modified)
+    }
+    if err == nil {
+        err = buffered.Flush()
+    }
+    if file != nil {
+        closeErr := file.Close()
+        if err == nil {
+            err = closeErr
+        }
+        // a cut short archive would pass for a whole one
+        if err != nil {
+            os.Remove(output)
+        }
+    }
+    return err
+}
+
+// archiveTree resolves rev to the tree to archive and the time its files
+// get, the commit's or tag's time and now for a bare tree
+func archiveTree(rev string) (string, time.Time, error) {
+    hash, err := resolveRevision(rev)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    kind, _, err := readObject(hash)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    if kind == TREE {
+        return hash, time.Now(), nil
+    }
+    commit, err := peelToCommit(hash)
+    if err != nil {
+        return "", time.Time{}, &RevisionError{rev, err}
+    }
+    c, err := deserializeCommit(commit)
+    if err != nil {
+        return "", time.Time{}, err
+    }
+    return c.Tree, commitTime(c), nil
+}
+
+// filterPaths keeps the files that are or sit below one of paths, failing
+// on a path that matches nothing
+func filterPaths(files map[string]models.IndexEntry, paths []string) (map[string]models.IndexEntry, error) {
+    if len(paths) == 0 {
+        return files, nil
+    }
+    kept := map[string]models.IndexEntry{}
+    for _, p := range paths {
+        p = strings.Trim(path.Clean(p), "/")
+        matched := false
+        for name, entry := range files {
+            if p == "." || name == p || strings.HasPrefix(name, p + "/") {
+                kept[name] = entry
+                matched = true
+            }
+        }
+        if !matched {
+            return nil, fmt.Errorf("Path \"%s\" is not in the tree: %w", p, ErrObjectNotFound)
+        }
+    }
+    return kept, nil
+}
+
+// archiveDirs lists every directory above the entries, parents first
+func archiveDirs(entries []archiveEntry, prefix string) ([]string) {
+    seen := map[string]bool{}
+    dirs := []string{}
+    if strings.HasSuffix(prefix, "/") {
+        seen[strings.TrimSuffix(prefix, "/")] = true
+        dirs = append(dirs, strings.TrimSuffix(prefix, "/"))
+    }
+    for _, entry := range entries {
+        for dir := path.Dir(entry.name); dir != "." && dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+            seen[dir] = true
+            dirs = append(dirs, dir)
+        }
+    }
+    sort.Strings(dirs)
+    return dirs
+}
+
+func writeTar(w io.Writer, entries []archiveEntry, prefix string, modified time.Time) (error) {
+    tw := tar.NewWriter(w)
+    for _, dir := range archiveDirs(entries, prefix) {
+        err := tw.WriteHeader(&tar.Header {
+            Typeflag: tar.TypeDir,
+            Name: dir + "/",
+            Mode: 0755,
+            ModTime: modified,
+        })
+        if err != nil {
+            return err
+        }
+    }
+    for _, entry := range entries {
+        content, err := readTypedObject(entry.hash, BLOB)
+        if err != nil {
+            return err
+        }
+        header := &tar.Header {
+            Typeflag: tar.TypeReg,
+            Name: entry.name,
+            Mode: int64(fileMode(entry.mode)),
+            Size: int64(len(content)),
+            ModTime: modified,
+        }
+        if entry.mode == SYMLINK_MODE {
+            header.Typeflag, header.Linkname = tar.TypeSymlink, string(content)
+            header.Mode, header.Size = 0777, 0
+        }
+        err = tw.WriteHeader(header)
+        if err != nil {
+            return err
+        }
+        if header.Typeflag == tar.TypeReg {
+            _, err = tw.Write(content)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return tw.Close()
+}
+
+func writeZip(w io.Writer, entries []archiveEntry, modified time.Time) (error) {
+    zw := zip.NewWriter(w)
+    for _, entry := range entries {
+        content, err := readTypedObject(entry.hash, BLOB)
+        if err != nil {
+            return err
+        }
+        header := &zip.FileHeader {
+            Name: entry.name,
+            Method: zip.Deflate,
+            Modified: modified,
+        }
+        mode := fileMode(entry.mode)
+        if entry.mode == SYMLINK_MODE {
+            mode = os.ModeSymlink | 0777
+        }
+        header.SetMode(mode)
+        fw, err := zw.CreateHeader(header)
+        if err != nil {
+            return err
+        }
+        _, err = fw.Write(content)
+        if err != nil {
+            return err
+        }
+    }
+    return zw.Close()
+}
@@ -0,0 +1,186 @@
+package core
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+// readArchive lists what an archive in format holds: "dir" for
+// directories, "-> target" for symlinks and the permission bits and
+// content for files
+func readArchive(t *testing.T, file string, format string) (map[string]string) {
+    t.Helper()
+    got := map[string]string{}
+    if format == FORMAT_ZIP {
+        zr, err := zip.OpenReader(file)
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer zr.Close()
+        for _, f := range zr.File {
+            r, err := f.Open()
+            if err != nil {
+                t.Fatal(err)
+            }
+            content, err := io.ReadAll(r)
+            r.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+            switch mode := f.Mode(); {
+            case mode & os.ModeSymlink != 0:
+                got[f.Name] = "-> " + string(content)
+            case mode.IsDir():
+                got[f.Name] = "dir"
+            default:
+                got[f.Name] = fmt.Sprintf("%o %s", mode.Perm(), content)
+            }
+        }
+        return got
+    }
+
+    f, err := os.Open(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    var r io.Reader = f
+    if format == FORMAT_TAR_GZ {
+        gz, err := gzip.NewReader(f)
+        if err != nil {
+            t.Fatal(err)
+        }
+        r = gz
+    }
+    tr := tar.NewReader(r)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        switch header.Typeflag {
+        case tar.TypeDir:
+            got[header.Name] = "dir"
+        case tar.TypeSymlink:
+            got[header.Name] = "-> " + header.Linkname
+        default:
+            content, err := io.ReadAll(tr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got[header.Name] = fmt.Sprintf("%o %s", header.Mode & 0777, content)
+        }
+    }
+    return got
+}
+
+// archiveRepo commits a file, a symlink to it and a dir holding a plain
+// and an executable file
+func archiveRepo(t *testing.T) {
+    t.Helper()
+    testRepo(t)
+    writeTestFile(t, "file", "file\n")
+    writeTestFile(t, "dir/sub", "sub\n")
+    writeTestFile(t, "dir/run.sh", "run\n")
+    err := os.Chmod(BaseDir + "dir/run.sh", 0755)
+    if err == nil {
+        err = os.Symlink("file", BaseDir + "link")
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    commitAll(t, "archive")
+}
+
+func TestArchive(t *testing.T) {
+    tests := []struct {
+        name   string
+        file   string
+        args   []string
+        format string
+        want   map[string]string
+    }{
+        {"tar", "out.tar", []string{ "HEAD" }, FORMAT_TAR, map[string]string{
+            "dir/": "dir",
+            "dir/run.sh": "755 run\n",
+            "dir/sub": "644 sub\n",
+            "file": "644 file\n",
+            "link": "-> file",
+        }},
+        {"tar.gz with a prefix", "out.tar.gz", []string{ "--prefix", "proj/", "HEAD" }, FORMAT_TAR_GZ, map[string]string{
+            "proj/": "dir",
+            "proj/dir/": "dir",
+            "proj/dir/run.sh": "755 run\n",
+            "proj/dir/sub": "644 sub\n",
+            "proj/file": "644 file\n",
+            "proj/link": "-> file",
+        }},
+        {"zip", "out.zip", []string{ "HEAD" }, FORMAT_ZIP, map[string]string{
+            "dir/run.sh": "755 run\n",
+            "dir/sub": "644 sub\n",
+            "file": "644 file\n",
+            "link": "-> file",
+        }},
+        {"paths", "out.tar", []string{ "HEAD", "dir", "link" }, FORMAT_TAR, map[string]string{
+            "dir/": "dir",
+            "dir/run.sh": "755 run\n",
+            "dir/sub": "644 sub\n",
+            "link": "-> file",
+        }},
+        {"format over extension", "out.zip", []string{ "--format", "tar", "HEAD:dir" }, FORMAT_TAR, map[string]string{
+            "run.sh": "755 run\n",
+            "sub": "644 sub\n",
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            archiveRepo(t)
+            out := filepath.Join(t.TempDir(), tt.file)
+            err := Archive(append([]string{ "-o", out }, tt.args...))
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := readArchive(t, out, tt.format); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("archive holds\n%v\nwant\n%v", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestArchiveRemovesPartialOutput(t *testing.T) {
+    archiveRepo(t)
+    // the dir entries are written before file, so the archive is cut
+    // short when its blob turns out to be missing
+    blob, _ := hashBlob(models.Blob{ Content: []byte("file\n") })
+    err := os.Remove(BaseDir + OBJECTS_DIR + blob)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{ "out.tar", "out.tar.gz", "out.zip" } {
+        out := filepath.Join(t.TempDir(), name)
+        err := Archive([]string{ "-o", out, "HEAD" })
+        if err == nil {
+            t.Fatalf("archive to %s succeeded without a blob", name)
+        }
+        mustNotExist(t, out)
+    }
+    // a path matching nothing fails before anything is created
+    out := filepath.Join(t.TempDir(), "out.tar")
+    err = Archive([]string{ "-o", out, "HEAD", "nosuch" })
+    if err == nil {
+        t.Fatal("archive of a missing path succeeded")
+    }
+    mustNotExist(t, out)
+}
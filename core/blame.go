@@ -0,0 +1,216 @@
+package core
+
+import (
+    "fmt"
+
+    "goverse/internal/models"
+)
+
+///////////
+// BLAME //
+///////////
+
+// a file deleted on one side and added on the other counts as renamed when
+// at least this share of its lines survived
+const RENAME_SIMILARITY = 0.5
+
+// a line still looking for its commit, cur is its index in the version of
+// the file being looked at and final its index in the blamed version
+type blameLine struct {
+    cur   int
+    final int
+}
+
+// a commit and the lines that may come from it or its parents
+type blameTarget struct {
+    commit models.Commit
+    path   string
+    lines  []blameLine
+}
+
+// BlameFile attributes each line of path as of rev to the commit that last
+// changed it, following the file across renames. start and end pick lines
+// counting from 1, zero means from the first or to the last line
+func BlameFile(rev string, path string, start int, end int) ([]models.BlameLine, error) {
+    err := requireRepository()
+    if err != nil {
+        return nil, err
+    }
+    rel, err := relPath(path)
+    if err != nil {
+        return nil, err
+    }
+    hash, err := resolveCommit(rev)
+    if err != nil {
+        return nil, err
+    }
+    c, err := deserializeCommit(hash)
+    if err != nil {
+        return nil, err
+    }
+    files, err := treeFiles(c.Tree)
+    if err != nil {
+        return nil, err
+    }
+    entry, ok := files[rel]
+    if !ok {
+        return nil, fmt.Errorf("\"%s\" is not in %s: %w", rel, rev, ErrObjectNotFound)
+    }
+    content, err := readTypedObject(entry.Hash, BLOB)
+    if err != nil {
+        return nil, err
+    }
+    final := splitLines(content)
+    if start <= 0 {
+        start = 1
+    }
+    if end <= 0 || end > len(final) {
+        end = len(final)
+    }
+    if start > end {
+        return nil, fmt.Errorf("Line range %d,%d is outside \"%s\", which has %d lines", start, end, rel, len(final))
+    }
+
+    result := make([]models.BlameLine, len(final))
+    target := &blameTarget{commit: c, path: rel}
+    for i := start - 1; i < end; i++ {
+        target.lines = append(target.lines, blameLine{i, i})
+    }
+    // newest commit first, so every child hands its lines down before its
+    // parents are looked at
+    queue := map[string]*blameTarget{ hash: target }
+    for len(queue) > 0 {
+        var next *blameTarget
+        for _, t := range queue {
+            if next == nil || commitTime(t.commit).After(commitTime(next.commit)) {
+                next = t
+            }
+        }
+        delete(queue, next.commit.Hash)
+        err := blameStep(next, queue, result, final)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return result[start-1:end], nil
+}
+
+// blameStep passes the lines of t that its parents already had on to them
+// and attributes the rest to t's commit
+func blameStep(t *blameTarget, queue map[string]*blameTarget, result []models.BlameLine, final []string) (error) {
+    files, err := treeFiles(t.commit.Tree)
+    if err != nil {
+        return err
+    }
+    content, err := readTypedObject(files[t.path].Hash, BLOB)
+    if err != nil {
+        return err
+    }
+    current := splitLines(content)
+
+    remaining := t.lines
+    for _, parentHash := range t.commit.Parents {
+        if len(remaining) == 0 {
+            break
+        }
+        parent, err := deserializeCommit(parentHash)
+        if err != nil {
+            return err
+        }
+        parentFiles, err := treeFiles(parent.Tree)
+        if err != nil {
+            return err
+        }
+        parentPath, err := findOrigin(t.path, content, files, parentFiles)
+        if err != nil {
+            return err
+        }
+        if parentPath == "" {
+            continue
+        }
+        parentContent, err := readTypedObject(parentFiles[parentPath].Hash, BLOB)
+        if err != nil {
+            return err
+        }
+
+        // where each current line sits in the parent's version, if anywhere
+        origin := make([]int, len(current))
+        for i := range origin {
+            origin[i] = -1
+        }
+        for i, match := range diffMatches(splitLines(parentContent), current) {
+            if match >= 0 {
+                origin[match] = i
+            }
+        }
+        passed, kept := []blameLine{}, []blameLine{}
+        for _, line := range remaining {
+            if origin[line.cur] >= 0 {
+                passed = append(passed, blameLine{origin[line.cur], line.final})
+            } else {
+                kept = append(kept, line)
+            }
+        }
+        remaining = kept
+        if len(passed) == 0 {
+            continue
+        }
+        next, ok := queue[parentHash]
+        if !ok {
+            next = &blameTarget{commit: parent, path: parentPath}
+            queue[parentHash] = next
+        }
+        next.lines = append(next.lines, passed...)
+    }
+
+    for _, line := range remaining {
+        result[line.final] = models.BlameLine {
+            Hash: t.commit.Hash,
+            Author: t.commit.Author,
+            Timestamp: t.commit.Timestamp,
+            Path: t.path,
+            OrigLine: line.cur + 1,
+            FinalLine: line.final + 1,
+            Content: final[line.final],
+        }
+    }
+    return nil
+}
+
+// findOrigin names the file in parentFiles that path in files came from:
+// the same path, or else the deleted file most like it, "" if it is new
+func findOrigin(path string, content []byte, files map[string]models.IndexEntry, parentFiles map[string]models.IndexEntry) (string, error) {
+    if _, ok := parentFiles[path]; ok {
+        return path, nil
+    }
+    lines := splitLines(content)
+    best, bestScore := "", 0.0
+    for candidate, entry := range parentFiles {
+        if _, stillThere := files[candidate]; stillThere {
+            continue
+        }
+        if entry.Hash == files[path].Hash {
+            return candidate, nil
+        }
+        candidateContent, err := readTypedObject(entry.Hash, BLOB)
+        if err != nil {
+            return "", err
+        }
+        candidateLines := splitLines(candidateContent)
+        kept := 0
+        for _, match := range diffMatches(candidateLines, lines) {
+            if match >= 0 {
+                kept++
+            }
+        }
+        size := max(len(lines), len(candidateLines))
+        if size == 0 {
+            continue
+        }
+        score := float64(kept) / float64(size)
+        if score >= RENAME_SIMILARITY && (score > bestScore || (score == bestScore && candidate < best)) {
+            best, bestScore = candidate, score
+        }
+    }
+    return best, nil
+}
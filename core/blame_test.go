@@ -0,0 +1,64 @@
+package core
+
+import (
+    "os"
+    "testing"
+)
+
+func TestBlameFile(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "a.txt", "one\ntwo\nthree\n")
+    c1 := commitAll(t, "first")
+    writeTestFile(t, "a.txt", "one\n2\nthree\nfour\n")
+    c2 := commitAll(t, "edit")
+    // renamed and edited in one commit, most lines survive so it is
+    // still followed
+    err := os.Remove(BaseDir + "a.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "b.txt", "zero\none\n2\nthree\nfour\n")
+    c3 := commitAll(t, "rename")
+    writeTestFile(t, "b.txt", "zero\none\n2\n3\nfour\n")
+    c4 := commitAll(t, "edit after rename")
+
+    tests := []struct {
+        hash    string
+        path    string
+        orig    int
+        content string
+    }{
+        {c3, "b.txt", 1, "zero\n"},
+        {c1, "a.txt", 1, "one\n"},
+        {c2, "a.txt", 2, "2\n"},
+        {c4, "b.txt", 4, "3\n"},
+        {c2, "a.txt", 4, "four\n"},
+    }
+    lines, err := BlameFile("HEAD", "b.txt", 0, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(lines) != len(tests) {
+        t.Fatalf("blame has %d lines, want %d", len(lines), len(tests))
+    }
+    for i, tt := range tests {
+        got := lines[i]
+        if got.Hash != tt.hash || got.Path != tt.path || got.OrigLine != tt.orig || got.FinalLine != i + 1 || got.Content != tt.content {
+            t.Errorf("line %d = %s %s:%d %q, want %s %s:%d %q", i + 1, got.Hash, got.Path, got.OrigLine, got.Content, tt.hash, tt.path, tt.orig, tt.content)
+        }
+    }
+
+    // a range and an older revision
+    lines, err = BlameFile(c2, "a.txt", 2, 3)
+    if err != nil || len(lines) != 2 || lines[0].Hash != c2 || lines[1].Hash != c1 || lines[1].FinalLine != 3 {
+        t.Errorf("blame -L 2,3 %s = %+v, %v", c2, lines, err)
+    }
+    _, err = BlameFile("HEAD", "b.txt", 4, 9)
+    if err != nil {
+        t.Errorf("a range past the end = %v, want it cut short", err)
+    }
+    _, err = BlameFile("HEAD", "a.txt", 0, 0)
+    if err == nil {
+        t.Error("blame of a file not in HEAD succeeded")
+    }
+}
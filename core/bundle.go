@@ -0,0 +1,386 @@
+package core
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strings"
+)
+
+////////////
+// BUNDLE //
+////////////
+
+// A bundle is a fetch written to a file, for carrying history to machines
+// no transport reaches:
+//
+//     # goverse bundle v1
+//     -<hash> <subject>     a commit the receiver must already have, one
+//                           per line, when history below it was left out
+//     <ref> <value>         the refs, as in a ref advertisement, then an
+//                           empty line
+//     <pack>                every object the refs need beyond the
+//                           prerequisites
+//
+// A bundle file works anywhere a remote url does, so it can be cloned and
+// fetched from
+
+const BUNDLE_SIGNATURE = "# goverse bundle v1"
+
+// readBundleHeader reads the signature, prerequisites and refs, leaving r
+// at the pack
+func readBundleHeader(r *bufio.Reader, file string) ([]string, map[string]string, error) {
+    signature, err := r.ReadString('\n')
+    if err != nil || strings.TrimSuffix(signature, "\n") != BUNDLE_SIGNATURE {
+        return nil, nil, &PathError{"read bundle", file, fmt.Errorf("not a goverse bundle: %w", ErrCorruptObject)}
+    }
+    prerequisites := []string{}
+    for {
+        next, err := r.Peek(1)
+        if err != nil || next[0] != '-' {
+            break
+        }
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return nil, nil, &PathError{"read bundle", file, err}
+        }
+        hash, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSuffix(line, "\n"), "-"), " ")
+        if !isHex(hash) {
+            return nil, nil, &PathError{"read bundle", file, fmt.Errorf("bad prerequisite \"%s\": %w", strings.TrimSpace(line), ErrCorruptObject)}
+        }
+        prerequisites = append(prerequisites, hash)
+    }
+    refs, err := readRefs(r)
+    if err != nil {
+        return nil, nil, &PathError{"read bundle", file, err}
+    }
+    return prerequisites, refs, nil
+}
+
+// missingPrerequisites lists the prerequisites this repository lacks
+func missingPrerequisites(prerequisites []string) ([]string) {
+    missing := []string{}
+    for _, hash := range prerequisites {
+        if !objectExists(hash) {
+            missing = append(missing, hash)
+        }
+    }
+    return missing
+}
+
+// isBundle says whether path is a bundle file rather than a repository
+func isBundle(path string) (bool) {
+    f, err := os.Open(path)
+    if err != nil {
+        return false
+    }
+    defer f.Close()
+    signature := make([]byte, len(BUNDLE_SIGNATURE))
+    _, err = io.ReadFull(f, signature)
+    return err == nil && string(signature) == BUNDLE_SIGNATURE
+}
+
+// Bundle works with bundle files: "create <file> <ref|A..B|^rev|--all>...",
+// "verify <file>" and "list-heads <file>"
+func Bundle(args []string) (error) {
+    if len(args) < 2 {
+        return errors.New("Bundle needs create, verify or list-heads and a file")
+    }
+    switch args[0] {
+    case "create":
+        return bundleCreate(args[1], args[2:])
+    case "verify":
+        return bundleVerify(args[1])
+    case "list-heads":
+        return bundleListHeads(args[1])
+    }
+    return fmt.Errorf("Unknown bundle command \"%s\"", args[0])
+}
+
+// bundleCreate writes the refs named by revs to file along with the
+// objects they need, leaving out history reachable from "^rev" or the left
+// side of "A..B"
+func bundleCreate(file string, revs []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(revs) == 0 {
+        return errors.New("Name the refs to bundle, or --all")
+    }
+    refs := map[string]string{}
+    exclude := []string{}
+    addRef := func(name string) (error) {
+        ref, err := resolveRefName(name)
+        if err != nil {
+            return err
+        }
+        if ref == HEAD_REF {
+            target, err := headTarget()
+            if err != nil {
+                return err
+            }
+            if target != "" {
+                ref = target
+            }
+        }
+        value, err := resolveRef(ref)
+        if err != nil {
+            return err
+        }
+        if value == "" {
+            return fmt.Errorf("\"%s\" has no commits yet", name)
+        }
+        refs[ref] = value
+        return nil
+    }
+    for _, rev := range revs {
+        switch {
+        case rev == "--all":
+            for _, prefix := range []string{ BRANCHES_PREFIX, TAGS_PREFIX } {
+                found, err := listRefs(prefix)
+                if err != nil {
+                    return err
+                }
+                for _, ref := range found {
+                    err = addRef(ref)
+                    if err != nil {
+                        return err
+                    }
+                }
+            }
+        case strings.HasPrefix(rev, "^"):
+            hash, err := resolveCommit(strings.TrimPrefix(rev, "^"))
+            if err != nil {
+                return err
+            }
+            exclude = append(exclude, hash)
+        case strings.Contains(rev, ".."):
+            from, to, _ := strings.Cut(rev, "..")
+            if strings.HasPrefix(to, ".") {
+                return fmt.Errorf("\"%s\": bundles take A..B ranges only", rev)
+            }
+            hash, err := resolveCommit(orHead(from))
+            if err != nil {
+                return err
+            }
+            exclude = append(exclude, hash)
+            err = addRef(orHead(to))
+            if err != nil {
+                return err
+            }
+        default:
+            err = addRef(rev)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    if len(refs) == 0 {
+        return errors.New("Refusing to create an empty bundle")
+    }
+    // the receiver checks out what HEAD follows when cloning
+    if target, err := headTarget(); err == nil && refs[target] != "" {
+        refs[HEAD_REF] = SYMREF_PREFIX + target
+    }
+
+    wants, tips := []string{}, []string{}
+    for ref, value := range refs {
+        if ref == HEAD_REF {
+            continue
+        }
+        wants = append(wants, value)
+        tip, err := peelToCommit(value)
+        if err != nil {
+            return err
+        }
+        tips = append(tips, tip)
+    }
+    commits, err := revList(tips, exclude)
+    if err != nil {
+        return err
+    }
+    included := map[string]bool{}
+    for _, c := range commits {
+        included[c.Hash] = true
+    }
+    prerequisites := map[string]bool{}
+    for _, c := range commits {
+        for _, parent := range c.Parents {
+            if !included[parent] {
+                prerequisites[parent] = true
+            }
+        }
+    }
+    hashes, err := objectsBetween(wants, exclude)
+    if err != nil {
+        return err
+    }
+
+    f, err := os.Create(file + ".tmp")
+    if err != nil {
+        return &PathError{"create", file, err}
+    }
+    w := bufio.NewWriter(f)
+    fmt.Fprintln(w, BUNDLE_SIGNATURE)
+    sorted := []string{}
+    for hash := range prerequisites {
+        sorted = append(sorted, hash)
+    }
+    sort.Strings(sorted)
+    for _, hash := range sorted {
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            f.Close()
+            os.Remove(file + ".tmp")
+            return err
+        }
+        fmt.Fprintf(w, "-%s %s\n", hash, firstLine(c.Message))
+    }
+    err = writeRefs(w, refs)
+    if err == nil {
+        err = writePack(w, hashes)
+    }
+    if err == nil {
+        err = w.Flush()
+    }
+    if closeErr := f.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(file + ".tmp", file)
+    }
+    if err != nil {
+        os.Remove(file + ".tmp")
+        return &PathError{"write bundle", file, err}
+    }
+    fmt.Printf("Bundled %s and %s into %s\n", plural(len(refs), "ref"), plural(len(hashes), "object"), file)
+    return nil
+}
+
+// bundleVerify checks the pack of a bundle is whole and, for a bundle
+// that needs prerequisites, that this repository has them
+func bundleVerify(file string) (error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return &PathError{"open", file, err}
+    }
+    defer f.Close()
+    r := bufio.NewReader(f)
+    prerequisites, refs, err := readBundleHeader(r, file)
+    if err != nil {
+        return err
+    }
+    hashes, err := verifyPack(r)
+    if err != nil {
+        return err
+    }
+    packed := map[string]bool{}
+    for _, hash := range hashes {
+        packed[hash] = true
+    }
+    for ref, value := range refs {
+        if ref != HEAD_REF && !packed[value] && !objectExists(value) {
+            return fmt.Errorf("Bundle points %s at %s, which it does not hold: %w", ref, truncHash(value), ErrCorruptObject)
+        }
+    }
+
+    fmt.Printf("The bundle contains %s and %s\n", plural(len(refs), "ref"), plural(len(hashes), "object"))
+    if len(prerequisites) == 0 {
+        fmt.Println("The bundle records a complete history")
+    } else {
+        err = requireRepository()
+        if err != nil {
+            return fmt.Errorf("The bundle needs %s from a repository: %w", plural(len(prerequisites), "commit"), err)
+        }
+        fmt.Printf("The bundle requires %s\n", plural(len(prerequisites), "commit"))
+        missing := missingPrerequisites(prerequisites)
+        if len(missing) > 0 {
+            for _, hash := range missing {
+                fmt.Println("  missing " + hash)
+            }
+            return fmt.Errorf("This repository lacks %s the bundle builds on: %w", plural(len(missing), "commit"), ErrObjectNotFound)
+        }
+    }
+    fmt.Println(file + " is okay")
+    return nil
+}
+
+// bundleListHeads prints the refs a bundle holds
+func bundleListHeads(file string) (error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return &PathError{"open", file, err}
+    }
+    defer f.Close()
+    _, refs, err := readBundleHeader(bufio.NewReader(f), file)
+    if err != nil {
+        return err
+    }
+    names := []string{}
+    for ref := range refs {
+        names = append(names, ref)
+    }
+    sort.Strings(names)
+    for _, ref := range names {
+        fmt.Printf("%s %s\n", refs[ref], ref)
+    }
+    return nil
+}
+
+//////////////////////
+// BUNDLE TRANSPORT //
+//////////////////////
+
+type bundleTransport struct {
+    file string
+}
+
+// open reads the bundle's header, leaving the reader at its pack
+func (t *bundleTransport) open() (*os.File, *bufio.Reader, []string, map[string]string, error) {
+    f, err := os.Open(t.file)
+    if err != nil {
+        return nil, nil, nil, nil, &PathError{"open", t.file, err}
+    }
+    r := bufio.NewReader(f)
+    prerequisites, refs, err := readBundleHeader(r, t.file)
+    if err != nil {
+        f.Close()
+        return nil, nil, nil, nil, err
+    }
+    return f, r, prerequisites, refs, nil
+}
+
+func (t *bundleTransport) advertise() (map[string]string, error) {
+    f, _, _, refs, err := t.open()
+    if err != nil {
+        return nil, err
+    }
+    f.Close()
+    return refs, nil
+}
+
+// fetchPack stores the whole pack, a bundle cannot be asked for less so a
+// depth is refused rather than ignored
+func (t *bundleTransport) fetchPack(wants []string, haves []string, depth int) ([]string, error) {
+    if depth > 0 {
+        return nil, fmt.Errorf("Unable to fetch a depth from bundle %s, it holds all of its history", t.file)
+    }
+    f, r, prerequisites, _, err := t.open()
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    missing := missingPrerequisites(prerequisites)
+    if len(missing) > 0 {
+        return nil, fmt.Errorf("The bundle builds on %s this repository lacks, such as %s: %w", plural(len(missing), "commit"), truncHash(missing[0]), ErrObjectNotFound)
+    }
+    return readPack(r)
+}
+
+func (t *bundleTransport) sendPack(updates []refUpdate, hashes []string) ([]string, error) {
+    return nil, fmt.Errorf("Unable to push to bundle %s, create a new one instead", t.file)
+}
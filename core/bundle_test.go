@@ -0,0 +1,54 @@
+package core
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+func TestCloneFromBundle(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    head := commitAll(t, "two")
+    out := t.TempDir()
+    bundle := filepath.Join(out, "repo.bundle")
+    err := Bundle([]string{ "create", bundle, "--all" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name string
+        args []string
+        ok   bool
+    }{
+        {"whole history", nil, true},
+        {"one branch", []string{ "--branch", DEFAULT_BRANCH }, true},
+        // a bundle cannot be asked for less, so a depth is refused
+        {"depth", []string{ "--depth", "1" }, false},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dest := filepath.Join(out, "clone", string(rune('a' + i)))
+            err := Clone(append(append([]string{}, tt.args...), bundle, dest))
+            if (err == nil) != tt.ok {
+                t.Fatalf("clone %v = %v, want ok %v", tt.args, err, tt.ok)
+            }
+            if !tt.ok {
+                mustNotExist(t, dest)
+                return
+            }
+            err = inRepository(dest + "/", func() (error) {
+                got, err := getHead()
+                if err == nil && got != head {
+                    t.Errorf("clone is at %s, want %s", got, head)
+                }
+                return err
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+        })
+    }
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+///////////
+// CLONE //
+///////////
+
+// A shallow repository holds only the newest commits of its history.
+// .goverse/shallow lists the commits whose parents were left behind, one
+// hash per line, and those commits are read as if they had no parents
+
+const SHALLOW_FILE = GOVERSE_DIR + "shallow"
+
+// the shallow commits of the repository at shallowBase
+var shallowSet map[string]bool
+var shallowBase string
+
+// shallowCommits returns the commits history stops at, loaded once per
+// repository
+func shallowCommits() (map[string]bool) {
+    if shallowSet != nil && shallowBase == BaseDir {
+        return shallowSet
+    }
+    shallowSet, shallowBase = map[string]bool{}, BaseDir
+    content, err := os.ReadFile(BaseDir + SHALLOW_FILE)
+    if err != nil {
+        return shallowSet
+    }
+    for _, hash := range strings.Fields(string(content)) {
+        shallowSet[hash] = true
+    }
+    return shallowSet
+}
+
+// markShallow records every commit in hashes with a parent that is not
+// here, which after a depth-limited fetch is where history was cut off
+func markShallow(hashes []string) (error) {
+    shallow := map[string]bool{}
+    for hash := range shallowCommits() {
+        shallow[hash] = true
+    }
+    added := false
+    for _, hash := range hashes {
+        kind, _, err := readObject(hash)
+        if err != nil || kind != COMMIT || shallow[hash] {
+            continue
+        }
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return err
+        }
+        for _, parent := range c.Parents {
+            if !objectExists(parent) {
+                shallow[hash], added = true, true
+                break
+            }
+        }
+    }
+    if !added {
+        return nil
+    }
+    lines := []string{}
+    for hash := range shallow {
+        lines = append(lines, hash)
+    }
+    sort.Strings(lines)
+    lock, err := acquireLock(BaseDir + SHALLOW_FILE, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return err
+    }
+    shallowSet = nil
+    return lock.commit([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+// Clone copies the repository at source into a new directory, dir or one
+// named after source, with source as its origin remote and its default
+// branch (or the one given with "--branch <name>") checked out.
+// "--depth <n>" only fetches the newest n commits of each branch and
+// "--single-branch" only the branch being checked out
+func Clone(args []string) (error) {
+    depth, branch, single := 0, "", false
+    positional := []string{}
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        switch {
+        case arg == "--depth" || arg == "--branch" || arg == "-b":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s needs a value", arg)
+            }
+            i++
+            if arg != "--depth" {
+                branch = args[i]
+                continue
+            }
+            n, err := strconv.Atoi(args[i])
+            if err != nil || n < 1 {
+                return fmt.Errorf("--depth needs a positive number, not \"%s\"", args[i])
+            }
+            depth = n
+        case arg == "--single-branch":
+            single = true
+        case strings.HasPrefix(arg, "-"):
+            return fmt.Errorf("Unknown clone option \"%s\"", arg)
+        default:
+            positional = append(positional, arg)
+        }
+    }
+    if len(positional) == 0 || len(positional) > 2 {
+        return errors.New("Clone needs a source and at most one directory")
+    }
+
+    url, err := normalizeURL(positional[0])
+    if err != nil {
+        return err
+    }
+    dir := ""
+    if len(positional) == 2 {
+        dir = positional[1]
+    } else {
+        // the last part of the path, or the host for a server's root
+        _, path, found := strings.Cut(url, "://")
+        if !found {
+            _, path, _ = strings.Cut(url, ":")
+        }
+        dir = strings.TrimSuffix(filepath.Base(strings.TrimRight(path, "/")), ".bundle")
+        if dir == "." || dir == "/" {
+            return fmt.Errorf("Unable to name a directory after \"%s\", give one", url)
+        }
+    }
+    if !filepath.IsAbs(dir) {
+        dir = filepath.Join(BaseDir, dir)
+    }
+    entries, err := os.ReadDir(dir)
+    if err == nil && len(entries) > 0 {
+        return fmt.Errorf("\"%s\" already exists and is not empty", dir)
+    }
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return &PathError{"read dir", dir, err}
+    }
+    created := err != nil
+    err = os.MkdirAll(dir, 0755)
+    if err != nil {
+        return &PathError{"create dir", dir, err}
+    }
+
+    fmt.Printf("Cloning into '%s'...\n", dir)
+    err = inRepository(dir + "/", func() (error) {
+        return cloneInto(url, branch, single, depth)
+    })
+    if err != nil {
+        // leave nothing half cloned behind
+        if created {
+            os.RemoveAll(dir)
+        } else {
+            os.RemoveAll(filepath.Join(dir, GOVERSE))
+        }
+        return err
+    }
+    return nil
+}
+
+// cloneInto fills the empty repository BaseDir from url
+func cloneInto(url string, branch string, single bool, depth int) (error) {
+    err := initRepository()
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+    err = setConfig("remote." + DEFAULT_REMOTE + ".url", url)
+    if err != nil {
+        return err
+    }
+
+    t, err := openTransport(url)
+    if err != nil {
+        return err
+    }
+    refs, err := t.advertise()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        branch = defaultBranch(refs)
+    }
+    if branch != "" && refs[BRANCHES_PREFIX + branch] == "" {
+        return fmt.Errorf("Remote has no branch \"%s\": %w", branch, ErrObjectNotFound)
+    }
+    branches := []string{}
+    if single && branch != "" {
+        branches = []string{ branch }
+        err = setConfig("remote." + DEFAULT_REMOTE + ".branches", branch)
+        if err != nil {
+            return err
+        }
+    }
+    err = fetchRemote(DEFAULT_REMOTE, branches, false, depth)
+    if err != nil {
+        return err
+    }
+
+    if branch == "" {
+        fmt.Println("warning: You appear to have cloned an empty repository")
+        return nil
+    }
+    err = setUpstream(branch, DEFAULT_REMOTE, branch)
+    if err != nil {
+        return err
+    }
+    ref := BRANCHES_PREFIX + branch
+    err = setHeadRef(ref)
+    if err != nil {
+        return err
+    }
+    hash := refs[ref]
+    err = setHead(hash, "", REASON_CLONE, "from " + url)
+    if err != nil {
+        return err
+    }
+    files, err := commitFiles(hash)
+    if err != nil {
+        return err
+    }
+    err = checkoutFiles(nil, files, false)
+    if err != nil {
+        return err
+    }
+    return writeIndex(filesIndex(files))
+}
+
+// defaultBranch is the branch the remote has checked out, or failing that
+// main or its first branch, "" when it has none
+func defaultBranch(refs map[string]string) (string) {
+    target := strings.TrimPrefix(refs[HEAD_REF], SYMREF_PREFIX)
+    if target != refs[HEAD_REF] && refs[target] != "" {
+        return shortRef(target)
+    }
+    if refs[BRANCHES_PREFIX + DEFAULT_BRANCH] != "" {
+        return DEFAULT_BRANCH
+    }
+    names := []string{}
+    for ref := range refs {
+        if strings.HasPrefix(ref, BRANCHES_PREFIX) {
+            names = append(names, ref)
+        }
+    }
+    if len(names) == 0 {
+        return ""
+    }
+    sort.Strings(names)
+    return shortRef(names[0])
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+func TestClone(t *testing.T) {
+    upstream := testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    two := commitAll(t, "two")
+    err := Branch([]string{ "topic" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "file", "three\n")
+    three := commitAll(t, "three")
+    // relative paths are taken from the repository dir, never from the
+    // directory the tests run in
+    BaseDir = filepath.Dir(filepath.Clean(upstream)) + "/"
+    source := filepath.Base(filepath.Clean(upstream))
+
+    tests := []struct {
+        name     string
+        args     []string
+        head     string
+        commits  int
+        branches []string
+    }{
+        // init commits too, so there are four
+        {"whole history", nil, three, 4, []string{ DEFAULT_BRANCH, "topic" }},
+        {"depth", []string{ "--depth", "1", "--single-branch" }, three, 1, []string{ DEFAULT_BRANCH }},
+        {"single branch", []string{ "--single-branch", "--branch", "topic" }, two, 3, []string{ "topic" }},
+    }
+    for i, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dest := "clone" + string(rune('a' + i))
+            err := Clone(append(append([]string{}, tt.args...), source, dest))
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = inRepository(BaseDir + dest + "/", func() (error) {
+                head, err := getHead()
+                if err != nil || head != tt.head {
+                    t.Errorf("clone is at %s, %v, want %s", head, err, tt.head)
+                }
+                seen, err := ancestors(head)
+                if err != nil || len(seen) != tt.commits {
+                    t.Errorf("clone holds %d commits, %v, want %d", len(seen), err, tt.commits)
+                }
+                tracking, err := listRefs(REMOTES_PREFIX + DEFAULT_REMOTE + "/")
+                if err != nil || len(tracking) != len(tt.branches) {
+                    t.Errorf("clone tracks %v, %v, want %v", tracking, err, tt.branches)
+                }
+                return err
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+        })
+    }
+}
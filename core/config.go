@@ -0,0 +1,120 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+)
+
+////////////
+// CONFIG //
+////////////
+
+// .goverse/config holds one "key = value" per line, "#" starts a comment.
+// Keys are dotted, e.g. "user.name" or "lock.timeout"
+
+// readConfig returns every setting, an empty or missing file has none
+func readConfig() (map[string]string, error) {
+    config := map[string]string{}
+    bytes, err := os.ReadFile(BaseDir + CONFIG_FILE)
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return config, nil
+        }
+        return nil, &PathError{"read config", BaseDir + CONFIG_FILE, err}
+    }
+    for i, line := range strings.Split(string(bytes), "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        key, value, found := strings.Cut(line, "=")
+        if !found {
+            return nil, &PathError{"parse config", BaseDir + CONFIG_FILE, fmt.Errorf("line %d has no \"=\"", i + 1)}
+        }
+        config[strings.TrimSpace(key)] = strings.TrimSpace(value)
+    }
+    return config, nil
+}
+
+// getConfig returns the value of key, or fallback when it is unset
+func getConfig(key string, fallback string) (string) {
+    config, err := readConfig()
+    if err != nil {
+        return fallback
+    }
+    if value, ok := config[key]; ok {
+        return value
+    }
+    return fallback
+}
+
+// setConfig writes key, an empty value removes it
+func setConfig(key string, value string) (error) {
+    if key == "" || strings.ContainsAny(key, "= \t\n#") {
+        return fmt.Errorf("\"%s\" is not a valid config key", key)
+    }
+    lock, err := acquireLock(BaseDir + CONFIG_FILE, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return err
+    }
+    config, err := readConfig()
+    if err != nil {
+        lock.rollback()
+        return err
+    }
+    if value == "" {
+        delete(config, key)
+    } else {
+        config[key] = value
+    }
+    return lock.commit([]byte(formatConfig(config)))
+}
+
+func formatConfig(config map[string]string) (string) {
+    keys := []string{}
+    for key := range config {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    content := ""
+    for _, key := range keys {
+        content += key + " = " + config[key] + "\n"
+    }
+    return content
+}
+
+// Config lists every setting, prints one with "<key>", sets one with
+// "<key> <value>" and removes one with "--unset <key>"
+func Config(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    switch {
+    case len(args) == 0:
+        config, err := readConfig()
+        if err != nil {
+            return err
+        }
+        fmt.Print(formatConfig(config))
+        return nil
+    case args[0] == "--unset" && len(args) == 2:
+        return setConfig(args[1], "")
+    case len(args) == 1:
+        config, err := readConfig()
+        if err != nil {
+            return err
+        }
+        value, ok := config[args[0]]
+        if !ok {
+            return fmt.Errorf("\"%s\" is not set", args[0])
+        }
+        fmt.Println(value)
+        return nil
+    }
+    return setConfig(args[0], strings.Join(args[1:], " "))
+}
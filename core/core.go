@@ -3,9 +3,12 @@ package core
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"goverse/internal/models"
+	"os/user"
 	"strings"
+	"time"
 
 	// "hash"
 	// "io"
@@ -22,11 +25,13 @@ import (
 var BaseDir string
 const GOVERSE = ".goverse"
 const (
-    GOVERSE_DIR = ".goverse/"
-    OBJECTS_DIR = GOVERSE_DIR + "objects/"
-    TAGS_DIR    = GOVERSE_DIR + "tags/"
-    CONFIG_FILE = GOVERSE_DIR + "config"
-    HEAD_FILE   = GOVERSE_DIR + "head"
+    GOVERSE_DIR  = ".goverse/"
+    OBJECTS_DIR  = GOVERSE_DIR + "objects/"
+    TAGS_DIR     = GOVERSE_DIR + "tags/"
+    BRANCHES_DIR = GOVERSE_DIR + "branches/"
+    CONFIG_FILE  = GOVERSE_DIR + "config"
+    HEAD_FILE    = GOVERSE_DIR + "head"
+    INDEX_FILE   = GOVERSE_DIR + "index"
 )
 // test dirs
 const TD1 = OBJECTS_DIR + "another/file/smd/lol/lmao"
@@ -39,52 +44,75 @@ const TRUNC_LENGTH = 8
 
 
 func InitGoverse() (error) {
+    err := initRepository()
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    // snapshot everything into the index and commit it as a new root
+    idx := models.Index {}
+    err = stagePath(&idx, "")
+    if err != nil {
+        return fmt.Errorf("Unable to read files at BaseDir \"%s\": %w", BaseDir, err)
+    }
+    rootTree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return fmt.Errorf("Unable to store rootTree: %w", err)
+    }
+    err = writeIndex(idx)
+    if err != nil {
+        return err
+    }
+    message := "Initial snapshot"
+    newHead, err := createCommit(rootTree, nil, message)
+    if err != nil {
+        return fmt.Errorf("Unable to commit rootTree: %w", err)
+    }
+    err = setHead(newHead, "", REASON_INIT, message)
+    if err != nil {
+        return fmt.Errorf("Unable to set new head to hash \"%s\": %w", newHead , err)
+    }
+    return nil
+}
 
+// initRepository creates the .goverse layout with HEAD on the default
+// branch, leaving anything already there alone
+func initRepository() (error) {
     // Create necessary dirs and files for goverse VCS
-    dirs := []string{ GOVERSE_DIR, OBJECTS_DIR, TAGS_DIR }
+    dirs := []string{ GOVERSE_DIR, OBJECTS_DIR, TAGS_DIR, BRANCHES_DIR }
     // dirs = append(dirs, TD1, TD2, TD3, TD4, TD5)
-    files := []string{ CONFIG_FILE, HEAD_FILE }
+    files := []string{ CONFIG_FILE }
     for _, dir := range dirs {
         err := os.MkdirAll(BaseDir + dir, 0755)
         if err != nil {
-            return fmt.Errorf("Unable to create dir at \"%s\"\n%w\n", BaseDir + dir, err)
+            return &PathError{"create dir", BaseDir + dir, err}
         }
     }
     for _, file := range files {
+        if _, err := os.Stat(BaseDir + file); err == nil {
+            continue
+        }
         newFile, err := os.Create(BaseDir + file)
         if err != nil {
-            return fmt.Errorf("Unable to create file at \"%s\"\n%w\n", BaseDir + file, err)
+            return &PathError{"create file", BaseDir + file, err}
         }
         newFile.Close()
     }
-
-    rootTree := models.Tree {}
-    err := readFiles(BaseDir, &rootTree)
-    if err != nil {
-        return fmt.Errorf("Unable to read files at BaseDir \"%s\" into rootTree\n%w\n", BaseDir, err)
-    }
-    storeTree(rootTree)
-    newHead, err := hashTree(rootTree)
-    if err != nil {
-        return fmt.Errorf("Unable to hash rootTree\n%w\n", err)
+    if _, err := os.Stat(BaseDir + HEAD_FILE); err != nil {
+        return setHeadRef(BRANCHES_PREFIX + DEFAULT_BRANCH)
     }
-    err = setHead(newHead)
-    if err != nil {
-        return fmt.Errorf("Unable to set new head to hash \"%s\"\n%w\n", newHead , err)
-    }
-    // println("Entries: ")
-    // root, err := os.Create(BaseDir + OBJECTS_DIR + rootTree.Hash)
-    // if err != nil {
-    //     return err
-    // }
-    // root.Close()
     return nil
 }
 
 func printBlob(hash string) (error){
     content, err := getContent(hash)
     if err != nil {
-        return fmt.Errorf("Unable to get content for blob \"%s\"\n%w\n", hash, err)
+        return fmt.Errorf("Unable to get content for blob \"%s\": %w", hash, err)
     }
     lines := strings.Split(string(content), "\n")
     fmt.Printf("│  %s\n│  ------------\n", truncHash(hash))
@@ -99,7 +127,7 @@ func printTree(hash string, depth int, content bool) (error) {
 
     t, err := deserializeTree(hash)
     if err != nil {
-        return fmt.Errorf("Unable to deserialize Tree with hash \"%s\"\n%w\n", hash, err)
+        return fmt.Errorf("Unable to deserialize Tree with hash \"%s\": %w", hash, err)
     }
 
     for _, entry := range t.Entries {
@@ -126,22 +154,6 @@ func printTree(hash string, depth int, content bool) (error) {
     return nil
 }
 
-func setHead(hash string) (error) {
-    err := os.WriteFile(BaseDir + HEAD_FILE, []byte(hash), 0755)
-    if err != nil {
-        return fmt.Errorf("Unable to set new head at hash \"%s\"\n%w\n", hash, err)
-    }
-    return nil
-}
-
-func getHead() (string, error) {
-    bytes, err := os.ReadFile(BaseDir + HEAD_FILE)
-    if err != nil {
-        return "", fmt.Errorf("Unable to get head\n%w\n", err)
-    }
-    return string(bytes), nil
-}
-
 func getFileName(path string) (string) {
     if path[len(path)-1] == '/' {
         return strings.Split(path, "/")[len(strings.Split(path, "/"))-2]
@@ -154,14 +166,14 @@ func readFiles(path string, tree *models.Tree) (error) {
     // read directory
     entries, err := os.ReadDir(path)
     if err != nil {
-        return fmt.Errorf("Unable to read files at BaseDir \"%s\"\n%w\n", BaseDir, err)
+        return &PathError{"read dir", path, err}
     }
     
     // loop through directory
     for _, entry := range entries {
 
-        // skip meta goverse directory
-        if entry.Name() == GOVERSE {continue}
+        // skip meta goverse directory, and what a tree cannot hold
+        if entry.Name() == GOVERSE || workMode(entry.Type()) == "" {continue}
 
         // get new entry's path
         thisPath := path + entry.Name() 
@@ -172,7 +184,7 @@ func readFiles(path string, tree *models.Tree) (error) {
         // create tree entry for this entry
         te, err := createTreeEntry(thisPath)
         if err != nil {
-            return fmt.Errorf("Unable to create TreeEntry at path: %s\n%w\n", thisPath, err)
+            return fmt.Errorf("Unable to create TreeEntry at path: %s: %w", thisPath, err)
         }
         
         // add new tree entry to tree
@@ -183,16 +195,20 @@ func readFiles(path string, tree *models.Tree) (error) {
 
         // store blob content, or tree with tree entrys populated
         if te.IsBlob {
-            content, err := os.ReadFile(thisPath)
+            info, err := os.Lstat(thisPath)
+            if err != nil {
+                return &PathError{"stat", thisPath, err}
+            }
+            content, err := readWorkContent(thisPath, info)
             if err != nil {
-                return fmt.Errorf("Unable to read Blob at path: %s\n%w\n", thisPath, err)
+                return fmt.Errorf("Unable to read Blob at path: %s: %w", thisPath, err)
             }
             b := models.Blob {
                 Content: content,
             }
             err = storeBlob(b)
             if err != nil {
-                return fmt.Errorf("Unable to store Blob at path: %s\n%w\n", thisPath, err)
+                return fmt.Errorf("Unable to store Blob at path: %s: %w", thisPath, err)
             }
 
             // newBlobHash, _ := hashBlob(b)
@@ -204,11 +220,11 @@ func readFiles(path string, tree *models.Tree) (error) {
             }
             err := readFiles(thisPath, &t)
             if err != nil {
-                return fmt.Errorf("Unable to read Tree at path: %s\n%w\n", thisPath, err)
+                return fmt.Errorf("Unable to read Tree at path: %s: %w", thisPath, err)
             }
             treeHash, err := hashTree(t)
             if err != nil {
-                return fmt.Errorf("Unable to hash Tree, got hash %s\n%w\n", truncHash(treeHash), err)
+                return fmt.Errorf("Unable to hash Tree, got hash %s: %w", truncHash(treeHash), err)
             }
             storeTree(t)
         }
@@ -219,26 +235,20 @@ func readFiles(path string, tree *models.Tree) (error) {
 func storeBlob(b models.Blob) (error) {
     hashString, err := hashBlob(b)
     if err != nil {
-        return fmt.Errorf("Unable to hash Blob, got hash %s\n%w\n", truncHash(hashString), err)
+        return fmt.Errorf("Unable to hash Blob, got hash %s: %w", truncHash(hashString), err)
     }
-    path := BaseDir + OBJECTS_DIR + hashString
-    err = os.WriteFile(path, b.Content, 0755)
-    if err != nil {
-        return fmt.Errorf("Unable to store Blob at %s\n%w\n", path, err)
-    }
-
-    return nil
+    return writeObject(BLOB, hashString, b.Content)
 }
 
 func storeTree(t models.Tree) (error) {
     hashString, err := hashTree(t)
     if err != nil {
-        return fmt.Errorf("Unable to hash Tree, got hash %s\n%w\n", truncHash(hashString), err)
+        return fmt.Errorf("Unable to hash Tree, got hash %s: %w", truncHash(hashString), err)
     }
 
     serialized, err := serializeTree(t)
     if err != nil {
-        return fmt.Errorf("Unable to serialize tree with hash \"%s\"\n%w\n", hashString, err)
+        return fmt.Errorf("Unable to serialize tree with hash \"%s\": %w", hashString, err)
     }
 
 
@@ -251,31 +261,20 @@ func storeTree(t models.Tree) (error) {
     // println(hashString)
     // println("tree contents:")
     // println(string(serialized))
-    path := BaseDir + OBJECTS_DIR + hashString
-    
-    err = os.WriteFile(path, serialized, 0755)
-    if err != nil {
-        return fmt.Errorf("Unable to store Tree at %s\n%w\n", path, err)
-    }
-
-    return nil
+    return writeObject(TREE, hashString, serialized)
 }
 
 func getContent(hash string) ([]byte, error) {
-    files, err := os.ReadDir(BaseDir + OBJECTS_DIR)
-    if err != nil {
-        return nil, fmt.Errorf("Unable to read content at path: %s\n%w\n", BaseDir + OBJECTS_DIR, err)
-    }
-    for _, file := range files {
-        if file.Name() == hash {
-            return os.ReadFile(BaseDir + OBJECTS_DIR + hash)
-        }
-    }
-    return nil, fmt.Errorf("Unable to find hash content at path: %s\n%w\n", BaseDir + OBJECTS_DIR + truncHash(hash), err)
+    _, content, err := readObject(hash)
+    return content, err
 }
 
 func truncHash(hash string) (string) {
-    return hash[:TRUNC_LENGTH] + "..."
+    if len(hash) <= TRUNC_LENGTH {
+        return hash
+    }
+    // no trailing dots, a short hash can be typed back in as a revision
+    return hash[:TRUNC_LENGTH]
 }
 
 func getHash(str string) (string, error) {
@@ -283,7 +282,7 @@ func getHash(str string) (string, error) {
 
     _, err := hasher.Write([]byte(str))
     if err != nil {
-        return "", fmt.Errorf("Unable to hash %s\n%w", str, err)
+        return "", fmt.Errorf("Unable to hash %s: %w", str, err)
     }
 
     hashBytes := hasher.Sum(nil)
@@ -292,8 +291,14 @@ func getHash(str string) (string, error) {
     return hashString, nil
 }
 
+// hashKind hashes content behind the same "<kind> <size>\x00" header
+// objects are stored with, so objects of different kinds never share a name
+func hashKind(kind string, content string) (string, error) {
+    return getHash(fmt.Sprintf("%s %d\x00", kind, len(content)) + content)
+}
+
 func hashBlob(b models.Blob) (string, error) {
-    return getHash(string(b.Content))
+    return hashKind(BLOB, string(b.Content))
 }
 
 func hashTreeEntry(te models.TreeEntry) (string, error) {
@@ -305,80 +310,99 @@ func serializeTree(tree models.Tree) ([]byte, error) {
 }
 
 func deserializeTree(hash string) (models.Tree, error) {
-    file, err := os.ReadFile(BaseDir + OBJECTS_DIR + hash)
+    file, err := readTypedObject(hash, TREE)
     if err != nil {
-        return models.Tree{}, fmt.Errorf("Unable to read Tree file with hash \"%s\"\n%w\n", hash, err)
+        return models.Tree{}, err
     }
     var t models.Tree
     err = json.Unmarshal(file, &t)
     if err != nil {
-        return models.Tree{}, fmt.Errorf("Unable to deserialize Tree with hash \"%s\"\n%w\n", hash, err)
+        return models.Tree{}, &ObjectError{"deserialize Tree", truncHash(hash), fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+    }
+    err = checkTreeNames(t)
+    if err != nil {
+        return models.Tree{}, &ObjectError{"deserialize Tree", truncHash(hash), err}
     }
     return t, nil
 }
 
 func hashTree(t models.Tree) (string, error) {
+    // entry hashes cover names and modes, so a rename gives a new tree
     contentHashes := ""
     for _, te := range t.Entries {
-        contentHashes += te.Hash
+        entryHash, err := hashTreeEntry(te)
+        if err != nil {
+            return "", err
+        }
+        contentHashes += entryHash
     }
-    return getHash(contentHashes)
+    return hashKind(TREE, contentHashes)
 }
 
 func hashDir(path string) (string, error) {
-    hash := ""
     entries, err := os.ReadDir(path)  // read all files and subdirs
     if err != nil {
-        return "", fmt.Errorf("Unable to read files at path: %s\n%w\n", path, err)
+        return "", &PathError{"read dir", path, err}
     }
-    var entryHash string
+    // hash the same entries readFiles would store, so hashDir matches hashTree
+    t := models.Tree {}
     for _, entry := range entries {
+        if entry.Name() == GOVERSE || workMode(entry.Type()) == "" {continue}
+        entryPath := path + entry.Name()
         if entry.IsDir() {
-            entryHash, err = hashDir(path + entry.Name() + "/")
-        } else {
-            entryHash, err = hashFile(path + entry.Name())
+            entryPath += "/"
         }
+        te, err := createTreeEntry(entryPath)
         if err != nil {
-            return "", fmt.Errorf("Unable to hash %s\n%w", path + entry.Name(), err)
+            return "", fmt.Errorf("Unable to hash %s: %w", entryPath, err)
         }
-        hash += entryHash
+        t.Entries = append(t.Entries, te)
     }
 
-    return getHash(hash)
+    return hashTree(t)
 }
 
 func hashFile(path string) (string, error){
     content, err := os.ReadFile(path)
     if err != nil {
-        return "", fmt.Errorf("Unable to read file at path: %s\n%w\n", path, err)
+        return "", &PathError{"read file", path, err}
     }
-    return getHash(string(content))
+    return hashBlob(models.Blob{ Content: content })
 }
 
 func createTreeEntry(path string) (models.TreeEntry, error) {
     // println("\tcreateTreeEntry path: " + path)
 
-    fileInfo, err := os.Stat(path)
+    // Lstat, so a symlink is an entry of its own and never followed
+    fileInfo, err := os.Lstat(strings.TrimSuffix(path, "/"))
     if err != nil {
-        return models.TreeEntry{}, fmt.Errorf("Unable to find file at path: %s, cannot create TreeEntry\n%w\n", path, err)
+        return models.TreeEntry{}, &PathError{"stat", path, err}
+    }
+    mode := workMode(fileInfo.Mode())
+    if mode == "" {
+        return models.TreeEntry{}, &PathError{"stat", path, errors.New("not a file, directory or symlink")}
     }
 
     var hash string
     if fileInfo.IsDir() {
         hash, err = hashDir(path)
         if err != nil {
-            return models.TreeEntry{}, fmt.Errorf("Unable to hash dir for TreeEntry at path: %s\n%w\n", path, err)
+            return models.TreeEntry{}, fmt.Errorf("Unable to hash dir for TreeEntry at path: %s: %w", path, err)
         }
     } else {
-        hash, err = hashFile(path)
+        content, err := readWorkContent(path, fileInfo)
+        if err != nil {
+            return models.TreeEntry{}, fmt.Errorf("Unable to hash file for TreeEntry at path: %s: %w", path, err)
+        }
+        hash, err = hashBlob(models.Blob{ Content: content })
         if err != nil {
-            return models.TreeEntry{}, fmt.Errorf("Unable to hash file for TreeEntry at path: %s\n%w\n", path, err)
+            return models.TreeEntry{}, fmt.Errorf("Unable to hash file for TreeEntry at path: %s: %w", path, err)
         }
     }
 
     te := models.TreeEntry {
         Name: fileInfo.Name(),
-        Mode: fmt.Sprintf("%o", fileInfo.Mode()),
+        Mode: mode,
         Hash: hash,
         IsBlob: !fileInfo.IsDir(),
         Modified: false,
@@ -391,40 +415,92 @@ func createTreeEntry(path string) (models.TreeEntry, error) {
 func getStoredObject(path string) (string, error) {
     head, err := getHead()
     if err != nil {
-        return "", fmt.Errorf("Unable to find head\n%w\n", err)
+        return "", fmt.Errorf("Unable to find head: %w", err)
     }
     
-    return head, fmt.Errorf("Unable to find stored hash for %s\n%w\n", path, err)
+    return head, fmt.Errorf("Unable to find stored hash for %s: %w", path, err)
 }
 
 func CheckChanged(fileName string) (bool, error) {
     path := fileName
     thisHash, err := hashFile(path)
     if err != nil {
-        return false, fmt.Errorf("Unable to get hash for %s\n%w\n", path, err)
+        return false, fmt.Errorf("Unable to get hash for %s: %w", path, err)
     }
 
-    storedItemHash := BaseDir + OBJECTS_DIR + thisHash
-    if _, err := os.Stat(storedItemHash); err == nil {
-        return false, fmt.Errorf("Unable to get hash for %s\n%w\n", path, err)
+    if objectExists(thisHash) {
+        return false, nil
     }
     return true, nil
 }
 
 
 func Add(file string) (error) {
-    
-    return nil
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    rel, err := relPath(file)
+    if err != nil {
+        return err
+    }
+    if beyondSymlink(rel) {
+        return fmt.Errorf("Unable to add \"%s\", it is beyond a symbolic link", rel)
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    err = stagePath(&idx, rel)
+    if err != nil {
+        return fmt.Errorf("Unable to add \"%s\": %w", rel, err)
+    }
+    return writeIndex(idx)
 }
 
 func Status() (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    branch, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if branch != "" {
+        fmt.Println("On branch " + branch)
+    } else {
+        fmt.Println("HEAD detached")
+    }
     head, err := getHead()
     if err != nil {
-        return fmt.Errorf("Unable to get head\n%w\n", err)
+        return fmt.Errorf("Unable to get head: %w", err)
+    }
+    if head == "" {
+        fmt.Println("No commits yet")
+        return nil
+    }
+    if branch != "" {
+        tracking, err := trackingStatus(branch, head)
+        if err != nil {
+            return err
+        }
+        if tracking != "" {
+            fmt.Println(tracking)
+        }
     }
     fmt.Println("Head: " + head + "\n")
 
-    printTree(head, 0, true)
+    c, err := deserializeCommit(head)
+    if err != nil {
+        return err
+    }
+    printTree(c.Tree, 0, true)
     
     return nil
 }
@@ -433,22 +509,283 @@ func Diff() {
     
 }
 
-func Tag() {
-    
+// Tag lists tags, or tags rev (HEAD by default) as name, "-d name" deletes
+func Tag(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(args) == 0 {
+        tags, err := listRefs(TAGS_PREFIX)
+        if err != nil {
+            return err
+        }
+        for _, tag := range tags {
+            fmt.Println(shortRef(tag))
+        }
+        return nil
+    }
+    if args[0] == "-d" {
+        if len(args) < 2 {
+            return errors.New("No tag given to delete")
+        }
+        err := checkRefName(args[1])
+        if err != nil {
+            return err
+        }
+        return deleteRef(TAGS_PREFIX + args[1])
+    }
+
+    name := args[0]
+    err = checkRefName(name)
+    if err != nil {
+        return err
+    }
+    if refExists(TAGS_PREFIX + name) {
+        return fmt.Errorf("Tag \"%s\" already exists", name)
+    }
+    rev := "HEAD"
+    if len(args) > 1 {
+        rev = args[1]
+    }
+    commit, err := resolveCommit(rev)
+    if err != nil {
+        return err
+    }
+    t := models.Tag {
+        Name: name,
+        Version: name,
+        Commit: commit,
+        Tagger: getIdentity(),
+        Timestamp: time.Now().Format(time.RFC3339),
+    }
+    hash, err := storeTagObject(t)
+    if err != nil {
+        return err
+    }
+    return writeRef(TAGS_PREFIX + name, hash)
 }
 
-func Commit() {
-    
+// Branch lists branches ("-r" the remote-tracking ones), or creates name at
+// rev (HEAD by default), "-d name" deletes
+func Branch(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    current, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if len(args) == 0 || args[0] == "-r" {
+        prefix := BRANCHES_PREFIX
+        if len(args) > 0 {
+            prefix = REMOTES_PREFIX
+        }
+        branches, err := listRefs(prefix)
+        if err != nil {
+            return err
+        }
+        for _, branch := range branches {
+            if shortRef(branch) == current {
+                fmt.Println("* " + shortRef(branch))
+            } else {
+                fmt.Println("  " + shortRef(branch))
+            }
+        }
+        return nil
+    }
+    if args[0] == "-d" {
+        if len(args) < 2 {
+            return errors.New("No branch given to delete")
+        }
+        if args[1] == current {
+            return fmt.Errorf("Cannot delete the checked out branch \"%s\"", current)
+        }
+        err := checkRefName(args[1])
+        if err != nil {
+            return err
+        }
+        return deleteRef(BRANCHES_PREFIX + args[1])
+    }
+
+    name := args[0]
+    err = checkRefName(name)
+    if err != nil {
+        return err
+    }
+    if refExists(BRANCHES_PREFIX + name) {
+        return fmt.Errorf("Branch \"%s\" already exists", name)
+    }
+    rev := "HEAD"
+    if len(args) > 1 {
+        rev = args[1]
+    }
+    commit, err := resolveCommit(rev)
+    if err != nil {
+        return err
+    }
+    return updateRef(BRANCHES_PREFIX + name, commit, ZERO_HASH, REASON_BRANCH, "Created from " + rev)
 }
 
-func Log() {
-    
+// createCommit stores a commit of tree on top of parents and returns its hash
+func createCommit(tree string, parents []string, message string) (string, error) {
+    c := models.Commit {
+        Tree: tree,
+        Parents: parents,
+        Message: message,
+        Author: getIdentity(),
+        Timestamp: time.Now().Format(time.RFC3339),
+    }
+    return storeCommit(c)
 }
-    
-func Flush() (error) {
-    err := os.RemoveAll(BaseDir + GOVERSE_DIR)
+
+// getIdentity names whoever runs goverse, $GOVERSE_AUTHOR wins over
+// user.name and user.email, which win over the login name
+func getIdentity() (string) {
+    if author := os.Getenv("GOVERSE_AUTHOR"); author != "" {
+        return author
+    }
+    name := "unknown"
+    if u, err := user.Current(); err == nil {
+        name = u.Username
+    }
+    host, err := os.Hostname()
+    if err != nil {
+        host = "localhost"
+    }
+    name = getConfig("user.name", name)
+    email := getConfig("user.email", name + "@" + host)
+    return fmt.Sprintf("%s <%s>", name, email)
+}
+
+func Commit(message string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    message = strings.TrimSpace(message)
+    if message == "" {
+        return errors.New("Commit message is empty")
+    }
+    unlock, err := lockRepository()
     if err != nil {
         return err
     }
+    defer unlock()
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+
+    parents := []string{}
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    expectedHead := head
+    if head == "" {
+        expectedHead = ZERO_HASH
+    }
+    if head != "" {
+        parent, err := deserializeCommit(head)
+        if err != nil {
+            return err
+        }
+        if parent.Tree == tree {
+            return ErrNothingToCommit
+        }
+        parents = append(parents, head)
+    }
+
+    hash, err := createCommit(tree, parents, message)
+    if err != nil {
+        return err
+    }
+    err = setHead(hash, expectedHead, REASON_COMMIT, firstLine(message))
+    if err != nil {
+        return err
+    }
+    branch, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        branch = "detached HEAD"
+    }
+    fmt.Printf("[%s %s] %s\n", branch, truncHash(hash), firstLine(message))
+    return nil
+}
+
+func printCommit(c models.Commit) {
+    fmt.Println("commit " + c.Hash)
+    if len(c.Parents) > 1 {
+        short := []string{}
+        for _, parent := range c.Parents {
+            short = append(short, truncHash(parent))
+        }
+        fmt.Println("Merge:  " + strings.Join(short, " "))
+    }
+    fmt.Println("Author: " + c.Author)
+    fmt.Println("Date:   " + c.Timestamp)
+    fmt.Println()
+    for _, line := range strings.Split(strings.TrimRight(c.Message, "\n"), "\n") {
+        fmt.Println("    " + line)
+    }
+    fmt.Println()
+}
+
+// Log prints the history selected by revs, HEAD when none are given and
+// every ref with "--all"
+func Log(revs []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    include, exclude, err := logRevisions(revs)
+    if err != nil {
+        return err
+    }
+    commits, err := revList(include, exclude)
+    if err != nil {
+        return err
+    }
+    for _, c := range commits {
+        printCommit(c)
+    }
+    return nil
+}
+
+// RevParse prints the full hash each revision names, ranges print their
+// included commits and then their excluded ones prefixed with ^
+func RevParse(revs []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    for _, rev := range revs {
+        if !strings.Contains(rev, "..") && !strings.HasPrefix(rev, "^") {
+            hash, err := resolveRevision(rev)
+            if err != nil {
+                return err
+            }
+            fmt.Println(hash)
+            continue
+        }
+        include, exclude, err := resolveRange(rev)
+        if err != nil {
+            return err
+        }
+        for _, hash := range include {
+            fmt.Println(hash)
+        }
+        for _, hash := range exclude {
+            fmt.Println("^" + hash)
+        }
+    }
     return nil
 }
@@ -0,0 +1,316 @@
+package core
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+//////////
+// DIFF //
+//////////
+
+// lines of context around each change in a unified diff
+const DIFF_CONTEXT = 3
+
+// splitLines cuts content into lines that keep their "\n", the last line
+// may lack one
+func splitLines(content []byte) ([]string) {
+    lines := []string{}
+    for len(content) > 0 {
+        end := bytes.IndexByte(content, '\n')
+        if end < 0 {
+            end = len(content) - 1
+        }
+        lines = append(lines, string(content[:end+1]))
+        content = content[end+1:]
+    }
+    return lines
+}
+
+// isBinary guesses like git does, a NUL early on means not text
+func isBinary(content []byte) (bool) {
+    if len(content) > 8000 {
+        content = content[:8000]
+    }
+    return bytes.IndexByte(content, 0) >= 0
+}
+
+// diffMatches finds a shortest edit script from a to b with Myers'
+// algorithm and returns, for every line of a, the line of b it is kept
+// as, or -1 when it is deleted. It splits on the middle snake and
+// recurses rather than keeping every step's V, so memory stays linear
+func diffMatches(a []string, b []string) ([]int) {
+    matches := make([]int, len(a))
+    for i := range matches {
+        matches[i] = -1
+    }
+    diffRange(a, b, 0, len(a), 0, len(b), matches)
+    return matches
+}
+
+// diffRange fills in matches for a[aLo:aHi] against b[bLo:bHi]
+func diffRange(a []string, b []string, aLo int, aHi int, bLo int, bHi int, matches []int) {
+    // a common head and tail are kept as they are
+    for aLo < aHi && bLo < bHi && a[aLo] == b[bLo] {
+        matches[aLo] = bLo
+        aLo++
+        bLo++
+    }
+    for aLo < aHi && bLo < bHi && a[aHi-1] == b[bHi-1] {
+        aHi--
+        bHi--
+        matches[aHi] = bHi
+    }
+    if aLo == aHi || bLo == bHi {
+        return
+    }
+    x, y, u, v := middleSnake(a, b, aLo, aHi, bLo, bHi)
+    for i := x; i < u; i++ {
+        matches[i] = y + i - x
+    }
+    diffRange(a, b, aLo, x, bLo, y, matches)
+    diffRange(a, b, u, aHi, v, bHi, matches)
+}
+
+// middleSnake runs Myers' search from both ends of a[aLo:aHi] and
+// b[bLo:bHi] until they meet, returning where the snake they meet on
+// starts (x, y) and ends (u, v)
+func middleSnake(a []string, b []string, aLo int, aHi int, bLo int, bHi int) (int, int, int, int) {
+    n, m := aHi - aLo, bHi - bLo
+    delta := n - m
+    odd := delta % 2 != 0
+    max := (n + m + 1) / 2
+    offset := max + 1
+    // forward[k] is the furthest x on diagonal k = x - y from the start,
+    // backward[c] the furthest from the end on c = (n - x) - (m - y)
+    forward := make([]int, 2*max + 3)
+    backward := make([]int, 2*max + 3)
+    for d := 0; d <= max; d++ {
+        for k := -d; k <= d; k += 2 {
+            var x int
+            if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+                x = forward[offset+k+1]
+            } else {
+                x = forward[offset+k-1] + 1
+            }
+            y := x - k
+            startX, startY := x, y
+            for x < n && y < m && a[aLo+x] == b[bLo+y] {
+                x++
+                y++
+            }
+            forward[offset+k] = x
+            c := delta - k
+            if odd && c >= -(d-1) && c <= d-1 && x + backward[offset+c] >= n {
+                return aLo + startX, bLo + startY, aLo + x, bLo + y
+            }
+        }
+        for c := -d; c <= d; c += 2 {
+            var x int
+            if c == -d || (c != d && backward[offset+c-1] < backward[offset+c+1]) {
+                x = backward[offset+c+1]
+            } else {
+                x = backward[offset+c-1] + 1
+            }
+            y := x - c
+            startX, startY := x, y
+            for x < n && y < m && a[aHi-1-x] == b[bHi-1-y] {
+                x++
+                y++
+            }
+            backward[offset+c] = x
+            k := delta - c
+            if !odd && k >= -d && k <= d && x + forward[offset+k] >= n {
+                return aLo + n - x, bLo + m - y, aLo + n - startX, bLo + m - startY
+            }
+        }
+    }
+    // not reached, the two searches meet within max steps
+    return aLo, bLo, aLo, bLo
+}
+
+// diffEdit is one line of a diff: ' ' kept, '-' only in a, '+' only in b
+type diffEdit struct {
+    op   byte
+    line string
+    a    int
+    b    int
+}
+
+// diffLines lists every line of a and b in order, marked kept, deleted or added
+func diffLines(a []string, b []string) ([]diffEdit) {
+    matches := diffMatches(a, b)
+    edits := []diffEdit{}
+    j := 0
+    for i, line := range a {
+        if matches[i] < 0 {
+            edits = append(edits, diffEdit{'-', line, i, j})
+            continue
+        }
+        for ; j < matches[i]; j++ {
+            edits = append(edits, diffEdit{'+', b[j], i, j})
+        }
+        edits = append(edits, diffEdit{' ', line, i, j})
+        j++
+    }
+    for ; j < len(b); j++ {
+        edits = append(edits, diffEdit{'+', b[j], len(a), j})
+    }
+    return edits
+}
+
+// unifiedDiff renders the change from a to b as "---", "+++" and "@@"
+// hunks, "" when nothing changed
+func unifiedDiff(nameA string, nameB string, a []byte, b []byte) (string) {
+    if bytes.Equal(a, b) {
+        return ""
+    }
+    if isBinary(a) || isBinary(b) {
+        return fmt.Sprintf("Binary files %s and %s differ\n", nameA, nameB)
+    }
+    edits := diffLines(splitLines(a), splitLines(b))
+    var out strings.Builder
+    fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+    for start := 0; start < len(edits); {
+        if edits[start].op == ' ' {
+            start++
+            continue
+        }
+        // a hunk runs until more than twice the context passes unchanged
+        first := start - DIFF_CONTEXT
+        if first < 0 {
+            first = 0
+        }
+        end, quiet := start, 0
+        for end < len(edits) && quiet <= 2*DIFF_CONTEXT {
+            if edits[end].op == ' ' {
+                quiet++
+            } else {
+                quiet = 0
+            }
+            end++
+        }
+        end -= quiet
+        end += DIFF_CONTEXT
+        if end > len(edits) {
+            end = len(edits)
+        }
+
+        countA, countB := 0, 0
+        for _, edit := range edits[first:end] {
+            if edit.op != '+' {
+                countA++
+            }
+            if edit.op != '-' {
+                countB++
+            }
+        }
+        fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(edits[first].a, countA), hunkRange(edits[first].b, countB))
+        for _, edit := range edits[first:end] {
+            out.WriteByte(edit.op)
+            out.WriteString(edit.line)
+            if !strings.HasSuffix(edit.line, "\n") {
+                out.WriteString("\n\\ No newline at end of file\n")
+            }
+        }
+        start = end
+    }
+    return out.String()
+}
+
+// hunkRange is the "start,count" of a hunk header, lines count from 1 and
+// an empty side names the line before it
+func hunkRange(start int, count int) (string) {
+    if count == 0 {
+        return fmt.Sprintf("%d,0", start)
+    }
+    if count == 1 {
+        return fmt.Sprintf("%d", start + 1)
+    }
+    return fmt.Sprintf("%d,%d", start + 1, count)
+}
+
+// diffStat counts the lines added and deleted between a and b
+func diffStat(a []byte, b []byte) (int, int) {
+    added, deleted := 0, 0
+    for _, edit := range diffLines(splitLines(a), splitLines(b)) {
+        switch edit.op {
+        case '+':
+            added++
+        case '-':
+            deleted++
+        }
+    }
+    return added, deleted
+}
+
+// writeFilesDiff describes how the files in old became those in new,
+// a diffstat or with patch a unified diff of every changed file
+func writeFilesDiff(w io.Writer, old map[string]models.IndexEntry, new map[string]models.IndexEntry, patch bool) (error) {
+    paths := []string{}
+    for p := range old {
+        paths = append(paths, p)
+    }
+    for p := range new {
+        if _, ok := old[p]; !ok {
+            paths = append(paths, p)
+        }
+    }
+    sort.Strings(paths)
+
+    files, insertions, deletions := 0, 0, 0
+    for _, p := range paths {
+        before, inOld := old[p]
+        after, inNew := new[p]
+        if inOld && inNew && before.Hash == after.Hash && before.Mode == after.Mode {
+            continue
+        }
+        files++
+        var a, b []byte
+        var err error
+        nameA, nameB := "a/" + p, "b/" + p
+        if inOld {
+            a, err = readTypedObject(before.Hash, BLOB)
+            if err != nil {
+                return err
+            }
+        } else {
+            nameA = "/dev/null"
+        }
+        if inNew {
+            b, err = readTypedObject(after.Hash, BLOB)
+            if err != nil {
+                return err
+            }
+        } else {
+            nameB = "/dev/null"
+        }
+
+        if patch {
+            fmt.Fprintf(w, "diff a/%s b/%s\n", p, p)
+            switch {
+            case !inOld:
+                fmt.Fprintf(w, "new file mode %s\n", after.Mode)
+            case !inNew:
+                fmt.Fprintf(w, "deleted file mode %s\n", before.Mode)
+            case before.Mode != after.Mode:
+                fmt.Fprintf(w, "old mode %s\nnew mode %s\n", before.Mode, after.Mode)
+            }
+            fmt.Fprint(w, unifiedDiff(nameA, nameB, a, b))
+            continue
+        }
+        added, deleted := diffStat(a, b)
+        insertions += added
+        deletions += deleted
+        fmt.Fprintf(w, " %s | %d %s%s\n", p, added + deleted, strings.Repeat("+", min(added, 40)), strings.Repeat("-", min(deleted, 40)))
+    }
+    if !patch && files > 0 {
+        fmt.Fprintf(w, " %d files changed, %d insertions(+), %d deletions(-)\n", files, insertions, deletions)
+    }
+    return nil
+}
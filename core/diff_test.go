@@ -0,0 +1,131 @@
+package core
+
+import (
+    "fmt"
+    "math/rand"
+    "runtime"
+    "strings"
+    "testing"
+)
+
+// lcsLength is the textbook quadratic longest common subsequence, what a
+// shortest edit script has to keep
+func lcsLength(a []string, b []string) (int) {
+    prev := make([]int, len(b)+1)
+    for i := range a {
+        cur := make([]int, len(b)+1)
+        for j := range b {
+            switch {
+            case a[i] == b[j]:
+                cur[j+1] = prev[j] + 1
+            case prev[j+1] > cur[j]:
+                cur[j+1] = prev[j+1]
+            default:
+                cur[j+1] = cur[j]
+            }
+        }
+        prev = cur
+    }
+    return prev[len(b)]
+}
+
+// checkMatches fails unless matches pairs equal lines in order and keeps as
+// many as the longest common subsequence
+func checkMatches(t *testing.T, a []string, b []string, matches []int) {
+    t.Helper()
+    kept, last := 0, -1
+    for i, j := range matches {
+        if j < 0 {
+            continue
+        }
+        if j <= last || j >= len(b) || a[i] != b[j] {
+            t.Fatalf("bad match %d -> %d in %q / %q", i, j, a, b)
+        }
+        last = j
+        kept++
+    }
+    if want := lcsLength(a, b); kept != want {
+        t.Fatalf("kept %d lines of %q / %q, want %d", kept, a, b, want)
+    }
+}
+
+func TestDiffMatches(t *testing.T) {
+    tests := []struct {
+        name string
+        a    string
+        b    string
+    }{
+        {"both empty", "", ""},
+        {"all added", "", "abc"},
+        {"all deleted", "abc", ""},
+        {"same", "abc", "abc"},
+        {"one changed", "abc", "axc"},
+        {"insert in middle", "abcd", "abXcd"},
+        {"swap", "ab", "ba"},
+        {"myers example", "abcabba", "cbabac"},
+        {"nothing shared", "abc", "xyz"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+            checkMatches(t, a, b, diffMatches(a, b))
+        })
+    }
+
+    random := rand.New(rand.NewSource(1))
+    for n := 0; n < 300; n++ {
+        a := make([]string, random.Intn(30))
+        b := make([]string, random.Intn(30))
+        for i := range a {
+            a[i] = string(rune('a' + random.Intn(4)))
+        }
+        for i := range b {
+            b[i] = string(rune('a' + random.Intn(4)))
+        }
+        checkMatches(t, a, b, diffMatches(a, b))
+    }
+}
+
+// a rewrite of every line is the worst case, D is n + m
+func TestDiffMatchesMemory(t *testing.T) {
+    a, b := make([]string, 6000), make([]string, 6000)
+    for i := range a {
+        a[i] = fmt.Sprintf("old %d\n", i)
+        b[i] = fmt.Sprintf("new %d\n", i)
+    }
+    var before, after runtime.MemStats
+    runtime.ReadMemStats(&before)
+    matches := diffMatches(a, b)
+    runtime.ReadMemStats(&after)
+    for i, j := range matches {
+        if j >= 0 {
+            t.Fatalf("line %d kept as %d in a full rewrite", i, j)
+        }
+    }
+    if used := after.TotalAlloc - before.TotalAlloc; used > 16<<20 {
+        t.Fatalf("diff of 6000 rewritten lines allocated %d MB", used>>20)
+    }
+}
+
+func TestUnifiedDiff(t *testing.T) {
+    tests := []struct {
+        name string
+        a    string
+        b    string
+        want string
+    }{
+        {"unchanged", "a\n", "a\n", ""},
+        {"binary", "a\x00", "b", "Binary files a/f and b/f differ\n"},
+        {"one line", "a\nb\nc\n", "a\nB\nc\n", "--- a/f\n+++ b/f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+        {"new file", "", "x\n", "--- a/f\n+++ b/f\n@@ -0,0 +1 @@\n+x\n"},
+        {"no newline", "a\n", "a", "--- a/f\n+++ b/f\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := unifiedDiff("a/f", "b/f", []byte(tt.a), []byte(tt.b))
+            if got != tt.want {
+                t.Fatalf("unifiedDiff = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
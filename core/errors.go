@@ -0,0 +1,81 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+////////////
+// ERRORS //
+////////////
+
+// Sentinel errors, match these with errors.Is
+var (
+    ErrNotARepository    = errors.New("not a goverse repository")
+    ErrObjectNotFound    = errors.New("object not found")
+    ErrAmbiguousRevision = errors.New("ambiguous revision")
+    ErrDirtyWorkTree     = errors.New("working tree has uncommitted changes")
+    ErrConflict          = errors.New("conflict")
+    ErrCorruptObject     = errors.New("corrupt object")
+    ErrDangling          = errors.New("dangling objects")
+    ErrNothingToCommit   = errors.New("nothing to commit")
+    ErrLocked            = errors.New("locked by another goverse process")
+    ErrRefChanged        = errors.New("ref was updated by another goverse process")
+    ErrRejected          = errors.New("rejected by the remote")
+    ErrUnsafePath        = errors.New("unsafe path")
+    ErrNotFastForward    = errors.New("not a fast-forward")
+)
+
+// PathError records a failed operation on a file or directory
+type PathError struct {
+    Op   string
+    Path string
+    Err  error
+}
+
+func (e *PathError) Error() (string) {
+    return fmt.Sprintf("Unable to %s \"%s\": %v", e.Op, e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() (error) {
+    return e.Err
+}
+
+// ObjectError records a failed operation on a stored object
+type ObjectError struct {
+    Op   string
+    Hash string
+    Err  error
+}
+
+func (e *ObjectError) Error() (string) {
+    return fmt.Sprintf("Unable to %s object \"%s\": %v", e.Op, e.Hash, e.Err)
+}
+
+func (e *ObjectError) Unwrap() (error) {
+    return e.Err
+}
+
+// ConflictError lists the paths a merge left conflict markers in
+type ConflictError struct {
+    Paths []string
+}
+
+func (e *ConflictError) Error() (string) {
+    return fmt.Sprintf("Merge conflicts in %s, fix them and add the result", strings.Join(e.Paths, ", "))
+}
+
+func (e *ConflictError) Unwrap() (error) {
+    return ErrConflict
+}
+
+// requireRepository fails with ErrNotARepository when BaseDir has no .goverse dir
+func requireRepository() (error) {
+    info, err := os.Stat(BaseDir + GOVERSE_DIR)
+    if err != nil || !info.IsDir() {
+        return &PathError{"open repository", BaseDir, ErrNotARepository}
+    }
+    return nil
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+/////////////////
+// FAST-EXPORT //
+/////////////////
+
+type exporter struct {
+    out     *bufio.Writer
+    marks   map[string]string
+    marked  map[string]string
+    next    int
+    commits int
+}
+
+// mark gives hash a new mark
+func (ex *exporter) mark(hash string) (string) {
+    ex.next++
+    mark := ":" + strconv.Itoa(ex.next)
+    ex.marks[mark] = hash
+    ex.marked[hash] = mark
+    return mark
+}
+
+// gitIdent turns an identity and RFC3339 timestamp into
+// "<name> <<email>> <seconds> <zone>"
+func gitIdent(who string, timestamp string) (string) {
+    if !strings.Contains(who, "<") {
+        who += " <>"
+    }
+    when, err := time.Parse(time.RFC3339, timestamp)
+    if err != nil {
+        when = time.Unix(0, 0).UTC()
+    }
+    return fmt.Sprintf("%s %d %s", who, when.Unix(), when.Format("-0700"))
+}
+
+// gitMode maps a goverse file mode to the git one
+func gitMode(mode string) (string) {
+    if mode == SYMLINK_MODE {
+        return GIT_SYMLINK_MODE
+    }
+    if fileMode(mode) & 0111 != 0 {
+        return GIT_EXECUTABLE_MODE
+    }
+    return GIT_FILE_MODE
+}
+
+func (ex *exporter) data(content []byte) {
+    fmt.Fprintf(ex.out, "data %d\n", len(content))
+    ex.out.Write(content)
+    ex.out.WriteString("\n")
+}
+
+// commit writes c to ref with its files as changes against its first parent
+func (ex *exporter) commit(ref string, c models.Commit) (error) {
+    before := map[string]models.IndexEntry{}
+    if len(c.Parents) > 0 {
+        var err error
+        before, err = commitFiles(c.Parents[0])
+        if err != nil {
+            return err
+        }
+    }
+    after, err := treeFiles(c.Tree)
+    if err != nil {
+        return err
+    }
+    paths := []string{}
+    for p := range before {
+        if _, ok := after[p]; !ok {
+            paths = append(paths, p)
+        }
+    }
+    for p, entry := range after {
+        if old, ok := before[p]; !ok || old.Hash != entry.Hash || gitMode(old.Mode) != gitMode(entry.Mode) {
+            paths = append(paths, p)
+        }
+    }
+    sort.Strings(paths)
+
+    for _, p := range paths {
+        entry, ok := after[p]
+        if !ok || ex.marked[entry.Hash] != "" {
+            continue
+        }
+        content, err := readTypedObject(entry.Hash, BLOB)
+        if err != nil {
+            return err
+        }
+        fmt.Fprintf(ex.out, "blob\nmark %s\n", ex.mark(entry.Hash))
+        ex.data(content)
+    }
+
+    if len(c.Parents) == 0 {
+        // without this a root commit would build on ref's last commit
+        fmt.Fprintf(ex.out, "reset %s\n", gitRef(ref))
+    }
+    ident := gitIdent(c.Author, c.Timestamp)
+    fmt.Fprintf(ex.out, "commit %s\nmark %s\nauthor %s\ncommitter %s\n", gitRef(ref), ex.mark(c.Hash), ident, ident)
+    ex.data([]byte(c.Message))
+    for i, parent := range c.Parents {
+        mark := ex.marked[parent]
+        if mark == "" {
+            return fmt.Errorf("Parent %s of %s was not exported", truncHash(parent), truncHash(c.Hash))
+        }
+        if i == 0 {
+            fmt.Fprintf(ex.out, "from %s\n", mark)
+        } else {
+            fmt.Fprintf(ex.out, "merge %s\n", mark)
+        }
+    }
+    for _, p := range paths {
+        entry, ok := after[p]
+        if !ok {
+            fmt.Fprintf(ex.out, "D %s\n", gitQuotePath(p))
+            continue
+        }
+        fmt.Fprintf(ex.out, "M %s %s %s\n", gitMode(entry.Mode), ex.marked[entry.Hash], gitQuotePath(p))
+    }
+    ex.out.WriteString("\n")
+    ex.commits++
+    return nil
+}
+
+// ref writes every commit leading to ref that is not exported yet, then
+// points ref at its tip
+func (ex *exporter) ref(ref string) (error) {
+    value, err := readRef(ref)
+    if err != nil {
+        return err
+    }
+    tip, err := peelToCommit(value)
+    if err != nil {
+        return err
+    }
+    commits, err := revList([]string{ tip }, nil)
+    if err != nil {
+        return err
+    }
+    last := ""
+    for i := len(commits) - 1; i >= 0; i-- {
+        if ex.marked[commits[i].Hash] != "" {
+            continue
+        }
+        err = ex.commit(ref, commits[i])
+        if err != nil {
+            return err
+        }
+        last = commits[i].Hash
+    }
+
+    if value != tip {
+        t, err := deserializeTag(value)
+        if err != nil {
+            return err
+        }
+        fmt.Fprintf(ex.out, "tag %s\nfrom %s\ntagger %s\n", shortRef(ref), ex.marked[tip], gitIdent(t.Tagger, t.Timestamp))
+        ex.data([]byte(t.Version + "\n"))
+        return nil
+    }
+    if last != tip {
+        fmt.Fprintf(ex.out, "reset %s\nfrom %s\n\n", gitRef(ref), ex.marked[tip])
+    }
+    return nil
+}
+
+// FastExport writes the history of refs (every branch and tag by default)
+// to stdout as a fast-import stream: "fast-export [--marks <file>]
+// [<ref>...]". Commits the marks file already lists are not written again
+func FastExport(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    marksFile := ""
+    names := []string{}
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--marks":
+            if i+1 >= len(args) {
+                return errors.New("--marks needs a file")
+            }
+            marksFile = args[i+1]
+            i++
+        case strings.HasPrefix(args[i], "-"):
+            return fmt.Errorf("Unknown fast-export option \"%s\"", args[i])
+        default:
+            names = append(names, args[i])
+        }
+    }
+    refs := []string{}
+    for _, name := range names {
+        ref, err := resolveRefName(name)
+        if err != nil {
+            return err
+        }
+        refs = append(refs, ref)
+    }
+    if len(names) == 0 {
+        for _, prefix := range []string{ BRANCHES_PREFIX, TAGS_PREFIX } {
+            found, err := listRefs(prefix)
+            if err != nil {
+                return err
+            }
+            refs = append(refs, found...)
+        }
+    }
+    marks, err := readMarks(marksFile)
+    if err != nil {
+        return err
+    }
+
+    ex := &exporter {
+        out: bufio.NewWriter(os.Stdout),
+        marks: marks,
+        marked: map[string]string{},
+    }
+    for mark, hash := range marks {
+        ex.marked[hash] = mark
+        if n, err := strconv.Atoi(mark[1:]); err == nil && n > ex.next {
+            ex.next = n
+        }
+    }
+    for _, ref := range refs {
+        err = ex.ref(ref)
+        if err != nil {
+            return err
+        }
+    }
+    _, err = io.WriteString(ex.out, "done\n")
+    if err == nil {
+        err = ex.out.Flush()
+    }
+    if err != nil {
+        return err
+    }
+    return writeMarks(marksFile, ex.marks)
+}
@@ -0,0 +1,845 @@
+package core
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+/////////////////
+// FAST-IMPORT //
+/////////////////
+
+// fast-import and fast-export speak git's fast-import stream, so history
+// moves between goverse and git with "git fast-export | goverse fast-import"
+// and "goverse fast-export | git fast-import". Both take "--marks <file>",
+// a mapping of stream marks to goverse hashes as ":<n> <hash>" lines that
+// is read when it exists and written back once done. Handing git the marks
+// file it kept for its side (--import-marks and --export-marks) lets a
+// later run carry on from where the last stopped.
+//
+// Git modes map to goverse ones as 100644 <-> 644, 100755 <-> 755 and
+// 120000 (a symlink) as is, submodules are skipped
+
+const (
+    GIT_FILE_MODE       = "100644"
+    GIT_EXECUTABLE_MODE = "100755"
+    GIT_SYMLINK_MODE    = "120000"
+    GIT_SUBMODULE_MODE  = "160000"
+)
+
+// git ref namespaces and the goverse ones they map to
+var GIT_REF_NAMESPACES = [][2]string{
+    {"refs/heads/", BRANCHES_PREFIX},
+    {"refs/tags/", TAGS_PREFIX},
+    {"refs/remotes/", REMOTES_PREFIX},
+}
+
+// goverseRef maps a git ref name to the goverse ref it becomes
+func goverseRef(ref string) (string, error) {
+    for _, ns := range GIT_REF_NAMESPACES {
+        if strings.HasPrefix(ref, ns[0]) {
+            name := strings.TrimPrefix(ref, ns[0])
+            if err := checkRefName(name); err != nil {
+                return "", fmt.Errorf("Unable to map git ref \"%s\": %w", ref, err)
+            }
+            return ns[1] + name, nil
+        }
+    }
+    return "", fmt.Errorf("Unable to map git ref \"%s\", only branches, tags and remotes are kept", ref)
+}
+
+// gitRef maps a goverse ref to its git name
+func gitRef(ref string) (string) {
+    for _, ns := range GIT_REF_NAMESPACES {
+        if strings.HasPrefix(ref, ns[1]) {
+            return ns[0] + strings.TrimPrefix(ref, ns[1])
+        }
+    }
+    return ref
+}
+
+// readMarks loads a marks file, a missing one is empty
+func readMarks(file string) (map[string]string, error) {
+    marks := map[string]string{}
+    if file == "" {
+        return marks, nil
+    }
+    content, err := os.ReadFile(file)
+    if errors.Is(err, fs.ErrNotExist) {
+        return marks, nil
+    }
+    if err != nil {
+        return nil, &PathError{"read marks", file, err}
+    }
+    for i, line := range strings.Split(string(content), "\n") {
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+        mark, hash, found := strings.Cut(line, " ")
+        if !found || !strings.HasPrefix(mark, ":") {
+            return nil, &PathError{"parse marks", file, fmt.Errorf("line %d is not \":<mark> <hash>\"", i + 1)}
+        }
+        marks[mark] = strings.TrimSpace(hash)
+    }
+    return marks, nil
+}
+
+// writeMarks saves marks in mark order
+func writeMarks(file string, marks map[string]string) (error) {
+    if file == "" {
+        return nil
+    }
+    names := []string{}
+    for mark := range marks {
+        names = append(names, mark)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        a, _ := strconv.Atoi(names[i][1:])
+        b, _ := strconv.Atoi(names[j][1:])
+        return a < b
+    })
+    var out strings.Builder
+    for _, mark := range names {
+        out.WriteString(mark + " " + marks[mark] + "\n")
+    }
+    err := os.WriteFile(file, []byte(out.String()), 0644)
+    if err != nil {
+        return &PathError{"write marks", file, err}
+    }
+    return nil
+}
+
+// parseGitIdent splits "<name> <<email>> <seconds> <zone>" into the
+// identity and an RFC3339 timestamp
+func parseGitIdent(ident string) (string, string, error) {
+    end := strings.LastIndex(ident, ">")
+    if end < 0 {
+        return "", "", fmt.Errorf("Bad identity \"%s\"", ident)
+    }
+    who := ident[:end+1]
+    fields := strings.Fields(ident[end+1:])
+    if len(fields) != 2 {
+        return "", "", fmt.Errorf("Bad identity \"%s\", expected a time and zone", ident)
+    }
+    seconds, err := strconv.ParseInt(fields[0], 10, 64)
+    if err != nil {
+        return "", "", fmt.Errorf("Bad time in identity \"%s\"", ident)
+    }
+    zone := fields[1]
+    offset, err := strconv.Atoi(zone)
+    if err != nil || len(zone) != 5 {
+        return "", "", fmt.Errorf("Bad zone in identity \"%s\"", ident)
+    }
+    minutes := (offset / 100) * 60 + (offset % 100)
+    when := time.Unix(seconds, 0).In(time.FixedZone("", minutes * 60))
+    return who, when.Format(time.RFC3339), nil
+}
+
+// gitQuotePath quotes a path the way the stream expects when it needs it
+func gitQuotePath(p string) (string) {
+    if strings.ContainsAny(p, "\"\\\n") || strings.HasPrefix(p, " ") {
+        return strconv.Quote(p)
+    }
+    return p
+}
+
+// gitUnquotePath reads a possibly quoted path off the front of s,
+// returning it and what follows
+func gitUnquotePath(s string) (string, string, error) {
+    if !strings.HasPrefix(s, "\"") {
+        return s, "", nil
+    }
+    for i := 1; i < len(s); i++ {
+        if s[i] == '\\' {
+            i++
+            continue
+        }
+        if s[i] == '"' {
+            p, err := strconv.Unquote(s[:i+1])
+            if err != nil {
+                return "", "", fmt.Errorf("Bad quoted path %s", s[:i+1])
+            }
+            return p, strings.TrimPrefix(s[i+1:], " "), nil
+        }
+    }
+    return "", "", fmt.Errorf("Unterminated quoted path %s", s)
+}
+
+// splitPaths reads the source and destination of a copy or rename
+func splitPaths(s string) (string, string, error) {
+    if strings.HasPrefix(s, "\"") {
+        src, rest, err := gitUnquotePath(s)
+        if err != nil {
+            return "", "", err
+        }
+        dst, _, err := gitUnquotePath(rest)
+        return src, dst, err
+    }
+    src, rest, found := strings.Cut(s, " ")
+    if !found {
+        return "", "", fmt.Errorf("\"%s\" names only one path", s)
+    }
+    dst, _, err := gitUnquotePath(rest)
+    return src, dst, err
+}
+
+type importer struct {
+    in       *bufio.Reader
+    pending  *string
+    marks    map[string]string
+    tips     map[string]string
+    force    bool
+    blobs    int
+    commits  int
+    tags     int
+    skipped  map[string]bool
+}
+
+// next returns the next line without its newline, io.EOF at the end
+func (im *importer) next() (string, error) {
+    if im.pending != nil {
+        line := *im.pending
+        im.pending = nil
+        return line, nil
+    }
+    line, err := im.in.ReadString('\n')
+    if err == io.EOF && line != "" {
+        err = nil
+    }
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSuffix(line, "\n"), nil
+}
+
+func (im *importer) unread(line string) {
+    im.pending = &line
+}
+
+// optional returns the argument of the next line when it is the given
+// command, leaving the line for later otherwise
+func (im *importer) optional(command string) (string, bool, error) {
+    line, err := im.next()
+    if err == io.EOF {
+        return "", false, nil
+    }
+    if err != nil {
+        return "", false, err
+    }
+    if strings.HasPrefix(line, command + " ") {
+        return strings.TrimPrefix(line, command + " "), true, nil
+    }
+    im.unread(line)
+    return "", false, nil
+}
+
+// data reads a "data <count>" or "data <<<delim>" block
+func (im *importer) data() ([]byte, error) {
+    line, err := im.next()
+    if err != nil {
+        return nil, fmt.Errorf("Stream ended before data: %w", err)
+    }
+    if !strings.HasPrefix(line, "data ") {
+        return nil, fmt.Errorf("Expected data, got \"%s\"", line)
+    }
+    arg := strings.TrimPrefix(line, "data ")
+    if strings.HasPrefix(arg, "<<") {
+        delim := strings.TrimPrefix(arg, "<<")
+        var out strings.Builder
+        for {
+            line, err := im.next()
+            if err != nil {
+                return nil, fmt.Errorf("Stream ended inside data <<%s: %w", delim, err)
+            }
+            if line == delim {
+                return []byte(out.String()), nil
+            }
+            out.WriteString(line + "\n")
+        }
+    }
+    n, err := strconv.Atoi(arg)
+    if err != nil || n < 0 {
+        return nil, fmt.Errorf("Bad data length \"%s\"", arg)
+    }
+    content := make([]byte, n)
+    _, err = io.ReadFull(im.in, content)
+    if err != nil {
+        return nil, fmt.Errorf("Stream ended inside %d bytes of data: %w", n, err)
+    }
+    // the newline after the data is optional
+    if b, err := im.in.Peek(1); err == nil && b[0] == '\n' {
+        im.in.ReadByte()
+    }
+    return content, nil
+}
+
+// markOf reads an optional "mark :<n>" line
+func (im *importer) markOf() (string, error) {
+    mark, found, err := im.optional("mark")
+    if err != nil || !found {
+        return "", err
+    }
+    if !strings.HasPrefix(mark, ":") {
+        return "", fmt.Errorf("Bad mark \"%s\"", mark)
+    }
+    return mark, nil
+}
+
+// skipOriginal drops an "original-oid" line, goverse hashes differ anyway
+func (im *importer) skipOriginal() (error) {
+    _, _, err := im.optional("original-oid")
+    return err
+}
+
+// object resolves a mark, a hash or a ref the stream refers to
+func (im *importer) object(name string) (string, error) {
+    if strings.HasPrefix(name, ":") {
+        hash, ok := im.marks[name]
+        if !ok {
+            return "", fmt.Errorf("Mark %s is not known: %w", name, ErrObjectNotFound)
+        }
+        return hash, nil
+    }
+    if strings.HasPrefix(name, "refs/") {
+        ref, err := goverseRef(name)
+        if err != nil {
+            return "", err
+        }
+        if tip, ok := im.tips[ref]; ok && tip != "" {
+            return tip, nil
+        }
+        return resolveCommit(ref)
+    }
+    return resolveCommit(name)
+}
+
+// tip is where a commit to ref without "from" builds on: the last commit
+// the stream made there, or the ref as the repository has it
+func (im *importer) tip(ref string) (string) {
+    if tip, ok := im.tips[ref]; ok {
+        return tip
+    }
+    hash, err := readRef(ref)
+    if err != nil {
+        return ""
+    }
+    commit, err := peelToCommit(hash)
+    if err != nil {
+        return ""
+    }
+    return commit
+}
+
+func (im *importer) blob() (error) {
+    mark, err := im.markOf()
+    if err != nil {
+        return err
+    }
+    err = im.skipOriginal()
+    if err != nil {
+        return err
+    }
+    content, err := im.data()
+    if err != nil {
+        return err
+    }
+    hash, err := im.storeBlob(content)
+    if err != nil {
+        return err
+    }
+    if mark != "" {
+        im.marks[mark] = hash
+    }
+    return nil
+}
+
+func (im *importer) storeBlob(content []byte) (string, error) {
+    b := models.Blob{ Content: content }
+    err := storeBlob(b)
+    if err != nil {
+        return "", err
+    }
+    im.blobs++
+    return hashBlob(b)
+}
+
+func (im *importer) commit(gitRef string) (error) {
+    ref, err := goverseRef(gitRef)
+    if err != nil {
+        return err
+    }
+    mark, err := im.markOf()
+    if err != nil {
+        return err
+    }
+    err = im.skipOriginal()
+    if err != nil {
+        return err
+    }
+    author, hasAuthor, err := im.optional("author")
+    if err != nil {
+        return err
+    }
+    committer, hasCommitter, err := im.optional("committer")
+    if err != nil {
+        return err
+    }
+    if !hasCommitter {
+        return fmt.Errorf("Commit to %s has no committer", gitRef)
+    }
+    if !hasAuthor {
+        author = committer
+    }
+    _, _, err = im.optional("encoding")
+    if err != nil {
+        return err
+    }
+    message, err := im.data()
+    if err != nil {
+        return err
+    }
+
+    parents := []string{}
+    from, hasFrom, err := im.optional("from")
+    if err != nil {
+        return err
+    }
+    if hasFrom {
+        parent, err := im.object(from)
+        if err != nil {
+            return err
+        }
+        parents = append(parents, parent)
+    } else if tip := im.tip(ref); tip != "" {
+        parents = append(parents, tip)
+    }
+    for {
+        merge, found, err := im.optional("merge")
+        if err != nil {
+            return err
+        }
+        if !found {
+            break
+        }
+        parent, err := im.object(merge)
+        if err != nil {
+            return err
+        }
+        parents = append(parents, parent)
+    }
+
+    files := map[string]models.IndexEntry{}
+    if len(parents) > 0 {
+        files, err = commitFiles(parents[0])
+        if err != nil {
+            return err
+        }
+    }
+    err = im.fileChanges(files)
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(filesIndex(files))
+    if err != nil {
+        return err
+    }
+    who, when, err := parseGitIdent(author)
+    if err != nil {
+        return err
+    }
+    hash, err := storeCommit(models.Commit {
+        Tree: tree,
+        Parents: parents,
+        Message: string(message),
+        Author: who,
+        Timestamp: when,
+    })
+    if err != nil {
+        return err
+    }
+    im.commits++
+    if mark != "" {
+        im.marks[mark] = hash
+    }
+    im.tips[ref] = hash
+    return nil
+}
+
+// fileChanges applies M, D, C, R and deleteall lines to files up to the
+// end of the commit
+func (im *importer) fileChanges(files map[string]models.IndexEntry) (error) {
+    for {
+        line, err := im.next()
+        if err == io.EOF || (err == nil && line == "") {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        command, rest, _ := strings.Cut(line, " ")
+        switch command {
+        case "M":
+            err = im.modify(files, rest)
+        case "D":
+            p, _, err := gitUnquotePath(rest)
+            if err == nil {
+                err = checkPath(strings.TrimSuffix(p, "/"))
+            }
+            if err != nil {
+                return err
+            }
+            removePath(files, p)
+        case "C", "R":
+            src, dst, err := splitPaths(rest)
+            if err == nil {
+                err = checkPath(strings.TrimSuffix(src, "/"))
+            }
+            if err == nil {
+                err = checkPath(strings.TrimSuffix(dst, "/"))
+            }
+            if err != nil {
+                return err
+            }
+            copyPath(files, src, dst, command == "R")
+        case "deleteall":
+            for p := range files {
+                delete(files, p)
+            }
+        default:
+            // the commit ended without its blank line
+            im.unread(line)
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// modify handles "M <mode> <dataref> <path>"
+func (im *importer) modify(files map[string]models.IndexEntry, rest string) (error) {
+    fields := strings.SplitN(rest, " ", 3)
+    if len(fields) != 3 {
+        return fmt.Errorf("Bad filemodify \"M %s\"", rest)
+    }
+    mode, ref := fields[0], fields[1]
+    p, _, err := gitUnquotePath(fields[2])
+    if err == nil {
+        err = checkPath(p)
+    }
+    if err != nil {
+        return err
+    }
+    hash := ""
+    if ref == "inline" {
+        content, err := im.data()
+        if err != nil {
+            return err
+        }
+        hash, err = im.storeBlob(content)
+        if err != nil {
+            return err
+        }
+    } else if strings.HasPrefix(ref, ":") {
+        var found bool
+        hash, found = im.marks[ref]
+        if !found {
+            return fmt.Errorf("Mark %s for %s is not known: %w", ref, p, ErrObjectNotFound)
+        }
+    } else if mode != GIT_SUBMODULE_MODE {
+        if !isHex(ref) || !objectExists(ref) {
+            return fmt.Errorf("Blob %s for %s is not here: %w", ref, p, ErrObjectNotFound)
+        }
+        hash = ref
+    }
+
+    switch strings.TrimLeft(mode, "0") {
+    case strings.TrimLeft(GIT_FILE_MODE, "0"), FILE_MODE:
+        mode = FILE_MODE
+    case strings.TrimLeft(GIT_EXECUTABLE_MODE, "0"), EXECUTABLE_MODE:
+        mode = EXECUTABLE_MODE
+    case GIT_SYMLINK_MODE:
+        mode = SYMLINK_MODE
+    case GIT_SUBMODULE_MODE:
+        if !im.skipped[p] {
+            fmt.Printf("warning: skipping submodule %s\n", p)
+            im.skipped[p] = true
+        }
+        return nil
+    default:
+        return fmt.Errorf("Unsupported mode %s for %s", mode, p)
+    }
+    // a file replaces a directory of the same name
+    removePath(files, p)
+    files[p] = models.IndexEntry{ Path: p, Mode: mode, Hash: hash }
+    return nil
+}
+
+// removePath deletes the file p or everything below the directory p
+func removePath(files map[string]models.IndexEntry, p string) {
+    p = strings.TrimSuffix(p, "/")
+    for name := range files {
+        if name == p || strings.HasPrefix(name, p + "/") {
+            delete(files, name)
+        }
+    }
+}
+
+// copyPath copies the file or directory src to dst, moving it for a rename
+func copyPath(files map[string]models.IndexEntry, src string, dst string, move bool) {
+    src, dst = strings.TrimSuffix(src, "/"), strings.TrimSuffix(dst, "/")
+    copied := map[string]models.IndexEntry{}
+    for name, entry := range files {
+        if name == src || strings.HasPrefix(name, src + "/") {
+            target := dst + strings.TrimPrefix(name, src)
+            copied[target] = models.IndexEntry{ Path: target, Mode: entry.Mode, Hash: entry.Hash }
+        }
+    }
+    if move {
+        removePath(files, src)
+    }
+    removePath(files, dst)
+    for name, entry := range copied {
+        files[name] = entry
+    }
+}
+
+func (im *importer) tag(name string) (error) {
+    err := checkRefName(name)
+    if err != nil {
+        return err
+    }
+    mark, err := im.markOf()
+    if err != nil {
+        return err
+    }
+    from, found, err := im.optional("from")
+    if err != nil {
+        return err
+    }
+    if !found {
+        return fmt.Errorf("Tag %s has no from", name)
+    }
+    target, err := im.object(from)
+    if err != nil {
+        return err
+    }
+    err = im.skipOriginal()
+    if err != nil {
+        return err
+    }
+    tagger, hasTagger, err := im.optional("tagger")
+    if err != nil {
+        return err
+    }
+    message, err := im.data()
+    if err != nil {
+        return err
+    }
+    who, when := getIdentity(), time.Now().Format(time.RFC3339)
+    if hasTagger {
+        who, when, err = parseGitIdent(tagger)
+        if err != nil {
+            return err
+        }
+    }
+    version := firstLine(string(message))
+    if version == "" {
+        version = name
+    }
+    hash, err := storeTagObject(models.Tag {
+        Name: name,
+        Version: version,
+        Commit: target,
+        Tagger: who,
+        Timestamp: when,
+    })
+    if err != nil {
+        return err
+    }
+    im.tags++
+    if mark != "" {
+        im.marks[mark] = hash
+    }
+    im.tips[TAGS_PREFIX + name] = hash
+    return nil
+}
+
+func (im *importer) reset(gitRef string) (error) {
+    ref, err := goverseRef(gitRef)
+    if err != nil {
+        return err
+    }
+    from, found, err := im.optional("from")
+    if err != nil {
+        return err
+    }
+    im.tips[ref] = ""
+    if found {
+        im.tips[ref], err = im.object(from)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// run reads the stream command by command up to its end or "done"
+func (im *importer) run() (error) {
+    for {
+        line, err := im.next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        command, arg, _ := strings.Cut(line, " ")
+        switch command {
+        case "", "#", "checkpoint", "feature", "option":
+        case "blob":
+            err = im.blob()
+        case "commit":
+            err = im.commit(arg)
+        case "tag":
+            err = im.tag(arg)
+        case "reset":
+            err = im.reset(arg)
+        case "progress":
+            fmt.Println("progress " + arg)
+        case "done":
+            return nil
+        default:
+            if strings.HasPrefix(command, "#") {
+                continue
+            }
+            return fmt.Errorf("Unsupported fast-import command \"%s\"", line)
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// updateRefs moves every ref the stream touched, refusing to lose commits
+// on one unless forced
+func (im *importer) updateRefs() (error) {
+    refs := []string{}
+    for ref := range im.tips {
+        refs = append(refs, ref)
+    }
+    sort.Strings(refs)
+    refused := []string{}
+    for _, ref := range refs {
+        hash := im.tips[ref]
+        if hash == "" {
+            continue
+        }
+        old, err := readRef(ref)
+        if err != nil {
+            old = ""
+        }
+        if old == hash {
+            continue
+        }
+        if old != "" && !im.force {
+            oldCommit, err1 := peelToCommit(old)
+            newCommit, err2 := peelToCommit(hash)
+            if err1 != nil || err2 != nil || !isAncestor(oldCommit, newCommit) {
+                fmt.Printf("warning: not updating %s, it would lose commits (use --force)\n", shortRef(ref))
+                refused = append(refused, shortRef(ref))
+                continue
+            }
+        }
+        expected := old
+        if old == "" {
+            expected = ZERO_HASH
+        }
+        err = updateRef(ref, hash, expected, REASON_FAST_IMPORT, "fast-import")
+        if err != nil {
+            return err
+        }
+    }
+    if len(refused) > 0 {
+        return fmt.Errorf("Refused to update %s: %w", strings.Join(refused, ", "), ErrNotFastForward)
+    }
+    return nil
+}
+
+// FastImport reads a fast-import stream from file, or stdin when none is
+// given, into the repository: "fast-import [--marks <file>] [--force]
+// [<file>]". "--force" lets a branch move to a commit that drops some of
+// its history
+func FastImport(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    marksFile, force, source := "", false, ""
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--marks":
+            if i+1 >= len(args) {
+                return errors.New("--marks needs a file")
+            }
+            marksFile = args[i+1]
+            i++
+        case args[i] == "--force":
+            force = true
+        case strings.HasPrefix(args[i], "-"):
+            return fmt.Errorf("Unknown fast-import option \"%s\"", args[i])
+        case source == "":
+            source = args[i]
+        default:
+            return errors.New("fast-import reads one stream")
+        }
+    }
+    var in io.Reader = os.Stdin
+    if source != "" {
+        f, err := os.Open(source)
+        if err != nil {
+            return &PathError{"open", source, err}
+        }
+        defer f.Close()
+        in = f
+    }
+    marks, err := readMarks(marksFile)
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    im := &importer {
+        in: bufio.NewReader(in),
+        marks: marks,
+        tips: map[string]string{},
+        force: force,
+        skipped: map[string]bool{},
+    }
+    err = im.run()
+    if err != nil {
+        return err
+    }
+    // keep the marks even when a ref is refused, the objects are stored
+    refErr := im.updateRefs()
+    err = writeMarks(marksFile, im.marks)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Imported %s, %s and %s\n", plural(im.blobs, "blob"), plural(im.commits, "commit"), plural(im.tags, "tag"))
+    return refErr
+}
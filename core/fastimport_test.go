@@ -0,0 +1,170 @@
+package core
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestGoverseRef(t *testing.T) {
+    tests := []struct {
+        ref  string
+        want string
+        ok   bool
+    }{
+        {"refs/heads/main", BRANCHES_PREFIX + "main", true},
+        {"refs/heads/feature/x", BRANCHES_PREFIX + "feature/x", true},
+        {"refs/tags/v1.0", TAGS_PREFIX + "v1.0", true},
+        {"refs/remotes/origin/main", REMOTES_PREFIX + "origin/main", true},
+        {"refs/notes/commits", "", false},
+        {"refs/heads/../../../fi_gv", "", false},
+        {"refs/heads/", "", false},
+        {"refs/tags/-v1", "", false},
+    }
+    for _, tt := range tests {
+        got, err := goverseRef(tt.ref)
+        if (err == nil) != tt.ok || got != tt.want {
+            t.Errorf("goverseRef(%q) = %q, %v, want %q, ok %v", tt.ref, got, err, tt.want, tt.ok)
+        }
+    }
+}
+
+func TestSwapRefStaysInsideGoverse(t *testing.T) {
+    dir := testRepo(t)
+    head, err := getHead()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ref := range []string{"../../fi_gv", "branches/../../../fi_gv", "/tmp/fi_gv", ""} {
+        err := updateRef(ref, head, "", REASON_FAST_IMPORT, "test")
+        if !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("updateRef(%q) = %v, want ErrUnsafePath", ref, err)
+        }
+        if err := deleteRef(ref); !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("deleteRef(%q) = %v, want ErrUnsafePath", ref, err)
+        }
+    }
+    mustNotExist(t, filepath.Join(filepath.Dir(filepath.Clean(dir)), "fi_gv"))
+}
+
+func TestFastImportRefusesEscapes(t *testing.T) {
+    header := "committer A <a@example.com> 1700000000 +0000\ndata 2\nx\n"
+    tests := []struct {
+        name   string
+        stream string
+        ok     bool
+        err    error
+    }{
+        {"plain commit", "commit refs/heads/main\n" + header + "M 100644 inline file\ndata 3\nok\n\n", true, nil},
+        {"ref climbing out", "commit refs/heads/../../../fi_gv\n" + header + "M 100644 inline file\ndata 3\nok\n\n", false, nil},
+        {"path climbing out", "commit refs/heads/main\n" + header + "M 100644 inline ../fi_file_gv\ndata 3\nok\n\n", false, ErrUnsafePath},
+        {"path into .goverse", "commit refs/heads/main\n" + header + "M 100644 inline .goverse/head\ndata 3\nok\n\n", false, ErrUnsafePath},
+        {"rename out", "commit refs/heads/main\n" + header + "M 100644 inline file\ndata 3\nok\nR file ../fi_file_gv\n\n", false, ErrUnsafePath},
+        {"tag climbing out", "tag ../../fi_gv\nfrom refs/heads/main\ntagger A <a@example.com> 1700000000 +0000\ndata 2\nx\n", false, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := testRepo(t)
+            stream := filepath.Join(t.TempDir(), "stream")
+            err := os.WriteFile(stream, []byte(tt.stream), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = FastImport([]string{ "--force", stream })
+            if (err == nil) != tt.ok {
+                t.Fatalf("fast-import = %v, want ok %v", err, tt.ok)
+            }
+            if tt.err != nil && !errors.Is(err, tt.err) {
+                t.Fatalf("fast-import = %v, want %v", err, tt.err)
+            }
+            parent := filepath.Dir(filepath.Clean(dir))
+            mustNotExist(t, filepath.Join(parent, "fi_gv"))
+            mustNotExist(t, filepath.Join(parent, "fi_file_gv"))
+        })
+    }
+}
+
+func TestFastExportImportRoundTrip(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    writeTestFile(t, "dir/sub", "sub\n")
+    commitAll(t, "one\n\nwith a body")
+    err := Branch([]string{ "topic" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "run.sh", "#!/bin/sh\n")
+    err = os.Chmod(BaseDir + "run.sh", 0755)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Symlink("file", BaseDir + "link")
+    if err != nil {
+        t.Fatal(err)
+    }
+    commitAll(t, "two")
+    err = Tag([]string{ "v1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = os.Remove(BaseDir + "dir/sub")
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "file", "three\n")
+    three := commitAll(t, "three")
+    // a merge of topic, which export has to write with both parents
+    c, err := deserializeCommit(three)
+    if err != nil {
+        t.Fatal(err)
+    }
+    topic, _ := resolveRef(BRANCHES_PREFIX + "topic")
+    merge, err := storeCommit(models.Commit{ Tree: c.Tree, Parents: []string{ three, topic }, Message: "merge topic", Author: c.Author, Timestamp: c.Timestamp })
+    if err == nil {
+        err = updateRef(BRANCHES_PREFIX + DEFAULT_BRANCH, merge, three, REASON_MERGE, "merge topic")
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    refs := map[string]string{}
+    for _, prefix := range []string{ BRANCHES_PREFIX, TAGS_PREFIX } {
+        found, err := listRefs(prefix)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, ref := range found {
+            refs[ref], _ = resolveRef(ref)
+        }
+    }
+    if len(refs) != 3 {
+        t.Fatalf("exporting %v, want main, topic and v1", refs)
+    }
+    stream, err := captureStdout(t, func() (error) {
+        return FastExport(nil)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    file := filepath.Join(t.TempDir(), "stream")
+    err = os.WriteFile(file, []byte(stream), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // the fresh repository's own init commit is replaced
+    testRepo(t)
+    err = FastImport([]string{ "--force", file })
+    if err != nil {
+        t.Fatal(err)
+    }
+    for ref, want := range refs {
+        got, err := resolveRef(ref)
+        if err != nil || got != want {
+            t.Errorf("%s = %s, %v after the round trip, want %s", ref, got, err, want)
+        }
+    }
+}
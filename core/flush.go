@@ -0,0 +1,173 @@
+package core
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// FLUSH //
+///////////
+
+// Flush deletes the whole .goverse directory, or with "--index",
+// "--reflogs" or "--objects" only resets the index to HEAD, drops every
+// reflog or drops objects nothing reaches. "--backup=<file>" first writes
+// .goverse to a tar.gz, and confirm is asked before anything is touched
+// unless "--force" is given
+func Flush(args []string, confirm func(prompt string) bool) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    force, backup := false, ""
+    scopes := []string{}
+    for _, arg := range args {
+        switch {
+        case arg == "--force" || arg == "-f":
+            force = true
+        case strings.HasPrefix(arg, "--backup="):
+            backup = strings.TrimPrefix(arg, "--backup=")
+        case arg == "--index" || arg == "--reflogs" || arg == "--objects":
+            scopes = append(scopes, strings.TrimPrefix(arg, "--"))
+        default:
+            return fmt.Errorf("Unknown flush option \"%s\"", arg)
+        }
+    }
+
+    prompt := "Delete all goverse files in " + BaseDir + GOVERSE_DIR + ", including every commit?"
+    if len(scopes) > 0 {
+        prompt = "Flush the " + strings.Join(scopes, ", ") + " of " + BaseDir + GOVERSE_DIR + "?"
+    }
+    if !force && (confirm == nil || !confirm(prompt)) {
+        return fmt.Errorf("Flush cancelled, nothing was deleted")
+    }
+
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if backup != "" {
+        err := backupGoverse(backup)
+        if err != nil {
+            return fmt.Errorf("Unable to back up, nothing was deleted: %w", err)
+        }
+        fmt.Printf("Backed up %s to %s\n", GOVERSE_DIR, backup)
+    }
+
+    if len(scopes) == 0 {
+        err = os.RemoveAll(BaseDir + GOVERSE_DIR)
+        if err != nil {
+            return &PathError{"remove", BaseDir + GOVERSE_DIR, err}
+        }
+        return nil
+    }
+    for _, scope := range scopes {
+        switch scope {
+        case "index":
+            err = resetIndexToHead()
+        case "reflogs":
+            err = os.RemoveAll(BaseDir + LOGS_DIR)
+            if err != nil {
+                err = &PathError{"remove", BaseDir + LOGS_DIR, err}
+            }
+        case "objects":
+            err = Gc([]string{ "--prune=now" })
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// resetIndexToHead stages exactly what HEAD holds, or nothing on an unborn branch
+func resetIndexToHead() (error) {
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    idx := models.Index{}
+    if head != "" {
+        c, err := deserializeCommit(head)
+        if err != nil {
+            return err
+        }
+        idx, err = indexFromTree(c.Tree)
+        if err != nil {
+            return err
+        }
+    }
+    return writeIndex(idx)
+}
+
+// backupGoverse writes the .goverse directory to a gzipped tarball at path,
+// leaving out the lock this process holds
+func backupGoverse(path string) (error) {
+    file, err := os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+    if err != nil {
+        return &PathError{"create backup", path, err}
+    }
+    gz := gzip.NewWriter(file)
+    tw := tar.NewWriter(gz)
+    err = filepath.WalkDir(BaseDir + GOVERSE, func(name string, entry fs.DirEntry, err error) (error) {
+        if err != nil {
+            return err
+        }
+        rel, err := filepath.Rel(BaseDir, name)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if rel == REPO_LOCK_FILE {
+            return nil
+        }
+        info, err := entry.Info()
+        if err != nil {
+            return err
+        }
+        header, err := tar.FileInfoHeader(info, "")
+        if err != nil {
+            return err
+        }
+        header.Name = rel
+        if entry.IsDir() {
+            header.Name += "/"
+        }
+        err = tw.WriteHeader(header)
+        if err != nil || !entry.Type().IsRegular() {
+            return err
+        }
+        src, err := os.Open(name)
+        if err != nil {
+            return err
+        }
+        defer src.Close()
+        _, err = io.Copy(tw, src)
+        return err
+    })
+    if err == nil {
+        err = tw.Close()
+    }
+    if err == nil {
+        err = gz.Close()
+    }
+    if err == nil {
+        err = file.Sync()
+    }
+    file.Close()
+    if err != nil {
+        os.Remove(path)
+        return &PathError{"write backup", path, err}
+    }
+    return nil
+}
@@ -0,0 +1,183 @@
+package core
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "io"
+    "os"
+    "strings"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+// flushRepo commits "file" twice and moves main back to the first, so the
+// second commit is only in the reflog. It also stages "staged" without
+// committing it and stores a blob nothing points at
+func flushRepo(t *testing.T) (string, string, string, string) {
+    t.Helper()
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    one := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    logged := commitAll(t, "two")
+    err := updateRef(BRANCHES_PREFIX + DEFAULT_BRANCH, one, logged, REASON_RESET, "moving to HEAD~1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "staged", "staged\n")
+    err = Add("staged")
+    if err != nil {
+        t.Fatal(err)
+    }
+    staged, _ := hashBlob(models.Blob{ Content: []byte("staged\n") })
+    dangling := storeTestBlob(t, "dangling\n")
+    return logged, staged, dangling, BaseDir
+}
+
+func TestFlush(t *testing.T) {
+    tests := []struct {
+        name string
+        args []string
+        // what is left afterwards
+        goverse  bool
+        staged   bool
+        reflogs  bool
+        logged   bool
+        dangling bool
+    }{
+        {"index", []string{ "--index" }, true, false, true, true, true},
+        {"reflogs", []string{ "--reflogs" }, true, true, false, true, true},
+        {"objects", []string{ "--objects" }, true, true, true, true, false},
+        {"reflogs then objects", []string{ "--reflogs", "--objects" }, true, true, false, false, false},
+        {"everything", nil, false, false, false, false, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            logged, staged, dangling, dir := flushRepo(t)
+            prompts := []string{}
+            err := Flush(tt.args, func(prompt string) (bool) {
+                prompts = append(prompts, prompt)
+                return true
+            })
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(prompts) != 1 {
+                t.Errorf("asked %q, want one confirmation", prompts)
+            }
+            if got := readTestFile(t, "file"); got != "two\n" {
+                t.Errorf("the work tree file became %q", got)
+            }
+            if _, err := os.Stat(dir + GOVERSE_DIR); err == nil != tt.goverse {
+                t.Fatalf("%s exists: %v, want %v", GOVERSE_DIR, err == nil, tt.goverse)
+            }
+            if !tt.goverse {
+                return
+            }
+            idx, err := readIndex()
+            if err != nil {
+                t.Fatal(err)
+            }
+            inIndex := false
+            for _, entry := range idx.Entries {
+                inIndex = inIndex || entry.Path == "staged"
+            }
+            if inIndex != tt.staged {
+                t.Errorf("staged is in the index: %v, want %v", inIndex, tt.staged)
+            }
+            entries, _ := readReflog(HEAD_REF)
+            if len(entries) > 0 != tt.reflogs {
+                t.Errorf("HEAD has %d reflog entries, want some: %v", len(entries), tt.reflogs)
+            }
+            kept := map[string]bool{ logged: tt.logged, dangling: tt.dangling, staged: true }
+            for hash, want := range kept {
+                if objectExists(hash) != want {
+                    t.Errorf("object %s exists: %v, want %v", hash, !want, want)
+                }
+            }
+        })
+    }
+}
+
+func TestFlushNeedsConfirmation(t *testing.T) {
+    for _, confirm := range []func(string) bool{ nil, func(string) (bool) { return false } } {
+        logged, _, dangling, dir := flushRepo(t)
+        err := Flush(nil, confirm)
+        if err == nil {
+            t.Fatal("flush went ahead without a yes")
+        }
+        if !objectExists(logged) || !objectExists(dangling) {
+            t.Fatalf("a refused flush deleted objects")
+        }
+        if _, err := os.Stat(dir + GOVERSE_DIR); err != nil {
+            t.Fatalf("a refused flush removed %s: %v", GOVERSE_DIR, err)
+        }
+    }
+    // --force needs no one to ask
+    _, _, _, dir := flushRepo(t)
+    err := Flush([]string{ "--force" }, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(dir + GOVERSE_DIR); err == nil {
+        t.Fatal("flush --force left the repository")
+    }
+}
+
+func TestFlushBackup(t *testing.T) {
+    _, _, _, dir := flushRepo(t)
+    head, _ := os.ReadFile(dir + HEAD_FILE)
+    backup := t.TempDir() + "/backup.tar.gz"
+    err := Flush([]string{ "--force", "--backup=" + backup }, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(dir + GOVERSE_DIR); err == nil {
+        t.Fatal("flush left the repository after backing it up")
+    }
+
+    file, err := os.Open(backup)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    gz, err := gzip.NewReader(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    names := map[string]string{}
+    tr := tar.NewReader(gz)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        content, _ := io.ReadAll(tr)
+        names[header.Name] = string(content)
+    }
+    if got, ok := names[HEAD_FILE]; !ok || got != string(head) {
+        t.Errorf("the backup holds HEAD as %q, want %q", got, head)
+    }
+    if _, ok := names[REPO_LOCK_FILE]; ok {
+        t.Error("the backup holds the repository lock")
+    }
+    for name := range names {
+        if !strings.HasPrefix(name, GOVERSE) {
+            t.Errorf("the backup holds %s from outside %s", name, GOVERSE)
+        }
+    }
+
+    // an existing backup is never overwritten, and nothing is deleted
+    _, _, _, dir = flushRepo(t)
+    err = Flush([]string{ "--force", "--backup=" + backup }, nil)
+    if err == nil {
+        t.Fatal("flush overwrote an existing backup")
+    }
+    if _, err := os.Stat(dir + GOVERSE_DIR); err != nil {
+        t.Fatalf("a failed backup still removed %s: %v", GOVERSE_DIR, err)
+    }
+}
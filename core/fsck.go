@@ -0,0 +1,257 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+)
+
+//////////
+// FSCK //
+//////////
+
+// an object somebody points at, remembered so a missing one can say who wanted it
+type fsckReference struct {
+    from string
+    kind string
+}
+
+type fsckState struct {
+    kinds      map[string]string
+    referenced map[string]fsckReference
+    corrupt    int
+    missing    int
+    broken     int
+}
+
+func (st *fsckState) reportCorrupt(hash string, err error) {
+    st.corrupt++
+    fmt.Printf("corrupt %s: %v\n", hash, err)
+}
+
+// expect records that from points at hash, which should be of kind
+func (st *fsckState) expect(from string, hash string, kinds ...string) {
+    if _, ok := st.referenced[hash]; !ok {
+        st.referenced[hash] = fsckReference{from, kinds[0]}
+    }
+    kind, ok := st.kinds[hash]
+    if !ok {
+        return
+    }
+    for _, want := range kinds {
+        if kind == want {
+            return
+        }
+    }
+    st.broken++
+    fmt.Printf("broken link from %s %s to %s %s, expected a %s\n", st.kinds[from], from, kind, hash, kinds[0])
+}
+
+// Fsck rehashes every object, checks every link between objects and from
+// refs, and reports dangling objects, the error says which problem was worst
+func Fsck(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(args) > 0 {
+        return fmt.Errorf("Unknown fsck option \"%s\"", args[0])
+    }
+    st := &fsckState{
+        kinds: map[string]string{},
+        referenced: map[string]fsckReference{},
+    }
+
+    // every object must hash to its name
+    entries, err := os.ReadDir(BaseDir + OBJECTS_DIR)
+    if err != nil {
+        return &PathError{"read dir", BaseDir + OBJECTS_DIR, err}
+    }
+    hashes := []string{}
+    for _, entry := range entries {
+        if entry.IsDir() {
+            continue
+        }
+        if !isHex(entry.Name()) || len(entry.Name()) != len(ZERO_HASH) {
+            fmt.Printf("garbage file %s\n", OBJECTS_DIR + entry.Name())
+            continue
+        }
+        hashes = append(hashes, entry.Name())
+    }
+    packed, err := packedObjects()
+    if err != nil {
+        st.reportCorrupt("pack", err)
+    }
+    for _, hash := range packed {
+        if !objectIsLoose(hash) {
+            hashes = append(hashes, hash)
+        }
+    }
+    sort.Strings(hashes)
+    for _, hash := range hashes {
+        kind, payload, err := readObject(hash)
+        if err != nil {
+            st.reportCorrupt(hash, err)
+            continue
+        }
+        actual, err := hashObject(kind, payload)
+        if err != nil {
+            st.reportCorrupt(hash, err)
+            continue
+        }
+        if actual != hash {
+            st.reportCorrupt(hash, fmt.Errorf("%s content hashes to %s", kind, actual))
+            continue
+        }
+        st.kinds[hash] = kind
+    }
+
+    // every link must land on an object of the right kind
+    for _, hash := range hashes {
+        switch st.kinds[hash] {
+        case COMMIT:
+            c, err := deserializeCommit(hash)
+            if err != nil {
+                st.reportCorrupt(hash, err)
+                continue
+            }
+            st.expect(hash, c.Tree, TREE)
+            for _, parent := range c.Parents {
+                st.expect(hash, parent, COMMIT)
+            }
+        case TREE:
+            t, err := deserializeTree(hash)
+            if err != nil {
+                st.reportCorrupt(hash, err)
+                continue
+            }
+            for _, entry := range t.Entries {
+                if entry.IsBlob {
+                    st.expect(hash, entry.Hash, BLOB)
+                } else {
+                    st.expect(hash, entry.Hash, TREE)
+                }
+            }
+        case TAG:
+            t, err := deserializeTag(hash)
+            if err != nil {
+                st.reportCorrupt(hash, err)
+                continue
+            }
+            st.expect(hash, t.Commit, COMMIT, TAG)
+        }
+    }
+    referenced := []string{}
+    for hash := range st.referenced {
+        referenced = append(referenced, hash)
+    }
+    sort.Strings(referenced)
+    for _, hash := range referenced {
+        if _, ok := st.kinds[hash]; !ok {
+            ref := st.referenced[hash]
+            st.missing++
+            fmt.Printf("missing %s %s, referenced by %s %s\n", ref.kind, hash, st.kinds[ref.from], ref.from)
+        }
+    }
+
+    // refs must point at commits, tags may point at tag objects too
+    refs, err := allRefs()
+    if err != nil {
+        return err
+    }
+    for _, ref := range refs {
+        hash, err := resolveRef(ref)
+        if err != nil {
+            // HEAD on a branch with no commits yet
+            if errors.Is(err, fs.ErrNotExist) {
+                continue
+            }
+            return err
+        }
+        kind, ok := st.kinds[hash]
+        switch {
+        case !ok:
+            st.missing++
+            fmt.Printf("missing commit %s, pointed to by %s\n", hash, displayRef(ref))
+        case kind != COMMIT && !(kind == TAG && strings.HasPrefix(ref, TAGS_PREFIX)):
+            st.broken++
+            fmt.Printf("broken ref %s points to %s %s\n", displayRef(ref), kind, hash)
+        }
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    for _, entry := range idx.Entries {
+        if _, ok := st.kinds[entry.Hash]; !ok {
+            st.missing++
+            fmt.Printf("missing blob %s, staged as %s\n", entry.Hash, entry.Path)
+        }
+    }
+
+    // whatever no root reaches and no object points at is dangling
+    roots, err := gcRoots()
+    if err != nil {
+        return err
+    }
+    reachable := map[string]bool{}
+    for _, root := range roots {
+        markPresent(root, st.kinds, reachable)
+    }
+    dangling := 0
+    for _, hash := range hashes {
+        kind, ok := st.kinds[hash]
+        if !ok || reachable[hash] {
+            continue
+        }
+        if _, pointedAt := st.referenced[hash]; !pointedAt {
+            dangling++
+            fmt.Printf("dangling %s %s\n", kind, hash)
+        }
+    }
+
+    fmt.Printf("checked %d objects\n", len(hashes))
+    switch {
+    case st.corrupt > 0:
+        return fmt.Errorf("%d corrupt objects: %w", st.corrupt, ErrCorruptObject)
+    case st.missing > 0 || st.broken > 0:
+        return fmt.Errorf("%d missing objects, %d broken links: %w", st.missing, st.broken, ErrObjectNotFound)
+    case dangling > 0:
+        return fmt.Errorf("%d dangling objects: %w", dangling, ErrDangling)
+    }
+    return nil
+}
+
+// markPresent is markReachable over objects fsck already verified, it
+// steps around anything missing instead of failing
+func markPresent(hash string, kinds map[string]string, seen map[string]bool) {
+    stack := []string{ hash }
+    for len(stack) > 0 {
+        hash := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        if seen[hash] {
+            continue
+        }
+        seen[hash] = true
+        switch kinds[hash] {
+        case COMMIT:
+            if c, err := deserializeCommit(hash); err == nil {
+                stack = append(stack, c.Tree)
+                stack = append(stack, c.Parents...)
+            }
+        case TAG:
+            if t, err := deserializeTag(hash); err == nil {
+                stack = append(stack, t.Commit)
+            }
+        case TREE:
+            if t, err := deserializeTree(hash); err == nil {
+                for _, entry := range t.Entries {
+                    stack = append(stack, entry.Hash)
+                }
+            }
+        }
+    }
+}
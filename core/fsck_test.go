@@ -0,0 +1,54 @@
+package core
+
+import (
+    "errors"
+    "os"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestFsck(t *testing.T) {
+    tests := []struct {
+        name  string
+        args  []string
+        setup func(t *testing.T)
+        ok    bool
+        err   error
+    }{
+        {"clean", nil, func(t *testing.T) {}, true, nil},
+        {"unknown option", []string{ "--full" }, func(t *testing.T) {}, false, nil},
+        {"dangling blob", nil, func(t *testing.T) {
+            storeTestBlob(t, "nobody points here\n")
+        }, false, ErrDangling},
+        {"corrupt object", nil, func(t *testing.T) {
+            blob, _ := hashBlob(models.Blob{ Content: []byte("content\n") })
+            err := os.WriteFile(BaseDir + OBJECTS_DIR + blob, []byte("blob 8\x00tampered"), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }, false, ErrCorruptObject},
+        {"missing object", nil, func(t *testing.T) {
+            blob, _ := hashBlob(models.Blob{ Content: []byte("content\n") })
+            err := os.Remove(BaseDir + OBJECTS_DIR + blob)
+            if err != nil {
+                t.Fatal(err)
+            }
+        }, false, ErrObjectNotFound},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            testRepo(t)
+            writeTestFile(t, "file", "content\n")
+            commitAll(t, "file")
+            tt.setup(t)
+            err := Fsck(tt.args)
+            if (err == nil) != tt.ok {
+                t.Fatalf("fsck = %v, want ok %v", err, tt.ok)
+            }
+            if tt.err != nil && !errors.Is(err, tt.err) {
+                t.Fatalf("fsck = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
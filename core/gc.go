@@ -0,0 +1,290 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+////////
+// GC //
+////////
+
+// loose objects younger than this survive gc even when unreachable, so a
+// commit still being written by another process is never pulled out from
+// under it, see "gc.pruneExpire"
+const DEFAULT_PRUNE_EXPIRE = "14d"
+
+// every namespace under .goverse/ that holds refs
+var REF_NAMESPACES = []string{ BRANCHES_PREFIX, TAGS_PREFIX, REMOTES_PREFIX }
+
+// allRefs lists HEAD, the stash when there is one, and every ref in
+// REF_NAMESPACES
+func allRefs() ([]string, error) {
+    refs := []string{ HEAD_REF }
+    if refExists(STASH_REF) {
+        refs = append(refs, STASH_REF)
+    }
+    for _, namespace := range REF_NAMESPACES {
+        found, err := listRefs(namespace)
+        if err != nil {
+            return nil, err
+        }
+        refs = append(refs, found...)
+    }
+    return refs, nil
+}
+
+// gcRoots lists every object something still points at: refs, reflog
+// entries and the index
+func gcRoots() ([]string, error) {
+    roots := []string{}
+    refs, err := allRefs()
+    if err != nil {
+        return nil, err
+    }
+    for _, ref := range refs {
+        hash, err := resolveRef(ref)
+        if err != nil {
+            if errors.Is(err, fs.ErrNotExist) {
+                continue
+            }
+            return nil, err
+        }
+        roots = append(roots, hash)
+    }
+
+    logs, err := listReflogs()
+    if err != nil {
+        return nil, err
+    }
+    for _, ref := range logs {
+        entries, err := readReflog(ref)
+        if err != nil {
+            return nil, err
+        }
+        for _, entry := range entries {
+            for _, hash := range []string{ entry.Old, entry.New } {
+                if hash != ZERO_HASH {
+                    roots = append(roots, hash)
+                }
+            }
+        }
+    }
+
+    idx, err := readIndex()
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range idx.Entries {
+        roots = append(roots, entry.Hash)
+    }
+    return roots, nil
+}
+
+// markReachable adds hash and everything it points at to seen
+func markReachable(hash string, seen map[string]bool) (error) {
+    stack := []string{ hash }
+    for len(stack) > 0 {
+        hash := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        if seen[hash] {
+            continue
+        }
+        seen[hash] = true
+
+        kind, _, err := readObject(hash)
+        if err != nil {
+            return err
+        }
+        switch kind {
+        case COMMIT:
+            c, err := deserializeCommit(hash)
+            if err != nil {
+                return err
+            }
+            stack = append(stack, c.Tree)
+            stack = append(stack, c.Parents...)
+        case TAG:
+            t, err := deserializeTag(hash)
+            if err != nil {
+                return err
+            }
+            stack = append(stack, t.Commit)
+        case TREE:
+            t, err := deserializeTree(hash)
+            if err != nil {
+                return err
+            }
+            for _, entry := range t.Entries {
+                stack = append(stack, entry.Hash)
+            }
+        }
+    }
+    return nil
+}
+
+// reachableObjects returns every object reachable from the gc roots
+func reachableObjects() (map[string]bool, error) {
+    roots, err := gcRoots()
+    if err != nil {
+        return nil, err
+    }
+    seen := map[string]bool{}
+    for _, root := range roots {
+        err := markReachable(root, seen)
+        if err != nil {
+            return nil, err
+        }
+    }
+    return seen, nil
+}
+
+// looseObjects lists every object stored as its own file
+func looseObjects() ([]fs.DirEntry, error) {
+    entries, err := os.ReadDir(BaseDir + OBJECTS_DIR)
+    if err != nil {
+        return nil, &PathError{"read dir", BaseDir + OBJECTS_DIR, err}
+    }
+    objects := []fs.DirEntry{}
+    for _, entry := range entries {
+        if !entry.IsDir() && isHex(entry.Name()) {
+            objects = append(objects, entry)
+        }
+    }
+    return objects, nil
+}
+
+// loosenPacked writes every unreachable packed object still inside the
+// grace period out as a loose object dated like its pack, so dropping the
+// old packs on repack prunes only what gc would have. A pack is as old as
+// the repack that wrote it, when everything in it was still reachable
+func loosenPacked(reachable map[string]bool, grace time.Duration, now time.Time) (int, error) {
+    index, err := loadPackIndex()
+    if err != nil {
+        return 0, err
+    }
+    count := 0
+    for hash, entry := range index {
+        if reachable[hash] || objectIsLoose(hash) {
+            continue
+        }
+        info, err := os.Stat(entry.file)
+        if err != nil {
+            return count, &PathError{"stat pack", entry.file, err}
+        }
+        if grace != 0 && now.Sub(info.ModTime()) >= grace {
+            continue
+        }
+        kind, payload, err := readObject(hash)
+        if err != nil {
+            return count, err
+        }
+        err = writeLooseObject(kind, hash, payload)
+        if err != nil {
+            return count, err
+        }
+        os.Chtimes(BaseDir + OBJECTS_DIR + hash, info.ModTime(), info.ModTime())
+        count++
+    }
+    return count, nil
+}
+
+// Gc deletes unreachable loose objects older than the grace period:
+// "--dry-run" only reports them, "--prune=<age>" overrides gc.pruneExpire
+// ("now" or "never" work too) and "--repack" packs what is left
+func Gc(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    dryRun, doRepack := false, false
+    pruneExpire := getConfig("gc.pruneExpire", DEFAULT_PRUNE_EXPIRE)
+    for _, arg := range args {
+        switch {
+        case arg == "--dry-run" || arg == "-n":
+            dryRun = true
+        case arg == "--repack":
+            doRepack = true
+        case strings.HasPrefix(arg, "--prune="):
+            pruneExpire = strings.TrimPrefix(arg, "--prune=")
+        default:
+            return fmt.Errorf("Unknown gc option \"%s\"", arg)
+        }
+    }
+    grace, err := parseExpiry(pruneExpire)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    reachable, err := reachableObjects()
+    if err != nil {
+        return fmt.Errorf("Unable to mark reachable objects, nothing was deleted: %w", err)
+    }
+    loose, err := looseObjects()
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    count, freed := 0, int64(0)
+    for _, entry := range loose {
+        if reachable[entry.Name()] {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+        if grace == 0 || now.Sub(info.ModTime()) < grace {
+            continue
+        }
+        count++
+        freed += info.Size()
+        if dryRun {
+            kind, _, _ := readObject(entry.Name())
+            fmt.Printf("would remove %s %s (%d bytes)\n", entry.Name(), kind, info.Size())
+            continue
+        }
+        err = os.Remove(BaseDir + OBJECTS_DIR + entry.Name())
+        if err != nil {
+            return &PathError{"remove object", BaseDir + OBJECTS_DIR + entry.Name(), err}
+        }
+    }
+    if dryRun {
+        fmt.Printf("%d unreachable objects, %d bytes would be freed\n", count, freed)
+        return nil
+    }
+    fmt.Printf("Removed %d unreachable objects, freed %d bytes\n", count, freed)
+
+    if doRepack {
+        loosened, err := loosenPacked(reachable, grace, now)
+        if err != nil {
+            return err
+        }
+        if loosened > 0 {
+            fmt.Printf("Kept %d unreachable packed objects as loose ones until they expire\n", loosened)
+        }
+        hashes := []string{}
+        for hash := range reachable {
+            hashes = append(hashes, hash)
+        }
+        sort.Strings(hashes)
+        name, err := repack(hashes)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Packed %d objects into %s\n", len(hashes), name)
+    }
+    return nil
+}
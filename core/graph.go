@@ -0,0 +1,301 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "sort"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// GRAPH //
+///////////
+
+// Graph symbols, a lane is a column carrying the line of history down to
+// the commit it waits for
+const (
+    GRAPH_COMMIT     = "●"
+    GRAPH_LANE       = "│"
+    GRAPH_FILL       = "─"
+    GRAPH_CROSS      = "┼"
+    GRAPH_JOIN_LEFT  = "╯"  // a lane from the right ends in the commit
+    GRAPH_JOIN_RIGHT = "╰"  // a lane from the left ends in the commit
+    GRAPH_FORK_RIGHT = "╮"  // a merge parent gets a new lane to the right
+    GRAPH_FORK_LEFT  = "╭"  // or to the left
+    GRAPH_TEE_RIGHT  = "├"
+    GRAPH_TEE_LEFT   = "┤"
+)
+
+// logRevisions turns log arguments into commits to include and exclude,
+// "--all" takes every ref and nothing at all means HEAD
+func logRevisions(revs []string) ([]string, []string, error) {
+    include, exclude := []string{}, []string{}
+    given := false
+    for _, rev := range revs {
+        if rev == "--all" {
+            refs, err := allRefs()
+            if err != nil {
+                return nil, nil, err
+            }
+            for _, ref := range refs {
+                hash, err := resolveRef(ref)
+                if errors.Is(err, fs.ErrNotExist) {
+                    continue
+                }
+                if err == nil {
+                    hash, err = peelToCommit(hash)
+                }
+                if err != nil {
+                    return nil, nil, err
+                }
+                include = append(include, hash)
+            }
+            given = true
+            continue
+        }
+        if strings.HasPrefix(rev, "--") {
+            return nil, nil, fmt.Errorf("Unknown log option \"%s\"", rev)
+        }
+        inc, exc, err := resolveRange(rev)
+        if err != nil {
+            return nil, nil, err
+        }
+        include = append(include, inc...)
+        exclude = append(exclude, exc...)
+        given = given || len(inc) > 0
+    }
+    if !given {
+        inc, _, err := resolveRange("HEAD")
+        if err != nil {
+            return nil, nil, err
+        }
+        include = append(include, inc...)
+    }
+    return include, exclude, nil
+}
+
+// refDecorations maps commits to the names pointing at them: "HEAD -> main"
+// for the checked out branch, then branches, remote branches and "tag: <name>"
+func refDecorations() (map[string][]string, error) {
+    decorations := map[string][]string{}
+    target, err := headTarget()
+    if err != nil {
+        return nil, err
+    }
+    head, err := getHead()
+    if err != nil {
+        return nil, err
+    }
+    if head != "" && target == "" {
+        decorations[head] = append(decorations[head], "HEAD")
+    }
+    for _, prefix := range []string{ BRANCHES_PREFIX, REMOTES_PREFIX, TAGS_PREFIX } {
+        refs, err := listRefs(prefix)
+        if err != nil {
+            return nil, err
+        }
+        for _, ref := range refs {
+            hash, err := resolveRef(ref)
+            if err == nil {
+                hash, err = peelToCommit(hash)
+            }
+            if err != nil {
+                continue
+            }
+            name := shortRef(ref)
+            switch {
+            case ref == target:
+                name = "HEAD -> " + name
+            case prefix == TAGS_PREFIX:
+                name = "tag: " + name
+            }
+            decorations[hash] = append(decorations[hash], name)
+        }
+    }
+    for hash, names := range decorations {
+        // the checked out branch leads
+        sort.SliceStable(names, func(i, j int) bool {
+            return strings.HasPrefix(names[i], "HEAD") && !strings.HasPrefix(names[j], "HEAD")
+        })
+        decorations[hash] = names
+    }
+    return decorations, nil
+}
+
+// graphLanes is the state carried from one row to the next: the commit
+// each lane waits for ("" when free) and the color it was given
+type graphLanes struct {
+    waiting []string
+    colors  []int
+    next    int
+}
+
+func (g *graphLanes) find(hash string) (int) {
+    for i, waiting := range g.waiting {
+        if waiting == hash {
+            return i
+        }
+    }
+    return -1
+}
+
+// claim gives hash the first free lane, a new one if none is free
+func (g *graphLanes) claim(hash string) (int) {
+    i := g.find("")
+    if i < 0 {
+        g.waiting = append(g.waiting, "")
+        g.colors = append(g.colors, 0)
+        i = len(g.waiting) - 1
+    }
+    g.waiting[i] = hash
+    g.colors[i] = g.next
+    g.next++
+    return i
+}
+
+func graphCell(symbol string, lane int) (models.GraphCell) {
+    return models.GraphCell{Symbol: symbol, Lane: lane}
+}
+
+// row draws the lanes with special symbols at some of them and a fill
+// running between from and to
+func (g *graphLanes) row(special map[int]models.GraphCell, from int, to int, fillLane int) ([]models.GraphCell) {
+    width := len(g.waiting)
+    for width > 0 && g.waiting[width-1] == "" && special[width-1].Symbol == "" {
+        width--
+    }
+    cells := []models.GraphCell{}
+    for i := 0; i < width; i++ {
+        if i > 0 {
+            if i > from && i <= to {
+                cells = append(cells, graphCell(GRAPH_FILL, fillLane))
+            } else {
+                cells = append(cells, graphCell(" ", -1))
+            }
+        }
+        inSpan := i > from && i < to
+        switch {
+        case special[i].Symbol != "":
+            cells = append(cells, special[i])
+        case g.waiting[i] != "" && inSpan:
+            cells = append(cells, graphCell(GRAPH_CROSS, g.colors[i]))
+        case g.waiting[i] != "":
+            cells = append(cells, graphCell(GRAPH_LANE, g.colors[i]))
+        case inSpan:
+            cells = append(cells, graphCell(GRAPH_FILL, fillLane))
+        default:
+            cells = append(cells, graphCell(" ", -1))
+        }
+    }
+    return cells
+}
+
+// LogGraph lays out the history log would show as rows of lanes, one row
+// per commit plus a row wherever a merge forks off new lanes
+func LogGraph(revs []string) ([]models.GraphRow, error) {
+    err := requireRepository()
+    if err != nil {
+        return nil, err
+    }
+    include, exclude, err := logRevisions(revs)
+    if err != nil {
+        return nil, err
+    }
+    commits, err := revList(include, exclude)
+    if err != nil {
+        return nil, err
+    }
+    decorations, err := refDecorations()
+    if err != nil {
+        return nil, err
+    }
+    listed := map[string]bool{}
+    for _, c := range commits {
+        listed[c.Hash] = true
+    }
+
+    g := &graphLanes{}
+    rows := []models.GraphRow{}
+    for _, c := range commits {
+        col := g.find(c.Hash)
+        if col < 0 {
+            col = g.claim(c.Hash)
+        }
+        // every other lane waiting for this commit ends in it
+        special := map[int]models.GraphCell{ col: graphCell(GRAPH_COMMIT, g.colors[col]) }
+        from, to, fillLane := col, col, g.colors[col]
+        for i, waiting := range g.waiting {
+            if i == col || waiting != c.Hash {
+                continue
+            }
+            if i > col {
+                special[i] = graphCell(GRAPH_JOIN_LEFT, g.colors[i])
+                to = max(to, i)
+            } else {
+                special[i] = graphCell(GRAPH_JOIN_RIGHT, g.colors[i])
+                from = min(from, i)
+            }
+            fillLane = g.colors[i]
+            g.waiting[i] = ""
+        }
+        rows = append(rows, models.GraphRow{Cells: g.row(special, from, to, fillLane), Commit: c, Refs: decorations[c.Hash]})
+
+        // parents outside the listed history are not drawn
+        parents := []string{}
+        for _, parent := range c.Parents {
+            if listed[parent] {
+                parents = append(parents, parent)
+            }
+        }
+        if len(parents) == 0 {
+            g.waiting[col] = ""
+            continue
+        }
+        g.waiting[col] = parents[0]
+        if len(parents) == 1 {
+            continue
+        }
+
+        // a merge reaches over to the lanes of its other parents
+        special = map[int]models.GraphCell{}
+        from, to = col, col
+        left, right := false, false
+        for _, parent := range parents[1:] {
+            lane := g.find(parent)
+            fresh := lane < 0 || lane == col
+            if fresh {
+                lane = g.claim(parent)
+            }
+            if lane > col {
+                right = true
+                to = max(to, lane)
+                if fresh {
+                    special[lane] = graphCell(GRAPH_FORK_RIGHT, g.colors[lane])
+                } else {
+                    special[lane] = graphCell(GRAPH_TEE_LEFT, g.colors[lane])
+                }
+            } else {
+                left = true
+                from = min(from, lane)
+                if fresh {
+                    special[lane] = graphCell(GRAPH_FORK_LEFT, g.colors[lane])
+                } else {
+                    special[lane] = graphCell(GRAPH_TEE_RIGHT, g.colors[lane])
+                }
+            }
+        }
+        symbol := GRAPH_TEE_RIGHT
+        switch {
+        case left && right:
+            symbol = GRAPH_CROSS
+        case left:
+            symbol = GRAPH_TEE_LEFT
+        }
+        special[col] = graphCell(symbol, g.colors[col])
+        rows = append(rows, models.GraphRow{Cells: g.row(special, from, to, g.colors[col])})
+    }
+    return rows, nil
+}
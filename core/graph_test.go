@@ -0,0 +1,67 @@
+package core
+
+import (
+    "reflect"
+    "testing"
+)
+
+// graphText draws each row's symbols, followed by the commit's message
+func graphText(t *testing.T, revs ...string) ([]string) {
+    t.Helper()
+    rows, err := LogGraph(revs)
+    if err != nil {
+        t.Fatal(err)
+    }
+    lines := []string{}
+    for _, row := range rows {
+        line := ""
+        for _, cell := range row.Cells {
+            line += cell.Symbol
+        }
+        if row.Commit.Hash != "" {
+            line += " " + row.Commit.Message
+        }
+        lines = append(lines, line)
+    }
+    return lines
+}
+
+func TestLogGraph(t *testing.T) {
+    testRepo(t)
+    root := testCommit(t, "root")
+    a := testCommit(t, "a", root)
+    b := testCommit(t, "b", root)
+    merge := testCommit(t, "merge", a, b)
+    side := testCommit(t, "side", b)
+
+    tests := []struct {
+        name string
+        revs []string
+        want []string
+    }{
+        {"straight line", []string{ a }, []string{
+            "● a",
+            "● root",
+        }},
+        {"merge", []string{ merge }, []string{
+            "● merge",
+            "├─╮",
+            "● │ a",
+            "│ ● b",
+            "●─╯ root",
+        }},
+        {"merge and a branch off its side", []string{ merge, side }, []string{
+            "● merge",
+            "├─╮",
+            "│ │ ● side",
+            "● │ │ a",
+            "│ ●─╯ b",
+            "●─╯ root",
+        }},
+    }
+    for _, tt := range tests {
+        if got := graphText(t, tt.revs...); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s:\n%q\nwant\n%q", tt.name, got, tt.want)
+        }
+    }
+}
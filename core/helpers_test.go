@@ -0,0 +1,109 @@
+package core
+
+import (
+    "errors"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+// testRepo makes an initialized repository in a temp dir the current one
+// for the rest of the test, like inRepository does for a whole command
+func testRepo(t *testing.T) (string) {
+    t.Helper()
+    t.Setenv("GOVERSE_AUTHOR", "Tester <tester@example.com>")
+    dir := t.TempDir() + "/"
+    base, packs, depth := BaseDir, packIndex, repoLockDepth
+    BaseDir, packIndex, repoLockDepth = dir, nil, 0
+    t.Cleanup(func() {
+        BaseDir, packIndex, repoLockDepth = base, packs, depth
+    })
+    err := InitGoverse()
+    if err != nil {
+        t.Fatalf("init: %v", err)
+    }
+    return dir
+}
+
+// writeTestFile writes content to rel in the current repository's work tree
+func writeTestFile(t *testing.T, rel string, content string) {
+    t.Helper()
+    full := filepath.Join(BaseDir, rel)
+    err := os.MkdirAll(filepath.Dir(full), 0755)
+    if err == nil {
+        err = os.WriteFile(full, []byte(content), 0644)
+    }
+    if err != nil {
+        t.Fatalf("write %s: %v", rel, err)
+    }
+}
+
+// readTestFile is the content of rel in the work tree, "" when missing
+func readTestFile(t *testing.T, rel string) (string) {
+    t.Helper()
+    content, err := os.ReadFile(BaseDir + rel)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        t.Fatal(err)
+    }
+    return string(content)
+}
+
+// commitAll stages the whole work tree and commits it, returning the hash
+func commitAll(t *testing.T, message string) (string) {
+    t.Helper()
+    err := Add(".")
+    if err != nil {
+        t.Fatalf("add: %v", err)
+    }
+    err = Commit(message)
+    if err != nil {
+        t.Fatalf("commit %q: %v", message, err)
+    }
+    head, err := getHead()
+    if err != nil {
+        t.Fatalf("head: %v", err)
+    }
+    return head
+}
+
+// storeRawTree stores a tree without any of the checks checkout and pack
+// reading make, the way a hostile repository could have written it
+func storeRawTree(t *testing.T, entries ...models.TreeEntry) (string) {
+    t.Helper()
+    tree := models.Tree{ Entries: entries }
+    err := storeTree(tree)
+    if err != nil {
+        t.Fatalf("store tree: %v", err)
+    }
+    hash, err := hashTree(tree)
+    if err != nil {
+        t.Fatalf("hash tree: %v", err)
+    }
+    return hash
+}
+
+// storeTestBlob stores content as a blob and returns its hash
+func storeTestBlob(t *testing.T, content string) (string) {
+    t.Helper()
+    b := models.Blob{ Content: []byte(content) }
+    err := storeBlob(b)
+    if err != nil {
+        t.Fatalf("store blob: %v", err)
+    }
+    hash, err := hashBlob(b)
+    if err != nil {
+        t.Fatalf("hash blob: %v", err)
+    }
+    return hash
+}
+
+// mustNotExist fails the test when something was written at path
+func mustNotExist(t *testing.T, path string) {
+    t.Helper()
+    if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+        t.Fatalf("%s was created", path)
+    }
+}
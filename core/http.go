@@ -0,0 +1,214 @@
+package core
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+//////////
+// HTTP //
+//////////
+
+// A repository served over http answers three requests, see protocol.go:
+//
+//     GET  /refs          the ref advertisement
+//     POST /upload-pack   wants and haves in, pack out
+//     POST /receive-pack  updates and pack in, results out
+
+const (
+    DEFAULT_SERVE_ADDR = "localhost:8418"
+    PACK_CONTENT_TYPE  = "application/x-goverse-pack"
+)
+
+// Serve shares the repository over http until the process is stopped,
+// "--addr <host:port>" picks where it listens, port 0 any free one.
+// Nothing is authenticated, whoever reaches the server can fetch every
+// object and push to any branch, so only loopback addresses are served
+// unless "--public" says the network in between is trusted
+func Serve(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    addr, public := DEFAULT_SERVE_ADDR, false
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--addr" && i+1 < len(args):
+            addr = args[i+1]
+            i++
+        case strings.HasPrefix(args[i], "--addr="):
+            addr = strings.TrimPrefix(args[i], "--addr=")
+        case args[i] == "--public":
+            public = true
+        default:
+            return fmt.Errorf("Unknown serve option \"%s\"", args[i])
+        }
+    }
+    if !public && !isLoopback(addr) {
+        return fmt.Errorf("%s is reachable from other machines and anyone there could push, serve it with --public to allow that", addr)
+    }
+
+    // BaseDir, the pack index and the lock depth are shared by the whole
+    // process, so requests take turns
+    var mu sync.Mutex
+    mux := http.NewServeMux()
+    handle := func(method string, path string, fn func(w http.ResponseWriter, r *http.Request) error) {
+        mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+            if r.Method != method {
+                http.Error(w, "use " + method, http.StatusMethodNotAllowed)
+                return
+            }
+            mu.Lock()
+            defer mu.Unlock()
+            err := fn(w, r)
+            fmt.Printf("%s %s\n", r.Method, r.URL.Path)
+            if err != nil {
+                fmt.Printf("  %v\n", err)
+                http.Error(w, err.Error(), http.StatusInternalServerError)
+            }
+        })
+    }
+    handle(http.MethodGet, "/refs", serveRefs)
+    handle(http.MethodPost, "/upload-pack", serveUploadPack)
+    handle(http.MethodPost, "/receive-pack", serveReceivePack)
+
+    listener, err := net.Listen("tcp", addr)
+    if err == nil {
+        fmt.Printf("Serving %s on http://%s\n", BaseDir, listener.Addr())
+        err = http.Serve(listener, mux)
+    }
+    if err != nil {
+        return fmt.Errorf("Unable to serve on %s: %w", addr, err)
+    }
+    return nil
+}
+
+// isLoopback says whether addr only listens on this machine, an empty
+// host listens everywhere
+func isLoopback(addr string) (bool) {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return false
+    }
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+func serveRefs(w http.ResponseWriter, r *http.Request) (error) {
+    refs, err := advertiseRefs()
+    if err != nil {
+        return err
+    }
+    w.Header().Set("Content-Type", "text/plain")
+    return writeRefs(w, refs)
+}
+
+func serveUploadPack(w http.ResponseWriter, r *http.Request) (error) {
+    wants, haves, depth, err := readWants(bufio.NewReader(r.Body))
+    if err != nil {
+        return err
+    }
+    // work out the objects first, once the pack starts the status is sent
+    hashes, err := packObjects(wants, haves, depth)
+    if err != nil {
+        return err
+    }
+    w.Header().Set("Content-Type", PACK_CONTENT_TYPE)
+    err = writePack(w, hashes)
+    if err != nil {
+        // too late for an error status, the client sees a short pack
+        fmt.Printf("  %v\n", err)
+    }
+    return nil
+}
+
+func serveReceivePack(w http.ResponseWriter, r *http.Request) (error) {
+    body := bufio.NewReader(r.Body)
+    updates, err := readUpdates(body)
+    if err != nil {
+        return err
+    }
+    results, err := receivePack(body, updates)
+    if err != nil {
+        return err
+    }
+    w.Header().Set("Content-Type", "text/plain")
+    return writeResults(w, updates, results)
+}
+
+////////////////////
+// HTTP TRANSPORT //
+////////////////////
+
+type httpTransport struct {
+    url string
+}
+
+// httpCheck turns a failed response into an error carrying the server's
+// message
+func httpCheck(resp *http.Response, err error) (*http.Response, error) {
+    if err != nil {
+        return nil, fmt.Errorf("Unable to reach remote: %w", err)
+    }
+    if resp.StatusCode != http.StatusOK {
+        message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+        resp.Body.Close()
+        return nil, fmt.Errorf("Remote answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+    }
+    return resp, nil
+}
+
+func (t *httpTransport) advertise() (map[string]string, error) {
+    resp, err := httpCheck(http.Get(t.url + "/refs"))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return readRefs(bufio.NewReader(resp.Body))
+}
+
+func (t *httpTransport) fetchPack(wants []string, haves []string, depth int) ([]string, error) {
+    var request strings.Builder
+    err := writeWants(&request, wants, haves, depth)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := httpCheck(http.Post(t.url + "/upload-pack", "text/plain", strings.NewReader(request.String())))
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return readPack(resp.Body)
+}
+
+func (t *httpTransport) sendPack(updates []refUpdate, hashes []string) ([]string, error) {
+    // the pack is written while the request is being sent
+    body, pipe := io.Pipe()
+    go func() {
+        err := writeUpdates(pipe, updates)
+        if err == nil {
+            err = writePack(pipe, hashes)
+        }
+        pipe.CloseWithError(err)
+    }()
+    resp, err := httpCheck(http.Post(t.url + "/receive-pack", PACK_CONTENT_TYPE, body))
+    body.Close()
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    return readResults(resp.Body, updates)
+}
+
+// isHTTP says whether url is served by a goverse http server
+func isHTTP(url string) (bool) {
+    return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
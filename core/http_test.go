@@ -0,0 +1,135 @@
+package core
+
+import (
+    "bufio"
+    "os"
+    "os/exec"
+    "strings"
+    "testing"
+)
+
+func TestIsLoopback(t *testing.T) {
+    tests := []struct {
+        addr string
+        want bool
+    }{
+        {"localhost:8418", true},
+        {"127.0.0.1:0", true},
+        {"[::1]:8418", true},
+        {":8418", false},
+        {"0.0.0.0:8418", false},
+        {"192.168.1.10:8418", false},
+        {"example.com:8418", false},
+        {"localhost", false},
+    }
+    for _, tt := range tests {
+        if got := isLoopback(tt.addr); got != tt.want {
+            t.Errorf("isLoopback(%q) = %v, want %v", tt.addr, got, tt.want)
+        }
+    }
+}
+
+func TestServeRefusesPublicAddress(t *testing.T) {
+    testRepo(t)
+    err := Serve([]string{ "--addr", ":0" })
+    if err == nil || !strings.Contains(err.Error(), "--public") {
+        t.Fatalf("serve on every interface = %v, want it refused", err)
+    }
+}
+
+// TestServeHelperProcess is the server TestHTTPTransport talks to, BaseDir
+// belongs to the whole process so the server cannot share the test's. It
+// does nothing when run on its own
+func TestServeHelperProcess(t *testing.T) {
+    dir := os.Getenv("GOVERSE_SERVE_HELPER")
+    if dir == "" {
+        t.Skip("only run by TestHTTPTransport")
+    }
+    BaseDir = dir
+    err := Serve([]string{ "--addr", "127.0.0.1:0" })
+    t.Fatal(err)
+}
+
+// serveRepo serves the repository at dir from a helper process and
+// returns its url
+func serveRepo(t *testing.T, dir string) (string) {
+    t.Helper()
+    cmd := exec.Command(os.Args[0], "-test.run=^TestServeHelperProcess$")
+    cmd.Env = append(os.Environ(), "GOVERSE_SERVE_HELPER=" + dir)
+    out, err := cmd.StdoutPipe()
+    if err == nil {
+        err = cmd.Start()
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() {
+        cmd.Process.Kill()
+        cmd.Wait()
+    })
+    lines := bufio.NewScanner(out)
+    for lines.Scan() {
+        if _, url, ok := strings.Cut(lines.Text(), " on "); ok && strings.HasPrefix(lines.Text(), "Serving ") {
+            // keep reading, a full pipe would stall the server's logging
+            go func() {
+                for lines.Scan() {
+                }
+            }()
+            return url
+        }
+    }
+    t.Fatal("the server stopped before it was listening")
+    return ""
+}
+
+func TestHTTPTransport(t *testing.T) {
+    if testing.Short() {
+        t.Skip("starts a server process")
+    }
+    upstream := testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    one := commitAll(t, "one")
+    err := setConfig("receive.denyCurrentBranch", "ignore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    url := serveRepo(t, upstream)
+
+    // clone, then push a commit back
+    dest := t.TempDir() + "/"
+    err = Clone([]string{ url, dest })
+    if err != nil {
+        t.Fatal(err)
+    }
+    var two string
+    err = inRepository(dest, func() (error) {
+        if head, err := getHead(); err != nil || head != one {
+            t.Errorf("clone is at %s, %v, want %s", head, err, one)
+        }
+        writeTestFile(t, "file", "two\n")
+        two = commitAll(t, "two")
+        return Push(nil)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := refIn(t, upstream, BRANCHES_PREFIX + DEFAULT_BRANCH); got != two {
+        t.Fatalf("served main is %s after the push, want %s", got, two)
+    }
+
+    // a second repository fetches what the first pushed
+    testRepo(t)
+    err = Remote([]string{ "add", DEFAULT_REMOTE, url })
+    if err == nil {
+        err = Fetch(nil)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := resolveRef(REMOTES_PREFIX + DEFAULT_REMOTE + "/" + DEFAULT_BRANCH); got != two {
+        t.Fatalf("fetched origin/main is %s, want %s", got, two)
+    }
+    if !objectExists(one) {
+        t.Fatal("the fetch left out history")
+    }
+}
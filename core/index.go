@@ -0,0 +1,296 @@
+package core
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path"
+    "sort"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// INDEX //
+///////////
+
+// mode recorded for directories in trees built from the index
+var DIR_MODE = fmt.Sprintf("%o", os.ModeDir | 0755)
+
+// the modes a file is stored with, whatever its permission bits, a symlink's
+// blob holds its target
+const (
+    FILE_MODE       = "644"
+    EXECUTABLE_MODE = "755"
+    SYMLINK_MODE    = "120000"
+)
+
+func readIndex() (models.Index, error) {
+    idx := models.Index {}
+    bytes, err := os.ReadFile(BaseDir + INDEX_FILE)
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return idx, nil
+        }
+        return idx, &PathError{"read index", BaseDir + INDEX_FILE, err}
+    }
+    if len(bytes) == 0 {
+        return idx, nil
+    }
+    err = json.Unmarshal(bytes, &idx)
+    if err != nil {
+        return idx, &PathError{"parse index", BaseDir + INDEX_FILE, fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+    }
+    return idx, nil
+}
+
+func writeIndex(idx models.Index) (error) {
+    sort.Slice(idx.Entries, func(i, j int) bool {
+        return idx.Entries[i].Path < idx.Entries[j].Path
+    })
+    serialized, err := json.Marshal(idx)
+    if err != nil {
+        return fmt.Errorf("Unable to serialize index: %w", err)
+    }
+    err = os.WriteFile(BaseDir + INDEX_FILE, serialized, 0644)
+    if err != nil {
+        return &PathError{"write index", BaseDir + INDEX_FILE, err}
+    }
+    return nil
+}
+
+// indexFind returns the position of path in idx, or -1
+func indexFind(idx models.Index, path string) (int) {
+    for i, entry := range idx.Entries {
+        if entry.Path == path {
+            return i
+        }
+    }
+    return -1
+}
+
+// indexRemove drops path and everything below it from idx
+func indexRemove(idx *models.Index, path string) {
+    kept := []models.IndexEntry{}
+    for _, entry := range idx.Entries {
+        if entry.Path != path && !strings.HasPrefix(entry.Path, path + "/") {
+            kept = append(kept, entry)
+        }
+    }
+    idx.Entries = kept
+}
+
+// relPath turns user input into a clean path relative to BaseDir
+func relPath(file string) (string, error) {
+    file = strings.TrimSpace(file)
+    file = strings.TrimPrefix(file, BaseDir)
+    if file == "" {
+        return "", errors.New("No path given")
+    }
+    rel := path.Clean(file)
+    if rel == "." {
+        return "", nil
+    }
+    if path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+        return "", fmt.Errorf("\"%s\" is outside the repository", file)
+    }
+    if rel == GOVERSE || strings.HasPrefix(rel, GOVERSE_DIR) {
+        return "", fmt.Errorf("\"%s\" is inside %s", file, GOVERSE_DIR)
+    }
+    return rel, nil
+}
+
+// checkEntryName rejects a tree entry name that is not exactly one path
+// component inside the work tree, trees from a remote or a bundle must
+// never name a file outside it or inside .goverse
+func checkEntryName(name string) (error) {
+    if name == "" || name == "." || name == ".." || strings.EqualFold(name, GOVERSE) || strings.ContainsAny(name, "/\x00") {
+        return fmt.Errorf("\"%s\" is not a valid entry name: %w", name, ErrUnsafePath)
+    }
+    return nil
+}
+
+// checkPath is checkEntryName for every component of a slash separated path
+func checkPath(p string) (error) {
+    for _, name := range strings.Split(p, "/") {
+        if checkEntryName(name) != nil {
+            return fmt.Errorf("\"%s\" is not a valid path: %w", p, ErrUnsafePath)
+        }
+    }
+    return nil
+}
+
+func checkTreeNames(t models.Tree) (error) {
+    for _, entry := range t.Entries {
+        err := checkEntryName(entry.Name)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// stagePath stores rel's content and records it in idx, a missing path
+// is staged as a deletion and a directory is staged recursively. A symlink
+// is stored as its target and never followed, sockets, devices and FIFOs
+// are skipped
+func stagePath(idx *models.Index, rel string) (error) {
+    full := BaseDir + rel
+    info, err := os.Lstat(full)
+    if err != nil || beyondSymlink(rel) {
+        if err == nil || errors.Is(err, fs.ErrNotExist) {
+            indexRemove(idx, rel)
+            return nil
+        }
+        return &PathError{"stat", full, err}
+    }
+    mode := workMode(info.Mode())
+    if mode == "" {
+        fmt.Fprintf(os.Stderr, "warning: skipping %s, only files, directories and symlinks can be tracked\n", rel)
+        return nil
+    }
+
+    if mode == DIR_MODE {
+        entries, err := os.ReadDir(full)
+        if err != nil {
+            return &PathError{"read dir", full, err}
+        }
+        // drop entries for files that are gone from this directory
+        gone := []string{}
+        for _, entry := range idx.Entries {
+            if rel == "" || strings.HasPrefix(entry.Path, rel + "/") {
+                if _, err := os.Lstat(BaseDir + entry.Path); errors.Is(err, fs.ErrNotExist) || beyondSymlink(entry.Path) {
+                    gone = append(gone, entry.Path)
+                }
+            }
+        }
+        for _, gonePath := range gone {
+            indexRemove(idx, gonePath)
+        }
+        for _, entry := range entries {
+            if entry.Name() == GOVERSE {continue}
+            err := stagePath(idx, path.Join(rel, entry.Name()))
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    content, err := readWorkContent(full, info)
+    if err != nil {
+        return err
+    }
+    b := models.Blob {
+        Content: content,
+    }
+    err = storeBlob(b)
+    if err != nil {
+        return err
+    }
+    hash, err := hashBlob(b)
+    if err != nil {
+        return err
+    }
+
+    // a file replaces a directory of the same name and vice versa
+    indexRemove(idx, rel)
+    for parent := path.Dir(rel); parent != "."; parent = path.Dir(parent) {
+        if i := indexFind(*idx, parent); i >= 0 {
+            idx.Entries = append(idx.Entries[:i], idx.Entries[i+1:]...)
+        }
+    }
+    idx.Entries = append(idx.Entries, models.IndexEntry {
+        Path: rel,
+        Mode: mode,
+        Hash: hash,
+    })
+    return nil
+}
+
+// writeTreeFromIndex stores the trees described by idx and returns the root hash
+func writeTreeFromIndex(idx models.Index) (string, error) {
+    return writeTreeFromEntries(idx.Entries, "")
+}
+
+func writeTreeFromEntries(entries []models.IndexEntry, prefix string) (string, error) {
+    t := models.Tree {
+        Entries: []models.TreeEntry{},
+    }
+    children := map[string][]models.IndexEntry{}
+    for _, entry := range entries {
+        rest := strings.TrimPrefix(entry.Path, prefix)
+        name, _, isDir := strings.Cut(rest, "/")
+        if !isDir {
+            t.Entries = append(t.Entries, models.TreeEntry {
+                Name: name,
+                Mode: entry.Mode,
+                Hash: entry.Hash,
+                IsBlob: true,
+            })
+            continue
+        }
+        children[name] = append(children[name], entry)
+    }
+    for name, childEntries := range children {
+        hash, err := writeTreeFromEntries(childEntries, prefix + name + "/")
+        if err != nil {
+            return "", err
+        }
+        t.Entries = append(t.Entries, models.TreeEntry {
+            Name: name,
+            Mode: DIR_MODE,
+            Hash: hash,
+            IsBlob: false,
+        })
+    }
+    // same order os.ReadDir gives, so hashes agree with hashDir
+    sort.Slice(t.Entries, func(i, j int) bool {
+        return t.Entries[i].Name < t.Entries[j].Name
+    })
+
+    err := storeTree(t)
+    if err != nil {
+        return "", err
+    }
+    return hashTree(t)
+}
+
+// flattenTree maps every blob path below the tree to its TreeEntry
+func flattenTree(hash string, prefix string, files map[string]models.TreeEntry) (error) {
+    t, err := deserializeTree(hash)
+    if err != nil {
+        return err
+    }
+    for _, entry := range t.Entries {
+        if entry.IsBlob {
+            files[prefix + entry.Name] = entry
+        } else {
+            err := flattenTree(entry.Hash, prefix + entry.Name + "/", files)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func indexFromTree(hash string) (models.Index, error) {
+    idx := models.Index {}
+    files := map[string]models.TreeEntry{}
+    err := flattenTree(hash, "", files)
+    if err != nil {
+        return idx, err
+    }
+    for filePath, entry := range files {
+        idx.Entries = append(idx.Entries, models.IndexEntry {
+            Path: filePath,
+            Mode: entry.Mode,
+            Hash: entry.Hash,
+        })
+    }
+    return idx, nil
+}
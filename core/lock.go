@@ -0,0 +1,225 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "strconv"
+    "strings"
+    "syscall"
+    "time"
+)
+
+///////////
+// LOCKS //
+///////////
+
+// A file is replaced by writing path.lock, created exclusively so only one
+// process can hold it, and renaming it over path once complete
+
+const LOCK_SUFFIX = ".lock"
+
+// how long a ref update waits for another process to release the ref
+const (
+    REF_LOCK_TIMEOUT = 2 * time.Second
+    LOCK_RETRY       = 10 * time.Millisecond
+)
+
+type lockFile struct {
+    path string
+    file *os.File
+}
+
+// acquireLock creates path.lock, retrying until timeout while another
+// process holds it
+func acquireLock(path string, timeout time.Duration) (*lockFile, error) {
+    deadline := time.Now().Add(timeout)
+    for {
+        file, err := os.OpenFile(path + LOCK_SUFFIX, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+        if err == nil {
+            return &lockFile{path, file}, nil
+        }
+        if !errors.Is(err, fs.ErrExist) {
+            return nil, &PathError{"create lock", path + LOCK_SUFFIX, err}
+        }
+        if time.Now().After(deadline) {
+            return nil, &PathError{"lock", path, ErrLocked}
+        }
+        time.Sleep(LOCK_RETRY)
+    }
+}
+
+// commit writes content to the lock file and renames it over the locked path
+func (l *lockFile) commit(content []byte) (error) {
+    _, err := l.file.Write(content)
+    if err == nil {
+        err = l.file.Sync()
+    }
+    closeErr := l.file.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(l.path + LOCK_SUFFIX)
+        return &PathError{"write", l.path + LOCK_SUFFIX, err}
+    }
+    err = os.Rename(l.path + LOCK_SUFFIX, l.path)
+    if err != nil {
+        os.Remove(l.path + LOCK_SUFFIX)
+        return &PathError{"rename", l.path + LOCK_SUFFIX, err}
+    }
+    return nil
+}
+
+// rollback releases the lock leaving the locked path untouched
+func (l *lockFile) rollback() {
+    l.file.Close()
+    os.Remove(l.path + LOCK_SUFFIX)
+}
+
+/////////////////////
+// REPOSITORY LOCK //
+/////////////////////
+
+// Mutating commands hold .goverse/lock, which names the pid and host that
+// took it. A lock whose pid is gone on this host is stale and gets replaced,
+// so is one left without an owner for longer than it takes to write one
+
+const REPO_LOCK_FILE = GOVERSE_DIR + "lock"
+
+// how long a command waits for the repository lock, see "lock.timeout"
+const DEFAULT_LOCK_TIMEOUT = 10 * time.Second
+
+// how long a lock may go without a readable owner before it counts as stale
+const LOCK_OWNER_GRACE = 5 * time.Second
+
+// nested commands in this process share the lock they already hold
+var repoLockDepth int
+
+// lockRepository takes the repository lock, call the returned func to release it
+func lockRepository() (func(), error) {
+    if repoLockDepth > 0 {
+        repoLockDepth++
+        return unlockRepository, nil
+    }
+    timeout, err := lockTimeout()
+    if err != nil {
+        return nil, err
+    }
+
+    path := BaseDir + REPO_LOCK_FILE
+    host, _ := os.Hostname()
+    owner := fmt.Sprintf("%d %s\n", os.Getpid(), host)
+    deadline := time.Now().Add(timeout)
+    for {
+        file, err := os.OpenFile(path, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+        if err == nil {
+            _, err = file.WriteString(owner)
+            file.Close()
+            if err != nil {
+                os.Remove(path)
+                return nil, &PathError{"write lock", path, err}
+            }
+            repoLockDepth = 1
+            return unlockRepository, nil
+        }
+        if !errors.Is(err, fs.ErrExist) {
+            if rerr := requireRepository(); rerr != nil {
+                return nil, rerr
+            }
+            return nil, &PathError{"create lock", path, err}
+        }
+
+        info, err := os.Stat(path)
+        var holder []byte
+        if err == nil {
+            holder, err = os.ReadFile(path)
+        }
+        if errors.Is(err, fs.ErrNotExist) {
+            // released while we looked
+            continue
+        }
+        if err == nil && lockIsStale(string(holder), host, time.Since(info.ModTime())) {
+            if clearStaleLock(path, host) == nil {
+                continue
+            }
+        }
+        if !time.Now().After(deadline) {
+            time.Sleep(LOCK_RETRY)
+            continue
+        }
+        return nil, &PathError{"lock repository", path, fmt.Errorf("held by pid %s: %w", strings.TrimSpace(string(holder)), ErrLocked)}
+    }
+}
+
+func unlockRepository() {
+    repoLockDepth--
+    if repoLockDepth == 0 {
+        os.Remove(BaseDir + REPO_LOCK_FILE)
+    }
+}
+
+// clearStaleLock removes the lock at path if it is still stale. Takers
+// look again and remove it holding path.takeover, and a lock is only ever
+// removed by its owner or under takeover, so the one judged is the one removed
+func clearStaleLock(path string, host string) (error) {
+    release, err := takeoverLock(path + ".takeover")
+    if err != nil {
+        return err
+    }
+    defer release()
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil
+    }
+    holder, err := os.ReadFile(path)
+    if err == nil && lockIsStale(string(holder), host, time.Since(info.ModTime())) {
+        os.Remove(path)
+    }
+    return nil
+}
+
+// lockIsStale reports whether the "<pid> <host>" in a lock file names a
+// process on this host that has exited. A lock without a readable owner is
+// stale once it is older than LOCK_OWNER_GRACE, until then it may still be
+// being written
+func lockIsStale(holder string, host string, age time.Duration) (bool) {
+    fields := strings.Fields(holder)
+    if len(fields) < 2 {
+        return age > LOCK_OWNER_GRACE
+    }
+    pid, err := strconv.Atoi(fields[0])
+    if err != nil || pid <= 0 {
+        return age > LOCK_OWNER_GRACE
+    }
+    if fields[1] != host {
+        return false
+    }
+    return !processAlive(pid)
+}
+
+func processAlive(pid int) (bool) {
+    process, err := os.FindProcess(pid)
+    if err != nil {
+        return false
+    }
+    err = process.Signal(syscall.Signal(0))
+    return err == nil || errors.Is(err, os.ErrPermission)
+}
+
+// lockTimeout reads "lock.timeout" as a duration ("30s") or whole seconds
+func lockTimeout() (time.Duration, error) {
+    value := getConfig("lock.timeout", "")
+    if value == "" {
+        return DEFAULT_LOCK_TIMEOUT, nil
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second, nil
+    }
+    timeout, err := time.ParseDuration(value)
+    if err != nil || timeout < 0 {
+        return 0, fmt.Errorf("lock.timeout \"%s\" is not a valid duration", value)
+    }
+    return timeout, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package core
+
+// takeoverLock has no flock to hold here, stale locks are cleared unguarded
+func takeoverLock(path string) (func(), error) {
+    return func() {}, nil
+}
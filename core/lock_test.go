@@ -0,0 +1,132 @@
+package core
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+)
+
+// deadPid is the pid of a process that has already exited
+func deadPid(t *testing.T) (int) {
+    t.Helper()
+    cmd := exec.Command(os.Args[0], "-test.run=^$")
+    err := cmd.Run()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cmd.Process.Pid
+}
+
+func TestLockIsStale(t *testing.T) {
+    host, _ := os.Hostname()
+    dead := deadPid(t)
+    old, young := 2 * LOCK_OWNER_GRACE, time.Second
+    tests := []struct {
+        name   string
+        holder string
+        age    time.Duration
+        stale  bool
+    }{
+        {"live owner", fmt.Sprintf("%d %s\n", os.Getpid(), host), old, false},
+        {"dead owner", fmt.Sprintf("%d %s\n", dead, host), young, true},
+        {"dead owner on another host", fmt.Sprintf("%d elsewhere\n", dead), old, false},
+        {"being written", "", young, false},
+        {"empty and old", "", old, true},
+        {"garbage and young", "not a lock", young, false},
+        {"garbage and old", "not a lock", old, true},
+        {"pid only and old", "12\n", old, true},
+    }
+    for _, tt := range tests {
+        if got := lockIsStale(tt.holder, host, tt.age); got != tt.stale {
+            t.Errorf("%s: lockIsStale(%q, %v) = %v, want %v", tt.name, tt.holder, tt.age, got, tt.stale)
+        }
+    }
+}
+
+func TestLockReplacesOldEmptyLock(t *testing.T) {
+    dir := testRepo(t)
+    path := dir + REPO_LOCK_FILE
+    err := os.WriteFile(path, nil, 0644)
+    if err == nil {
+        past := time.Now().Add(-2 * LOCK_OWNER_GRACE)
+        err = os.Chtimes(path, past, past)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        t.Fatalf("an old empty lock was not replaced: %v", err)
+    }
+    unlock()
+}
+
+// TestLockHelperProcess is one of the processes TestRepositoryLockRace
+// starts, it does nothing when run on its own
+func TestLockHelperProcess(t *testing.T) {
+    dir := os.Getenv("GOVERSE_LOCK_HELPER")
+    if dir == "" {
+        t.Skip("only run by TestRepositoryLockRace")
+    }
+    BaseDir = dir
+    host, _ := os.Hostname()
+    crashed := os.Getenv("GOVERSE_DEAD_PID") + " " + host + "\n"
+    inside := dir + "inside"
+    for i := 0; i < 20; i++ {
+        _, err := lockRepository()
+        if err != nil {
+            t.Fatal(err)
+        }
+        marker, err := os.OpenFile(inside, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0644)
+        if err != nil {
+            t.Fatalf("two processes hold the lock: %v", err)
+        }
+        marker.Close()
+        time.Sleep(time.Millisecond)
+        os.Remove(inside)
+        // die holding the lock every time, so the others keep racing to
+        // take over a stale one
+        err = os.WriteFile(dir + REPO_LOCK_FILE, []byte(crashed), 0644)
+        if err != nil {
+            t.Fatal(err)
+        }
+        repoLockDepth = 0
+    }
+}
+
+func TestRepositoryLockRace(t *testing.T) {
+    if testing.Short() {
+        t.Skip("starts several processes")
+    }
+    dir := testRepo(t)
+    dead := strconv.Itoa(deadPid(t))
+    host, _ := os.Hostname()
+    err := os.WriteFile(dir + REPO_LOCK_FILE, []byte(dead + " " + host + "\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+    errs := make(chan error, 16)
+    for i := 0; i < cap(errs); i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            cmd := exec.Command(os.Args[0], "-test.run=^TestLockHelperProcess$")
+            cmd.Env = append(os.Environ(), "GOVERSE_LOCK_HELPER=" + dir, "GOVERSE_DEAD_PID=" + dead)
+            out, err := cmd.CombinedOutput()
+            if err != nil {
+                errs <- fmt.Errorf("%v\n%s", err, out)
+            }
+        }()
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package core
+
+import (
+    "os"
+    "syscall"
+)
+
+// takeoverLock holds an flock on path until release is called, the kernel
+// drops it should the process die first
+func takeoverLock(path string) (func(), error) {
+    file, err := os.OpenFile(path, os.O_CREATE | os.O_RDWR, 0644)
+    if err != nil {
+        return nil, &PathError{"create lock", path, err}
+    }
+    err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+    if err != nil {
+        file.Close()
+        return nil, &PathError{"lock", path, err}
+    }
+    release := func() {
+        syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+        file.Close()
+    }
+    return release, nil
+}
@@ -0,0 +1,431 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/mail"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+//////////
+// MAIL //
+//////////
+
+// format-patch writes each commit as a mail in mbox form, am reads such
+// mails back into commits:
+//
+//     From <hash> Mon Sep 17 00:00:00 2001
+//     From: <author>
+//     Date: <date>
+//     Subject: [PATCH n/m] <first line of the message>
+//
+//     <rest of the message>
+//     ---
+//     <diffstat>
+//
+//     <diff>
+
+// the fixed date git puts on the mbox "From " line
+const MBOX_DATE = "Mon Sep 17 00:00:00 2001"
+
+// longest subject part of a patch file name
+const PATCH_NAME_LENGTH = 52
+
+// patchFileName is the "0001-subject-words.patch" name of the nth patch
+func patchFileName(n int, subject string) (string) {
+    var slug strings.Builder
+    dash := false
+    for _, r := range subject {
+        if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+            slug.WriteRune(r)
+            dash = false
+        } else if !dash && slug.Len() > 0 {
+            slug.WriteByte('-')
+            dash = true
+        }
+    }
+    name := strings.TrimRight(slug.String(), "-")
+    if len(name) > PATCH_NAME_LENGTH {
+        name = strings.TrimRight(name[:PATCH_NAME_LENGTH], "-")
+    }
+    return fmt.Sprintf("%04d-%s.patch", n, name)
+}
+
+// formatPatchCommits lists the commits a format-patch range names, oldest
+// first: "-<n>" for the last n, "A..B", or "<rev>" for rev..HEAD
+func formatPatchCommits(spec string) ([]string, error) {
+    include, exclude := []string{}, []string{}
+    var err error
+    switch {
+    case strings.HasPrefix(spec, "-") && len(spec) > 1:
+        n, err := strconv.Atoi(spec[1:])
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("Unknown format-patch option \"%s\"", spec)
+        }
+        head, err := resolveCommit("HEAD")
+        if err != nil {
+            return nil, err
+        }
+        commits, err := revList([]string{ head }, nil)
+        if err != nil {
+            return nil, err
+        }
+        hashes := []string{}
+        for i := min(n, len(commits)) - 1; i >= 0; i-- {
+            hashes = append(hashes, commits[i].Hash)
+        }
+        return hashes, nil
+    case strings.Contains(spec, ".."):
+        include, exclude, err = resolveRange(spec)
+    default:
+        include, exclude, err = resolveRange(spec + "..HEAD")
+    }
+    if err != nil {
+        return nil, err
+    }
+    commits, err := revList(include, exclude)
+    if err != nil {
+        return nil, err
+    }
+    hashes := []string{}
+    for i := len(commits) - 1; i >= 0; i-- {
+        hashes = append(hashes, commits[i].Hash)
+    }
+    return hashes, nil
+}
+
+// formatPatch renders commit hash as patch n of total
+func formatPatch(hash string, n int, total int) (string, string, error) {
+    c, err := deserializeCommit(hash)
+    if err != nil {
+        return "", "", err
+    }
+    before := map[string]models.IndexEntry{}
+    if len(c.Parents) > 0 {
+        before, err = commitFiles(c.Parents[0])
+        if err != nil {
+            return "", "", err
+        }
+    }
+    after, err := treeFiles(c.Tree)
+    if err != nil {
+        return "", "", err
+    }
+    date := c.Timestamp
+    if when, err := time.Parse(time.RFC3339, c.Timestamp); err == nil {
+        date = when.Format(time.RFC1123Z)
+    }
+    subject := firstLine(c.Message)
+    prefix := "[PATCH]"
+    if total > 1 {
+        prefix = fmt.Sprintf("[PATCH %d/%d]", n, total)
+    }
+    body := ""
+    if _, rest, found := strings.Cut(strings.TrimSpace(c.Message), "\n"); found {
+        body = strings.TrimSpace(rest) + "\n"
+    }
+
+    var out strings.Builder
+    fmt.Fprintf(&out, "From %s %s\n", c.Hash, MBOX_DATE)
+    fmt.Fprintf(&out, "From: %s\nDate: %s\nSubject: %s %s\n\n", c.Author, date, prefix, subject)
+    if body != "" {
+        out.WriteString(body + "\n")
+    }
+    out.WriteString("---\n")
+    err = writeFilesDiff(&out, before, after, false)
+    if err != nil {
+        return "", "", err
+    }
+    out.WriteString("\n")
+    err = writeFilesDiff(&out, before, after, true)
+    if err != nil {
+        return "", "", err
+    }
+    out.WriteString("-- \ngoverse\n\n")
+    return patchFileName(n, subject), out.String(), nil
+}
+
+// FormatPatch writes each commit of a range as a mail ready for "am":
+// "format-patch [-o <dir>] [--stdout] <-n|A..B|rev>". Files are named
+// after their subjects, merges are left out
+func FormatPatch(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    dir, stdout, spec := "", false, ""
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "-o" || args[i] == "--output-directory":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s needs a directory", args[i])
+            }
+            dir = args[i+1]
+            i++
+        case args[i] == "--stdout":
+            stdout = true
+        case spec == "":
+            spec = args[i]
+        default:
+            return errors.New("format-patch takes one range")
+        }
+    }
+    if spec == "" {
+        return errors.New("Name the commits to format, as -<n>, A..B or a revision to build on")
+    }
+    hashes, err := formatPatchCommits(spec)
+    if err != nil {
+        return err
+    }
+    commits := []string{}
+    for _, hash := range hashes {
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return err
+        }
+        if len(c.Parents) > 1 {
+            fmt.Fprintf(os.Stderr, "Leaving out merge %s %s\n", truncHash(hash), firstLine(c.Message))
+            continue
+        }
+        commits = append(commits, hash)
+    }
+    if !filepath.IsAbs(dir) {
+        dir = filepath.Join(BaseDir, dir)
+    }
+    if !stdout {
+        err = os.MkdirAll(dir, 0755)
+        if err != nil {
+            return &PathError{"create dir", dir, err}
+        }
+    }
+    for i, hash := range commits {
+        name, text, err := formatPatch(hash, i + 1, len(commits))
+        if err != nil {
+            return err
+        }
+        if stdout {
+            fmt.Print(text)
+            continue
+        }
+        file := filepath.Join(dir, name)
+        err = os.WriteFile(file, []byte(text), 0644)
+        if err != nil {
+            return &PathError{"write patch", file, err}
+        }
+        fmt.Println(file)
+    }
+    return nil
+}
+
+////////
+// AM //
+////////
+
+// patchMail is one mail read back by am
+type patchMail struct {
+    author    string
+    timestamp string
+    message   string
+    patches   []filePatch
+}
+
+// splitMbox cuts an mbox into its mails at each "From " line
+func splitMbox(text string) ([]string) {
+    mails := []string{}
+    var current strings.Builder
+    for _, line := range splitLines([]byte(text)) {
+        if strings.HasPrefix(line, "From ") && current.Len() > 0 {
+            mails = append(mails, current.String())
+            current.Reset()
+        }
+        current.WriteString(line)
+    }
+    if strings.TrimSpace(current.String()) != "" {
+        mails = append(mails, current.String())
+    }
+    return mails
+}
+
+// stripSubjectPrefix drops the "[PATCH n/m]" format-patch put in front
+func stripSubjectPrefix(subject string) (string) {
+    subject = strings.TrimSpace(subject)
+    for strings.HasPrefix(subject, "[") {
+        end := strings.Index(subject, "]")
+        if end < 0 || !strings.Contains(strings.ToUpper(subject[:end]), "PATCH") {
+            break
+        }
+        subject = strings.TrimSpace(subject[end+1:])
+    }
+    return subject
+}
+
+// parseMail reads the author, date, message and patch out of one mail
+func parseMail(text string, strip int) (patchMail, error) {
+    if strings.HasPrefix(text, "From ") {
+        _, text, _ = strings.Cut(text, "\n")
+    }
+    msg, err := mail.ReadMessage(strings.NewReader(text))
+    if err != nil {
+        return patchMail{}, fmt.Errorf("Unable to read mail: %w", err)
+    }
+    subject := stripSubjectPrefix(msg.Header.Get("Subject"))
+    author := msg.Header.Get("From")
+    if address, err := mail.ParseAddress(author); err == nil {
+        author = fmt.Sprintf("%s <%s>", address.Name, address.Address)
+        if address.Name == "" {
+            author = "<" + address.Address + ">"
+        }
+    }
+    if author == "" {
+        return patchMail{}, fmt.Errorf("Mail \"%s\" has no author", subject)
+    }
+    timestamp := ""
+    if date, err := msg.Header.Date(); err == nil {
+        timestamp = date.Format(time.RFC3339)
+    }
+
+    content, err := io.ReadAll(msg.Body)
+    if err != nil {
+        return patchMail{}, err
+    }
+    body := string(content)
+    // the message runs up to the "---" line or straight into the diff
+    message := body
+    if end := strings.Index(body, "\n---\n"); end >= 0 {
+        message = body[:end]
+    } else if strings.HasPrefix(body, "---\n") {
+        message = ""
+    } else if end := strings.Index(body, "\ndiff "); end >= 0 {
+        message = body[:end]
+    }
+    message = strings.TrimSpace(message)
+    if message != "" {
+        message = subject + "\n\n" + message
+    } else {
+        message = subject
+    }
+    patches, err := parsePatch(body, strip)
+    if err != nil {
+        return patchMail{}, err
+    }
+    return patchMail{author, timestamp, message, patches}, nil
+}
+
+// Am commits each mail of the given mbox files on top of HEAD with the
+// author, date and message it carries: "am [--fuzz <n>] <mbox>...". It
+// stops at the first patch that does not apply, leaving it and the rest
+// unapplied
+func Am(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    fuzz := 0
+    files := []string{}
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--fuzz":
+            if i+1 >= len(args) {
+                return errors.New("--fuzz needs a number")
+            }
+            fuzz, err = strconv.Atoi(args[i+1])
+            if err != nil || fuzz < 0 {
+                return fmt.Errorf("--fuzz needs a number, not \"%s\"", args[i+1])
+            }
+            i++
+        case strings.HasPrefix(args[i], "-") && args[i] != "-":
+            return fmt.Errorf("Unknown am option \"%s\"", args[i])
+        default:
+            files = append(files, args[i])
+        }
+    }
+    if len(files) == 0 {
+        return errors.New("Am needs a mbox or patch file, \"-\" reads stdin")
+    }
+    mails := []patchMail{}
+    for _, file := range files {
+        text, err := readPatchFile(file)
+        if err != nil {
+            return err
+        }
+        for _, raw := range splitMbox(text) {
+            m, err := parseMail(raw, 1)
+            if err != nil {
+                return fmt.Errorf("%s: %w", file, err)
+            }
+            mails = append(mails, m)
+        }
+    }
+    if len(mails) == 0 {
+        return errors.New("No patches found")
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    for i, m := range mails {
+        fmt.Println("Applying: " + firstLine(m.message))
+        err = amApply(m, fuzz)
+        if err != nil {
+            return fmt.Errorf("Patch %d of %d \"%s\" failed, %d applied: %w", i + 1, len(mails), firstLine(m.message), i, err)
+        }
+    }
+    return nil
+}
+
+// amApply applies one mail's patch to HEAD's files and commits it
+func amApply(m patchMail, fuzz int) (error) {
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    if head == "" {
+        return errors.New("No commits yet to apply patches on")
+    }
+    err = requireCleanIndex(head)
+    if err != nil {
+        return err
+    }
+    files, err := commitFiles(head)
+    if err != nil {
+        return err
+    }
+    results, err := applyPatches(m.patches, fuzz, func(p string) ([]byte, string, bool, error) {
+        entry, ok := files[p]
+        if !ok {
+            return nil, "", false, nil
+        }
+        content, err := readTypedObject(entry.Hash, BLOB)
+        return content, entry.Mode, err == nil, err
+    })
+    if err != nil {
+        return err
+    }
+    patched, err := stageResults(files, results)
+    if err != nil {
+        return err
+    }
+    err = checkoutFiles(files, patched, false)
+    if err != nil {
+        return err
+    }
+    idx := filesIndex(patched)
+    err = writeIndex(idx)
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+    return commitTree(REASON_AM, head, tree, []string{ head }, m.message, m.author, m.timestamp)
+}
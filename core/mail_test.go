@@ -0,0 +1,88 @@
+package core
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestFormatPatchAmRoundTrip(t *testing.T) {
+    dir := testRepo(t)
+    writeTestFile(t, "file", "one\ntwo\nthree\n")
+    writeTestFile(t, "gone", "bye\n")
+    base := commitAll(t, "base")
+
+    // each commit exercises one kind of change
+    steps := []struct {
+        message string
+        change  func()
+    }{
+        {"edit a line", func() { writeTestFile(t, "file", "one\nTWO\nthree\n") }},
+        {"add a file in a dir\n\nWith a body\nover two lines.", func() { writeTestFile(t, "dir/new", "new\n") }},
+        {"delete a file", func() {
+            if err := os.Remove(dir + "gone"); err != nil {
+                t.Fatal(err)
+            }
+        }},
+        {"no final newline", func() { writeTestFile(t, "file", "one\nTWO\nthree") }},
+    }
+    want := []string{}
+    for _, step := range steps {
+        step.change()
+        want = append(want, commitAll(t, step.message))
+    }
+
+    out := t.TempDir()
+    err := FormatPatch([]string{ "-o", out, base + "..HEAD" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    patches, err := filepath.Glob(filepath.Join(out, "*.patch"))
+    if err != nil || len(patches) != len(steps) {
+        t.Fatalf("format-patch wrote %v, %v, want %d patches", patches, err, len(steps))
+    }
+    err = Reset([]string{ "--hard", base })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = Am(patches)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := ancestorList(t, len(steps))
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, hash := range got {
+        orig, err := deserializeCommit(want[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if c.Tree != orig.Tree || c.Message != orig.Message || c.Author != orig.Author {
+            t.Errorf("commit %d came back as %+v, want %+v", i + 1, c, orig)
+        }
+    }
+}
+
+// ancestorList is HEAD's first-parent line, n commits long, oldest first
+func ancestorList(t *testing.T, n int) ([]string, error) {
+    t.Helper()
+    hash, err := getHead()
+    list := make([]string, n)
+    for i := n - 1; i >= 0 && err == nil; i-- {
+        list[i] = hash
+        var c models.Commit
+        c, err = deserializeCommit(hash)
+        if err == nil && len(c.Parents) > 0 {
+            hash = c.Parents[0]
+        }
+    }
+    return list, err
+}
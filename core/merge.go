@@ -0,0 +1,220 @@
+package core
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// MERGE //
+///////////
+
+// Conflict kinds, as printed in "CONFLICT (<kind>): ..."
+const (
+    CONFLICT_CONTENT       = "content"
+    CONFLICT_ADD_ADD       = "add/add"
+    CONFLICT_MODIFY_DELETE = "modify/delete"
+)
+
+const (
+    MARKER_OURS   = "<<<<<<<"
+    MARKER_BASE   = "======="
+    MARKER_THEIRS = ">>>>>>>"
+)
+
+type mergeConflict struct {
+    path string
+    kind string
+}
+
+// mergeLines is diff3: changes made on only one side of base are taken,
+// changes both sides made identically are taken once, and anything else is
+// written out between conflict markers labelled ours and theirs
+func mergeLines(base []string, ours []string, theirs []string, labelOurs string, labelTheirs string) ([]string, bool) {
+    matchOurs := diffMatches(base, ours)
+    matchTheirs := diffMatches(base, theirs)
+    result := []string{}
+    conflict := false
+    o, a, b := 0, 0, 0
+    for {
+        // a stable run: base lines kept, in place, on both sides
+        i := 0
+        for o+i < len(base) && matchOurs[o+i] == a+i && matchTheirs[o+i] == b+i {
+            i++
+        }
+        if i > 0 {
+            result = append(result, base[o:o+i]...)
+            o, a, b = o+i, a+i, b+i
+            continue
+        }
+
+        // an unstable run ends at the next base line both sides kept
+        j := 0
+        for o+j < len(base) && (matchOurs[o+j] < 0 || matchTheirs[o+j] < 0) {
+            j++
+        }
+        endA, endB := len(ours), len(theirs)
+        if o+j < len(base) {
+            endA, endB = matchOurs[o+j], matchTheirs[o+j]
+        }
+        chunkBase, chunkA, chunkB := base[o:o+j], ours[a:endA], theirs[b:endB]
+        switch {
+        case linesEqual(chunkA, chunkB) || linesEqual(chunkB, chunkBase):
+            result = append(result, chunkA...)
+        case linesEqual(chunkA, chunkBase):
+            result = append(result, chunkB...)
+        default:
+            conflict = true
+            result = append(result, MARKER_OURS + " " + labelOurs + "\n")
+            result = appendTerminated(result, chunkA)
+            result = append(result, MARKER_BASE + "\n")
+            result = appendTerminated(result, chunkB)
+            result = append(result, MARKER_THEIRS + " " + labelTheirs + "\n")
+        }
+        o, a, b = o+j, endA, endB
+        if o >= len(base) && a >= len(ours) && b >= len(theirs) {
+            return result, conflict
+        }
+    }
+}
+
+func linesEqual(a []string, b []string) (bool) {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// appendTerminated appends lines, giving the last one a "\n" so a marker
+// after it starts on its own line
+func appendTerminated(result []string, lines []string) ([]string) {
+    for _, line := range lines {
+        if !strings.HasSuffix(line, "\n") {
+            line += "\n"
+        }
+        result = append(result, line)
+    }
+    return result
+}
+
+// mergeFiles merges the changes from base to theirs into ours, path by
+// path, storing any new blobs. Conflicted paths get the marked up content,
+// or the surviving side of a modify/delete
+func mergeFiles(base map[string]models.IndexEntry, ours map[string]models.IndexEntry, theirs map[string]models.IndexEntry, labelOurs string, labelTheirs string) (map[string]models.IndexEntry, []mergeConflict, error) {
+    paths := map[string]bool{}
+    for _, files := range []map[string]models.IndexEntry{base, ours, theirs} {
+        for p := range files {
+            paths[p] = true
+        }
+    }
+    sorted := []string{}
+    for p := range paths {
+        sorted = append(sorted, p)
+    }
+    sort.Strings(sorted)
+
+    same := func(x models.IndexEntry, inX bool, y models.IndexEntry, inY bool) (bool) {
+        return inX == inY && x.Hash == y.Hash && x.Mode == y.Mode
+    }
+    merged := map[string]models.IndexEntry{}
+    conflicts := []mergeConflict{}
+    for _, p := range sorted {
+        o, inBase := base[p]
+        a, inOurs := ours[p]
+        b, inTheirs := theirs[p]
+        switch {
+        case same(a, inOurs, b, inTheirs) || same(b, inTheirs, o, inBase):
+            if inOurs {
+                merged[p] = a
+            }
+            continue
+        case same(a, inOurs, o, inBase):
+            if inTheirs {
+                merged[p] = b
+            }
+            continue
+        case !inOurs || !inTheirs:
+            // one side changed what the other deleted, keep the change
+            conflicts = append(conflicts, mergeConflict{p, CONFLICT_MODIFY_DELETE})
+            if inOurs {
+                merged[p] = a
+            } else {
+                merged[p] = b
+            }
+            continue
+        }
+
+        mode := a.Mode
+        if a.Mode == o.Mode {
+            mode = b.Mode
+        }
+        entry, clean, err := mergeBlobs(p, o.Hash, a.Hash, b.Hash, labelOurs, labelTheirs)
+        if err != nil {
+            return nil, nil, err
+        }
+        entry.Mode = mode
+        merged[p] = entry
+        if !clean {
+            kind := CONFLICT_CONTENT
+            if !inBase {
+                kind = CONFLICT_ADD_ADD
+            }
+            conflicts = append(conflicts, mergeConflict{p, kind})
+        }
+    }
+    return merged, conflicts, nil
+}
+
+// mergeBlobs merges two versions of a file against their base, "" for no
+// base. Binary files cannot be merged and keep our side
+func mergeBlobs(p string, base string, ours string, theirs string, labelOurs string, labelTheirs string) (models.IndexEntry, bool, error) {
+    entry := models.IndexEntry{Path: p, Hash: ours}
+    contents := [][]byte{}
+    for _, hash := range []string{base, ours, theirs} {
+        if hash == "" {
+            contents = append(contents, nil)
+            continue
+        }
+        content, err := readTypedObject(hash, BLOB)
+        if err != nil {
+            return entry, false, err
+        }
+        contents = append(contents, content)
+    }
+    for _, content := range contents {
+        if isBinary(content) {
+            return entry, false, nil
+        }
+    }
+    lines, conflict := mergeLines(splitLines(contents[0]), splitLines(contents[1]), splitLines(contents[2]), labelOurs, labelTheirs)
+    b := models.Blob{Content: []byte(strings.Join(lines, ""))}
+    err := storeBlob(b)
+    if err != nil {
+        return entry, false, err
+    }
+    entry.Hash, err = hashBlob(b)
+    return entry, !conflict, err
+}
+
+// conflictError prints a CONFLICT line per path and returns the error
+// every merging command fails with
+func conflictError(conflicts []mergeConflict) (error) {
+    paths := []string{}
+    for _, c := range conflicts {
+        if c.kind == CONFLICT_MODIFY_DELETE {
+            fmt.Printf("CONFLICT (%s): %s was deleted on one side and modified on the other\n", c.kind, c.path)
+        } else {
+            fmt.Printf("CONFLICT (%s): Merge conflict in %s\n", c.kind, c.path)
+        }
+        paths = append(paths, c.path)
+    }
+    return &ConflictError{paths}
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestMergeLines(t *testing.T) {
+    lines := func(s string) ([]string) {
+        split := strings.SplitAfter(s, "\n")
+        if split[len(split)-1] == "" {
+            split = split[:len(split)-1]
+        }
+        return split
+    }
+    tests := []struct {
+        name     string
+        base     string
+        ours     string
+        theirs   string
+        want     string
+        conflict bool
+    }{
+        {"unchanged", "a\nb\n", "a\nb\n", "a\nb\n", "a\nb\n", false},
+        {"only ours", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n", false},
+        {"only theirs", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n", false},
+        {"both apart", "a\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", false},
+        {"same change", "a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", "a\nX\nc\n", false},
+        {"both append", "a\n", "a\nours\n", "a\ntheirs\n", "a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> topic\n", true},
+        {"clash", "a\nb\nc\n", "a\nO\nc\n", "a\nT\nc\n", "a\n<<<<<<< HEAD\nO\n=======\nT\n>>>>>>> topic\nc\n", true},
+        {"delete against keep", "a\nb\nc\n", "a\nc\n", "a\nb\nc\n", "a\nc\n", false},
+        {"delete against edit", "a\nb\nc\n", "a\nc\n", "a\nB\nc\n", "a\n<<<<<<< HEAD\n=======\nB\n>>>>>>> topic\nc\n", true},
+        {"empty base", "", "x\n", "x\n", "x\n", false},
+        {"no final newline", "a\nb", "a\nO", "a\nT", "a\n<<<<<<< HEAD\nO\n=======\nT\n>>>>>>> topic\n", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, conflict := mergeLines(lines(tt.base), lines(tt.ours), lines(tt.theirs), "HEAD", "topic")
+            if strings.Join(got, "") != tt.want || conflict != tt.conflict {
+                t.Fatalf("mergeLines = %q, %v, want %q, %v", strings.Join(got, ""), conflict, tt.want, tt.conflict)
+            }
+        })
+    }
+}
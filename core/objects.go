@@ -0,0 +1,305 @@
+package core
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha1"
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+/////////////
+// OBJECTS //
+/////////////
+
+// Object kinds, written into every object's header
+const (
+    BLOB   = "blob"
+    TREE   = "tree"
+    COMMIT = "commit"
+    TAG    = "tag"
+)
+
+// writeObject stores payload under hash behind a "<kind> <size>\x00" header,
+// objects never change once written so an existing file is left alone,
+// unless it is of another kind and the hash is not this object's
+func writeObject(kind string, hash string, payload []byte) (error) {
+    if objectExists(hash) {
+        found, err := objectKind(hash)
+        if err != nil {
+            return err
+        }
+        if found != kind {
+            return &ObjectError{"store " + kind, truncHash(hash), fmt.Errorf("already stored as a %s: %w", found, ErrCorruptObject)}
+        }
+        return nil
+    }
+    return writeLooseObject(kind, hash, payload)
+}
+
+// writeLooseObject stores payload as a file of its own, even when a pack
+// already holds it
+func writeLooseObject(kind string, hash string, payload []byte) (error) {
+    path := BaseDir + OBJECTS_DIR + hash
+    // write aside and rename, so a crash never leaves half an object behind
+    header := fmt.Sprintf("%s %d\x00", kind, len(payload))
+    tmpPath := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+    err := os.WriteFile(tmpPath, append([]byte(header), payload...), 0644)
+    if err == nil {
+        err = os.Rename(tmpPath, path)
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return &PathError{"store " + kind, path, err}
+    }
+    return nil
+}
+
+// readObject returns the kind and payload of the object stored under hash
+func readObject(hash string) (string, []byte, error) {
+    if !isHex(hash) {
+        return "", nil, &ObjectError{"find", hash, ErrObjectNotFound}
+    }
+    path := BaseDir + OBJECTS_DIR + hash
+    raw, err := os.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            kind, payload, found, err := readPackedObject(hash)
+            if found || err != nil {
+                return kind, payload, err
+            }
+            return "", nil, &ObjectError{"find", truncHash(hash), ErrObjectNotFound}
+        }
+        return "", nil, &PathError{"read object", path, err}
+    }
+    return parseObject(hash, raw)
+}
+
+// objectKind reads the kind of the object stored under hash, only the
+// header of a loose one
+func objectKind(hash string) (string, error) {
+    f, err := os.Open(BaseDir + OBJECTS_DIR + hash)
+    if err != nil {
+        kind, _, err := readObject(hash)
+        return kind, err
+    }
+    defer f.Close()
+    header, err := bufio.NewReader(f).ReadString(0)
+    if err != nil {
+        return "", &ObjectError{"parse", truncHash(hash), fmt.Errorf("missing header: %w", ErrCorruptObject)}
+    }
+    kind, _, _ := strings.Cut(header, " ")
+    return kind, nil
+}
+
+func parseObject(hash string, raw []byte) (string, []byte, error) {
+    nul := bytes.IndexByte(raw, 0)
+    if nul < 0 {
+        return "", nil, &ObjectError{"parse", truncHash(hash), fmt.Errorf("missing header: %w", ErrCorruptObject)}
+    }
+    var kind string
+    var size int
+    _, err := fmt.Sscanf(string(raw[:nul]), "%s %d", &kind, &size)
+    if err != nil {
+        return "", nil, &ObjectError{"parse", truncHash(hash), fmt.Errorf("bad header: %w", ErrCorruptObject)}
+    }
+    payload := raw[nul+1:]
+    if len(payload) != size {
+        return "", nil, &ObjectError{"parse", truncHash(hash), fmt.Errorf("expected %d bytes, found %d: %w", size, len(payload), ErrCorruptObject)}
+    }
+    return kind, payload, nil
+}
+
+// objectExists reports whether hash is stored loose or in a pack
+func objectExists(hash string) (bool) {
+    if objectIsLoose(hash) {
+        return true
+    }
+    index, err := loadPackIndex()
+    if err != nil {
+        return false
+    }
+    _, ok := index[hash]
+    return ok
+}
+
+func objectIsLoose(hash string) (bool) {
+    _, err := os.Stat(BaseDir + OBJECTS_DIR + hash)
+    return err == nil
+}
+
+// hashObject computes the name an object of kind with payload is stored under
+func hashObject(kind string, payload []byte) (string, error) {
+    switch kind {
+    case BLOB:
+        return hashBlob(models.Blob{Content: payload})
+    case TREE:
+        var t models.Tree
+        if err := json.Unmarshal(payload, &t); err != nil {
+            return "", fmt.Errorf("%v: %w", err, ErrCorruptObject)
+        }
+        // packs from a remote or bundle come through here, refuse a tree
+        // that would write outside the work tree before it is stored
+        if err := checkTreeNames(t); err != nil {
+            return "", err
+        }
+        return hashTree(t)
+    case COMMIT:
+        var c models.Commit
+        if err := json.Unmarshal(payload, &c); err != nil {
+            return "", fmt.Errorf("%v: %w", err, ErrCorruptObject)
+        }
+        return hashCommit(c)
+    case TAG:
+        var t models.Tag
+        if err := json.Unmarshal(payload, &t); err != nil {
+            return "", fmt.Errorf("%v: %w", err, ErrCorruptObject)
+        }
+        return hashTagObject(t)
+    }
+    return "", fmt.Errorf("unknown object kind \"%s\": %w", kind, ErrCorruptObject)
+}
+
+// readTypedObject is readObject that also insists on the object's kind
+func readTypedObject(hash string, kind string) ([]byte, error) {
+    found, payload, err := readObject(hash)
+    if err != nil {
+        return nil, err
+    }
+    if found != kind {
+        return nil, &ObjectError{"read " + kind, truncHash(hash), fmt.Errorf("object is a %s", found)}
+    }
+    return payload, nil
+}
+
+func isHex(str string) (bool) {
+    if str == "" {
+        return false
+    }
+    for _, c := range str {
+        if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+            return false
+        }
+    }
+    return true
+}
+
+// isObjectHash says whether str is a whole object name, not a prefix
+func isObjectHash(str string) (bool) {
+    return len(str) == sha1.Size * 2 && isHex(str)
+}
+
+/////////////
+// COMMITS //
+/////////////
+
+func hashCommit(c models.Commit) (string, error) {
+    content := "tree " + c.Tree + "\n"
+    for _, parent := range c.Parents {
+        content += "parent " + parent + "\n"
+    }
+    content += "author " + c.Author + "\n"
+    content += "date " + c.Timestamp + "\n\n"
+    content += c.Message
+    return hashKind(COMMIT, content)
+}
+
+func storeCommit(c models.Commit) (string, error) {
+    hashString, err := hashCommit(c)
+    if err != nil {
+        return "", fmt.Errorf("Unable to hash Commit: %w", err)
+    }
+    c.Hash = ""
+    serialized, err := json.Marshal(c)
+    if err != nil {
+        return "", fmt.Errorf("Unable to serialize Commit with hash \"%s\": %w", hashString, err)
+    }
+    return hashString, writeObject(COMMIT, hashString, serialized)
+}
+
+func deserializeCommit(hash string) (models.Commit, error) {
+    payload, err := readTypedObject(hash, COMMIT)
+    if err != nil {
+        return models.Commit{}, err
+    }
+    var c models.Commit
+    err = json.Unmarshal(payload, &c)
+    if err != nil {
+        return models.Commit{}, &ObjectError{"deserialize Commit", truncHash(hash), fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+    }
+    c.Hash = hash
+    // history was cut off below a shallow commit
+    if shallowCommits()[hash] {
+        c.Parents = nil
+    }
+    return c, nil
+}
+
+// firstLine is the summary line of a commit or tag message
+func firstLine(message string) (string) {
+    return strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+}
+
+//////////
+// TAGS //
+//////////
+
+func hashTagObject(t models.Tag) (string, error) {
+    content := "object " + t.Commit + "\n"
+    content += "tag " + t.Name + "\n"
+    content += "version " + t.Version + "\n"
+    content += "tagger " + t.Tagger + "\n"
+    content += "date " + t.Timestamp + "\n"
+    return hashKind(TAG, content)
+}
+
+func storeTagObject(t models.Tag) (string, error) {
+    hashString, err := hashTagObject(t)
+    if err != nil {
+        return "", fmt.Errorf("Unable to hash Tag: %w", err)
+    }
+    serialized, err := json.Marshal(t)
+    if err != nil {
+        return "", fmt.Errorf("Unable to serialize Tag \"%s\": %w", t.Name, err)
+    }
+    return hashString, writeObject(TAG, hashString, serialized)
+}
+
+func deserializeTag(hash string) (models.Tag, error) {
+    payload, err := readTypedObject(hash, TAG)
+    if err != nil {
+        return models.Tag{}, err
+    }
+    var t models.Tag
+    err = json.Unmarshal(payload, &t)
+    if err != nil {
+        return models.Tag{}, &ObjectError{"deserialize Tag", truncHash(hash), fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+    }
+    return t, nil
+}
+
+// peelToCommit follows tag objects until it reaches a commit
+func peelToCommit(hash string) (string, error) {
+    for {
+        kind, _, err := readObject(hash)
+        if err != nil {
+            return "", err
+        }
+        switch kind {
+        case COMMIT:
+            return hash, nil
+        case TAG:
+            t, err := deserializeTag(hash)
+            if err != nil {
+                return "", err
+            }
+            hash = t.Commit
+        default:
+            return "", &ObjectError{"peel", truncHash(hash), fmt.Errorf("%s is not a commit", kind)}
+        }
+    }
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+    "errors"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestHashesCoverKind(t *testing.T) {
+    blob, err := hashBlob(models.Blob{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    tree, err := hashTree(models.Tree{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if blob == tree {
+        t.Fatalf("the empty blob and the empty tree are both %s", blob)
+    }
+    // the empty blob's name is sha1("blob 0\x00")
+    want, _ := getHash("blob 0\x00")
+    if blob != want {
+        t.Fatalf("empty blob = %s, want %s", blob, want)
+    }
+}
+
+func TestHashObjectMatchesStore(t *testing.T) {
+    testRepo(t)
+    blob := storeTestBlob(t, "content\n")
+    tree := storeRawTree(t, models.TreeEntry{ Name: "file", Mode: FILE_MODE, Hash: blob, IsBlob: true })
+    commit, err := storeCommit(models.Commit{ Tree: tree, Message: "m", Author: "A <a@example.com>", Timestamp: "2024-01-01T00:00:00Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    tag, err := storeTagObject(models.Tag{ Commit: commit, Name: "v1", Tagger: "A <a@example.com>", Timestamp: "2024-01-01T00:00:00Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        hash string
+        kind string
+    }{
+        {blob, BLOB},
+        {tree, TREE},
+        {commit, COMMIT},
+        {tag, TAG},
+    }
+    for _, tt := range tests {
+        kind, payload, err := readObject(tt.hash)
+        if err != nil {
+            t.Fatalf("read %s: %v", tt.kind, err)
+        }
+        if kind != tt.kind {
+            t.Errorf("%s is stored as a %s", tt.kind, kind)
+        }
+        hash, err := hashObject(kind, payload)
+        if err != nil || hash != tt.hash {
+            t.Errorf("hashObject(%s) = %s, %v, want %s", tt.kind, hash, err, tt.hash)
+        }
+    }
+}
+
+func TestWriteObjectRefusesKindClash(t *testing.T) {
+    testRepo(t)
+    blob := storeTestBlob(t, "")
+    err := writeObject(TREE, blob, []byte("{}"))
+    if !errors.Is(err, ErrCorruptObject) {
+        t.Fatalf("writeObject over a blob = %v, want ErrCorruptObject", err)
+    }
+    if kind, _, err := readObject(blob); err != nil || kind != BLOB {
+        t.Fatalf("blob became %s, %v", kind, err)
+    }
+    // the same object again is fine
+    err = writeObject(BLOB, blob, []byte{})
+    if err != nil {
+        t.Fatalf("rewriting the blob: %v", err)
+    }
+}
+
+func TestEmptyFileNextToEmptyTree(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "empty", "")
+    commitAll(t, "add an empty file")
+    err := Reset([]string{ "--hard", "HEAD" })
+    if err != nil {
+        t.Fatalf("reset --hard: %v", err)
+    }
+    err = Fsck(nil)
+    if err != nil {
+        t.Fatalf("fsck: %v", err)
+    }
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+    "bufio"
+    "bytes"
+    "compress/zlib"
+    "crypto/sha1"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "sort"
+    "strings"
+)
+
+///////////
+// PACKS //
+///////////
+
+// A pack holds many objects in one file:
+//
+//     GPACK 1 <count>\n
+//     <hash> <kind> <size> <compressed size>\n<zlib payload>   (count times)
+//
+// Packs live in .goverse/objects/pack/ named by the hash of their content,
+// and the same stream carries objects between repositories
+
+const (
+    PACK_DIR        = OBJECTS_DIR + "pack/"
+    PACK_MAGIC      = "GPACK"
+    PACK_VERSION    = 1
+    // packs are written under this name and renamed once complete
+    TMP_PACK_PREFIX = "tmp-pack"
+)
+
+type packEntry struct {
+    file   string
+    offset int64
+    kind   string
+    size   int
+    csize  int64
+}
+
+// where every packed object lives, loaded on first use
+var packIndex map[string]packEntry
+
+func loadPackIndex() (map[string]packEntry, error) {
+    if packIndex != nil {
+        return packIndex, nil
+    }
+    index := map[string]packEntry{}
+    entries, err := os.ReadDir(BaseDir + PACK_DIR)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return nil, &PathError{"read dir", BaseDir + PACK_DIR, err}
+    }
+    for _, entry := range entries {
+        if !strings.HasSuffix(entry.Name(), ".pack") {
+            continue
+        }
+        err := indexPack(BaseDir + PACK_DIR + entry.Name(), index)
+        if err != nil {
+            return nil, err
+        }
+    }
+    packIndex = index
+    return packIndex, nil
+}
+
+func indexPack(path string, index map[string]packEntry) (error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return &PathError{"open pack", path, err}
+    }
+    defer file.Close()
+    reader := bufio.NewReader(file)
+
+    header, err := reader.ReadString('\n')
+    if err != nil {
+        return &PathError{"read pack", path, fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+    }
+    count, err := parsePackHeader(header)
+    if err != nil {
+        return &PathError{"read pack", path, err}
+    }
+    offset := int64(len(header))
+    for i := 0; i < count; i++ {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            return &PathError{"read pack", path, fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+        }
+        entry := packEntry{file: path}
+        var hash string
+        _, err = fmt.Sscanf(line, "%s %s %d %d", &hash, &entry.kind, &entry.size, &entry.csize)
+        if err != nil {
+            return &PathError{"read pack", path, fmt.Errorf("bad entry %d: %w", i, ErrCorruptObject)}
+        }
+        offset += int64(len(line))
+        entry.offset = offset
+        index[hash] = entry
+        _, err = reader.Discard(int(entry.csize))
+        if err != nil {
+            return &PathError{"read pack", path, fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+        }
+        offset += entry.csize
+    }
+    return nil
+}
+
+func parsePackHeader(header string) (int, error) {
+    var magic string
+    var version, count int
+    _, err := fmt.Sscanf(header, "%s %d %d", &magic, &version, &count)
+    if err != nil || magic != PACK_MAGIC {
+        return 0, fmt.Errorf("not a pack: %w", ErrCorruptObject)
+    }
+    if version != PACK_VERSION {
+        return 0, fmt.Errorf("unsupported pack version %d", version)
+    }
+    return count, nil
+}
+
+// readPackedObject is readObject for objects that only live in a pack
+func readPackedObject(hash string) (string, []byte, bool, error) {
+    index, err := loadPackIndex()
+    if err != nil {
+        return "", nil, false, err
+    }
+    entry, ok := index[hash]
+    if !ok {
+        return "", nil, false, nil
+    }
+    file, err := os.Open(entry.file)
+    if err != nil {
+        return "", nil, true, &PathError{"open pack", entry.file, err}
+    }
+    defer file.Close()
+    compressed := io.NewSectionReader(file, entry.offset, entry.csize)
+    payload, err := inflate(compressed, entry.size)
+    if err != nil {
+        return "", nil, true, &ObjectError{"unpack", truncHash(hash), err}
+    }
+    return entry.kind, payload, true, nil
+}
+
+// inflate decompresses an entry of the declared size, reading at most one
+// byte past it so a small entry cannot expand without bound
+func inflate(r io.Reader, size int) ([]byte, error) {
+    if size < 0 {
+        return nil, fmt.Errorf("negative size %d: %w", size, ErrCorruptObject)
+    }
+    zr, err := zlib.NewReader(r)
+    if err != nil {
+        return nil, fmt.Errorf("%v: %w", err, ErrCorruptObject)
+    }
+    defer zr.Close()
+    payload, err := io.ReadAll(io.LimitReader(zr, int64(size) + 1))
+    if err != nil {
+        return nil, fmt.Errorf("%v: %w", err, ErrCorruptObject)
+    }
+    if len(payload) != size {
+        return nil, fmt.Errorf("expected %d bytes, found %d: %w", size, len(payload), ErrCorruptObject)
+    }
+    return payload, nil
+}
+
+// packedObjects lists every hash held in a pack
+func packedObjects() ([]string, error) {
+    index, err := loadPackIndex()
+    if err != nil {
+        return nil, err
+    }
+    hashes := []string{}
+    for hash := range index {
+        hashes = append(hashes, hash)
+    }
+    sort.Strings(hashes)
+    return hashes, nil
+}
+
+// writePack streams the given objects to w in pack format
+func writePack(w io.Writer, hashes []string) (error) {
+    _, err := fmt.Fprintf(w, "%s %d %d\n", PACK_MAGIC, PACK_VERSION, len(hashes))
+    if err != nil {
+        return err
+    }
+    for _, hash := range hashes {
+        kind, payload, err := readObject(hash)
+        if err != nil {
+            return err
+        }
+        var compressed bytes.Buffer
+        zw := zlib.NewWriter(&compressed)
+        zw.Write(payload)
+        zw.Close()
+        _, err = fmt.Fprintf(w, "%s %s %d %d\n", hash, kind, len(payload), compressed.Len())
+        if err != nil {
+            return err
+        }
+        _, err = w.Write(compressed.Bytes())
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// readPack stores every object in a pack stream as a loose object after
+// checking it hashes to its name, returning the hashes it read
+func readPack(r io.Reader) ([]string, error) {
+    return scanPack(r, true)
+}
+
+// verifyPack checks a pack stream like readPack without storing anything
+func verifyPack(r io.Reader) ([]string, error) {
+    return scanPack(r, false)
+}
+
+func scanPack(r io.Reader, store bool) ([]string, error) {
+    reader := bufio.NewReader(r)
+    header, err := reader.ReadString('\n')
+    if err != nil {
+        return nil, fmt.Errorf("Unable to read pack header: %v: %w", err, ErrCorruptObject)
+    }
+    count, err := parsePackHeader(header)
+    if err != nil {
+        return nil, err
+    }
+    hashes := []string{}
+    for i := 0; i < count; i++ {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            return nil, fmt.Errorf("Unable to read pack entry %d: %v: %w", i, err, ErrCorruptObject)
+        }
+        var hash, kind string
+        var size int
+        var csize int64
+        _, err = fmt.Sscanf(line, "%s %s %d %d", &hash, &kind, &size, &csize)
+        if err != nil || !isHex(hash) || csize < 0 {
+            return nil, fmt.Errorf("Unable to read pack entry %d: %w", i, ErrCorruptObject)
+        }
+        payload, err := inflate(io.LimitReader(reader, csize), size)
+        if err != nil {
+            return nil, &ObjectError{"unpack", truncHash(hash), err}
+        }
+        actual, err := hashObject(kind, payload)
+        if err != nil {
+            return nil, &ObjectError{"unpack", truncHash(hash), err}
+        }
+        if actual != hash {
+            return nil, &ObjectError{"unpack", truncHash(hash), fmt.Errorf("content hashes to %s: %w", truncHash(actual), ErrCorruptObject)}
+        }
+        if store {
+            err = writeObject(kind, hash, payload)
+            if err != nil {
+                return nil, err
+            }
+        }
+        hashes = append(hashes, hash)
+    }
+    return hashes, nil
+}
+
+// repack writes hashes into one new pack, then drops the old packs and any
+// loose copies of what was packed. Whatever else the old packs hold is
+// gone, gc loosens what it still has to keep first
+func repack(hashes []string) (string, error) {
+    err := os.MkdirAll(BaseDir + PACK_DIR, 0755)
+    if err != nil {
+        return "", &PathError{"create dir", BaseDir + PACK_DIR, err}
+    }
+    oldPacks, err := os.ReadDir(BaseDir + PACK_DIR)
+    if err != nil {
+        return "", &PathError{"read dir", BaseDir + PACK_DIR, err}
+    }
+
+    // a repack that died left its temp file behind, callers hold the
+    // repository lock so none is still being written
+    for _, old := range oldPacks {
+        if strings.HasPrefix(old.Name(), TMP_PACK_PREFIX) {
+            os.Remove(BaseDir + PACK_DIR + old.Name())
+        }
+    }
+    tmp, err := os.CreateTemp(BaseDir + PACK_DIR, TMP_PACK_PREFIX + "*")
+    if err != nil {
+        return "", &PathError{"create pack", BaseDir + PACK_DIR, err}
+    }
+    tmpPath := tmp.Name()
+    hasher := sha1.New()
+    err = writePack(io.MultiWriter(tmp, hasher), hashes)
+    if err == nil {
+        err = tmp.Sync()
+    }
+    tmp.Close()
+    if err != nil {
+        os.Remove(tmpPath)
+        return "", fmt.Errorf("Unable to write pack: %w", err)
+    }
+    name := "pack-" + hex.EncodeToString(hasher.Sum(nil)) + ".pack"
+    err = os.Rename(tmpPath, BaseDir + PACK_DIR + name)
+    if err != nil {
+        os.Remove(tmpPath)
+        return "", &PathError{"rename", tmpPath, err}
+    }
+
+    for _, old := range oldPacks {
+        if old.Name() != name && strings.HasSuffix(old.Name(), ".pack") {
+            os.Remove(BaseDir + PACK_DIR + old.Name())
+        }
+    }
+    for _, hash := range hashes {
+        os.Remove(BaseDir + OBJECTS_DIR + hash)
+    }
+    packIndex = nil
+    return name, nil
+}
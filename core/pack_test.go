@@ -0,0 +1,155 @@
+package core
+
+import (
+    "bytes"
+    "compress/zlib"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func compress(t *testing.T, payload []byte) ([]byte) {
+    t.Helper()
+    var out bytes.Buffer
+    zw := zlib.NewWriter(&out)
+    _, err := zw.Write(payload)
+    if err == nil {
+        err = zw.Close()
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return out.Bytes()
+}
+
+// countingReader counts what inflate pulls from a compressed entry
+type countingReader struct {
+    r    io.Reader
+    read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.read += n
+    return n, err
+}
+
+func TestInflate(t *testing.T) {
+    bomb := compress(t, make([]byte, 64<<20))
+    tests := []struct {
+        name       string
+        compressed []byte
+        size       int
+        ok         bool
+    }{
+        {"declared size", compress(t, []byte("hello")), 5, true},
+        {"shorter than declared", compress(t, []byte("hello")), 6, false},
+        {"longer than declared", compress(t, []byte("hello")), 4, false},
+        {"negative size", compress(t, []byte("hello")), -1, false},
+        {"not zlib", []byte("hello"), 5, false},
+        {"bomb", bomb, 5, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            in := &countingReader{ r: bytes.NewReader(tt.compressed) }
+            _, err := inflate(in, tt.size)
+            if (err == nil) != tt.ok {
+                t.Fatalf("inflate = %v, want ok %v", err, tt.ok)
+            }
+            if err != nil && !errors.Is(err, ErrCorruptObject) {
+                t.Fatalf("inflate = %v, want ErrCorruptObject", err)
+            }
+            // stopping past the declared size leaves most of a bomb unread
+            if in.read > 64<<10 {
+                t.Fatalf("inflate read %d compressed bytes for a %d byte entry", in.read, tt.size)
+            }
+        })
+    }
+}
+
+func TestReadPackRefusesBomb(t *testing.T) {
+    testRepo(t)
+    hash, _ := hashBlob(models.Blob{ Content: []byte("x") })
+    bomb := compress(t, make([]byte, 64<<20))
+    var stream bytes.Buffer
+    fmt.Fprintf(&stream, "%s %d 1\n%s %s %d %d\n", PACK_MAGIC, PACK_VERSION, hash, BLOB, 1, len(bomb))
+    stream.Write(bomb)
+    _, err := readPack(&stream)
+    if !errors.Is(err, ErrCorruptObject) {
+        t.Fatalf("readPack = %v, want ErrCorruptObject", err)
+    }
+    if objectExists(hash) {
+        t.Fatal("the entry was stored")
+    }
+}
+
+func TestRepackAfterCrashedRepack(t *testing.T) {
+    dir := testRepo(t)
+    writeTestFile(t, "file", "content\n")
+    commitAll(t, "file")
+    err := os.MkdirAll(dir + PACK_DIR, 0755)
+    if err == nil {
+        err = os.WriteFile(dir + PACK_DIR + TMP_PACK_PREFIX, []byte("half a pack"), 0644)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = Gc([]string{ "--repack" })
+    if err != nil {
+        t.Fatalf("gc --repack after a crashed one: %v", err)
+    }
+    mustNotExist(t, dir + PACK_DIR + TMP_PACK_PREFIX)
+    entries, _ := os.ReadDir(dir + PACK_DIR)
+    if len(entries) != 1 {
+        t.Fatalf("pack dir holds %d files, want the one pack", len(entries))
+    }
+}
+
+func TestRepackHonorsPruneExpire(t *testing.T) {
+    tests := []struct {
+        prune string
+        kept  bool
+    }{
+        {"14d", true},
+        {"never", true},
+        {"now", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.prune, func(t *testing.T) {
+            testRepo(t)
+            writeTestFile(t, "file", "content\n")
+            commitAll(t, "file")
+            reachable, err := reachableObjects()
+            if err != nil {
+                t.Fatal(err)
+            }
+            // a blob that was reachable when it got packed, and is no more
+            orphan := storeTestBlob(t, "orphan\n")
+            hashes := []string{ orphan }
+            for hash := range reachable {
+                hashes = append(hashes, hash)
+            }
+            sort.Strings(hashes)
+            _, err = repack(hashes)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if objectIsLoose(orphan) {
+                t.Fatal("repack left the loose copy")
+            }
+
+            err = Gc([]string{ "--repack", "--prune=" + tt.prune })
+            if err != nil {
+                t.Fatal(err)
+            }
+            if objectExists(orphan) != tt.kept {
+                t.Fatalf("gc --prune=%s: orphan exists %v, want %v", tt.prune, objectExists(orphan), tt.kept)
+            }
+        })
+    }
+}
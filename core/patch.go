@@ -0,0 +1,590 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "io/fs"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// PATCH //
+///////////
+
+// Patches are unified diffs as writeFilesDiff and git write them: a
+// "diff" line per file, mode lines for new, deleted and rechmodded files,
+// then "---", "+++" and "@@" hunks. A bare "---"/"+++" diff works too.
+// Hunks apply where their context matches, however far the lines have
+// moved, and with fuzz up to that many context lines at either end of a
+// hunk may differ
+
+// hunk is one "@@" block, old and new are its lines on either side
+type hunk struct {
+    oldStart int
+    old      []string
+    new      []string
+    // how many of old's lines are context before and after the change
+    leading  int
+    trailing int
+}
+
+// filePatch is the change to one file
+type filePatch struct {
+    oldPath string
+    newPath string
+    oldMode string
+    newMode string
+    created bool
+    deleted bool
+    binary  bool
+    hunks   []hunk
+}
+
+// patchResult is what a patch leaves at a path, deleted when nothing
+type patchResult struct {
+    content []byte
+    mode    string
+    deleted bool
+}
+
+// normalizeMode maps git's modes onto goverse ones
+func normalizeMode(mode string) (string) {
+    if len(mode) == 6 && strings.HasPrefix(mode, "100") {
+        return mode[3:]
+    }
+    return mode
+}
+
+// stripPath drops the first strip components of a patch path, "" for
+// /dev/null
+func stripPath(name string, strip int) (string) {
+    name = strings.TrimSpace(name)
+    // "+++ b/file\t2024-01-01 ..." carries a timestamp after a tab
+    if tab := strings.Index(name, "\t"); tab >= 0 {
+        name = name[:tab]
+    }
+    if unquoted, err := strconv.Unquote(name); err == nil && strings.HasPrefix(name, "\"") {
+        name = unquoted
+    }
+    if name == "/dev/null" {
+        return ""
+    }
+    for i := 0; i < strip; i++ {
+        slash := strings.Index(name, "/")
+        if slash < 0 {
+            break
+        }
+        name = name[slash+1:]
+    }
+    return name
+}
+
+// parseHunkHeader reads "@@ -a,b +c,d @@", returning a, b and d, a
+// missing count is 1
+func parseHunkHeader(line string) (int, int, int, error) {
+    bad := fmt.Errorf("Bad hunk header \"%s\"", line)
+    fields := strings.Fields(line)
+    if len(fields) < 4 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+        return 0, 0, 0, bad
+    }
+    numbers := []int{}
+    for _, side := range fields[1:3] {
+        start, count, found := strings.Cut(side[1:], ",")
+        n, err := strconv.Atoi(start)
+        if err != nil {
+            return 0, 0, 0, bad
+        }
+        c := 1
+        if found {
+            c, err = strconv.Atoi(count)
+            if err != nil {
+                return 0, 0, 0, bad
+            }
+        }
+        numbers = append(numbers, n, c)
+    }
+    return numbers[0], numbers[1], numbers[3], nil
+}
+
+// parsePatch reads every file patch in text, anything before the first
+// one (a mail's headers and message) is skipped
+func parsePatch(text string, strip int) ([]filePatch, error) {
+    lines := splitLines([]byte(text))
+    patches := []filePatch{}
+    var current *filePatch
+    for i := 0; i < len(lines); i++ {
+        line := strings.TrimSuffix(lines[i], "\n")
+        switch {
+        case strings.HasPrefix(line, "diff "):
+            patches = append(patches, filePatch{})
+            current = &patches[len(patches)-1]
+            // "diff a/<path> b/<path>" or git's "diff --git a/<path> b/<path>",
+            // the "---" and "+++" lines have the final say
+            fields := strings.Fields(strings.TrimPrefix(strings.TrimPrefix(line, "diff "), "--git "))
+            if len(fields) == 2 {
+                current.oldPath = stripPath(fields[0], strip)
+                current.newPath = stripPath(fields[1], strip)
+            }
+        case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+            if current == nil || len(current.hunks) > 0 {
+                patches = append(patches, filePatch{})
+                current = &patches[len(patches)-1]
+            }
+            current.oldPath = stripPath(strings.TrimPrefix(line, "--- "), strip)
+            current.newPath = stripPath(strings.TrimPrefix(strings.TrimSuffix(lines[i+1], "\n"), "+++ "), strip)
+            current.created = current.created || current.oldPath == ""
+            current.deleted = current.deleted || current.newPath == ""
+            i++
+        case current == nil:
+        case strings.HasPrefix(line, "new file mode "):
+            current.created = true
+            current.newMode = normalizeMode(strings.TrimPrefix(line, "new file mode "))
+        case strings.HasPrefix(line, "deleted file mode "):
+            current.deleted = true
+            current.oldMode = normalizeMode(strings.TrimPrefix(line, "deleted file mode "))
+        case strings.HasPrefix(line, "old mode "):
+            current.oldMode = normalizeMode(strings.TrimPrefix(line, "old mode "))
+        case strings.HasPrefix(line, "new mode "):
+            current.newMode = normalizeMode(strings.TrimPrefix(line, "new mode "))
+        case strings.HasPrefix(line, "rename from "):
+            current.oldPath = strings.TrimPrefix(line, "rename from ")
+        case strings.HasPrefix(line, "rename to "):
+            current.newPath = strings.TrimPrefix(line, "rename to ")
+        case strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch":
+            current.binary = true
+        case strings.HasPrefix(line, "@@ "):
+            h, next, err := parseHunk(lines, i)
+            if err != nil {
+                return nil, err
+            }
+            current.hunks = append(current.hunks, h)
+            i = next - 1
+        }
+    }
+    for _, p := range patches {
+        if p.oldPath == "" && p.newPath == "" {
+            return nil, errors.New("Patch names a file on neither side")
+        }
+        // both sides, "rename from" included, must stay inside the work tree
+        for _, name := range []string{p.oldPath, p.newPath} {
+            if name == "" {
+                continue
+            }
+            if err := checkPath(name); err != nil {
+                return nil, fmt.Errorf("Patch touches a path outside the work tree: %w", err)
+            }
+        }
+    }
+    return patches, nil
+}
+
+// parseHunk reads the hunk whose header is lines[start], returning it and
+// the index of the line after it
+func parseHunk(lines []string, start int) (hunk, int, error) {
+    header := strings.TrimSuffix(lines[start], "\n")
+    oldStart, oldCount, newCount, err := parseHunkHeader(header)
+    if err != nil {
+        return hunk{}, 0, err
+    }
+    h := hunk{oldStart: oldStart, old: []string{}, new: []string{}}
+    // old and new lines still to come
+    remainingOld, remainingNew := oldCount, newCount
+    changed := false
+    i := start + 1
+    last := byte(0)
+    for ; i < len(lines) && (remainingOld > 0 || remainingNew > 0 || strings.HasPrefix(lines[i], "\\")); i++ {
+        line := lines[i]
+        if strings.HasPrefix(line, "\\") {
+            // "\ No newline at end of file" belongs to the line before
+            if last != '+' && len(h.old) > 0 {
+                h.old[len(h.old)-1] = strings.TrimSuffix(h.old[len(h.old)-1], "\n")
+            }
+            if last != '-' && len(h.new) > 0 {
+                h.new[len(h.new)-1] = strings.TrimSuffix(h.new[len(h.new)-1], "\n")
+            }
+            continue
+        }
+        op, text := byte(' '), ""
+        if line != "\n" && line != "" {
+            op, text = line[0], line[1:]
+        } else {
+            text = "\n"
+        }
+        switch op {
+        case ' ':
+            h.old, h.new = append(h.old, text), append(h.new, text)
+            remainingOld--
+            remainingNew--
+            if !changed {
+                h.leading++
+            }
+            h.trailing++
+        case '-':
+            h.old = append(h.old, text)
+            remainingOld--
+            changed, h.trailing = true, 0
+        case '+':
+            h.new = append(h.new, text)
+            remainingNew--
+            changed, h.trailing = true, 0
+        default:
+            return hunk{}, 0, fmt.Errorf("Bad line in hunk \"%s\": \"%s\"", header, strings.TrimSuffix(line, "\n"))
+        }
+        last = op
+    }
+    if remainingOld > 0 || remainingNew > 0 {
+        return hunk{}, 0, fmt.Errorf("Hunk \"%s\" is cut short", header)
+    }
+    if !changed {
+        h.leading = len(h.old)
+    }
+    return h, i, nil
+}
+
+// matchAt says whether want sits in lines at pos
+func matchAt(lines []string, want []string, pos int) (bool) {
+    if pos < 0 || pos + len(want) > len(lines) {
+        return false
+    }
+    for i, line := range want {
+        if lines[pos+i] != line {
+            return false
+        }
+    }
+    return true
+}
+
+// findHunk looks for want in lines at or after from, nearest to expected
+func findHunk(lines []string, want []string, expected int, from int) (int) {
+    for distance := 0; expected - distance >= from || expected + distance <= len(lines); distance++ {
+        if pos := expected - distance; pos >= from && matchAt(lines, want, pos) {
+            return pos
+        }
+        if pos := expected + distance; distance > 0 && pos >= from && matchAt(lines, want, pos) {
+            return pos
+        }
+    }
+    return -1
+}
+
+// applyHunks applies hunks in order to content
+func applyHunks(content []byte, hunks []hunk, fuzz int) ([]byte, error) {
+    lines := splitLines(content)
+    offset, from := 0, 0
+    for n, h := range hunks {
+        expected := h.oldStart - 1 + offset
+        if len(h.old) == 0 {
+            expected = h.oldStart + offset
+        }
+        applied := false
+        for f := 0; f <= fuzz && !applied; f++ {
+            // with fuzz f, drop up to f context lines from either end
+            lead, trail := min(f, h.leading), min(f, h.trailing)
+            if f > 0 && lead == 0 && trail == 0 {
+                break
+            }
+            old := h.old[lead:len(h.old)-trail]
+            new := h.new[lead:len(h.new)-trail]
+            pos := findHunk(lines, old, expected + lead, from)
+            if pos < 0 {
+                continue
+            }
+            rest := append([]string{}, lines[pos+len(old):]...)
+            lines = append(append(lines[:pos], new...), rest...)
+            offset += pos - (expected + lead)
+            offset += len(new) - len(old)
+            from = pos + len(new)
+            applied = true
+        }
+        if !applied {
+            return nil, fmt.Errorf("hunk %d at line %d does not apply", n + 1, h.oldStart)
+        }
+    }
+    return []byte(strings.Join(lines, "")), nil
+}
+
+// applyPatches works out what each patch leaves behind without writing
+// anything, read gives a path's current content and mode, or exists false
+func applyPatches(patches []filePatch, fuzz int, read func(p string) ([]byte, string, bool, error)) (map[string]patchResult, error) {
+    results := map[string]patchResult{}
+    current := func(p string) ([]byte, string, bool, error) {
+        if r, ok := results[p]; ok {
+            return r.content, r.mode, !r.deleted, nil
+        }
+        return read(p)
+    }
+    for _, p := range patches {
+        name := p.newPath
+        if p.deleted || name == "" {
+            name = p.oldPath
+        }
+        if p.binary {
+            return nil, fmt.Errorf("%s: binary patches cannot be applied", name)
+        }
+        source := p.oldPath
+        if p.created {
+            source = p.newPath
+        }
+        content, mode, exists, err := current(source)
+        if err != nil {
+            return nil, err
+        }
+        if p.created && exists {
+            return nil, fmt.Errorf("%s: already exists", name)
+        }
+        if !p.created && !exists {
+            return nil, fmt.Errorf("%s: does not exist: %w", source, ErrObjectNotFound)
+        }
+        if p.created {
+            content, mode = nil, FILE_MODE
+        }
+        if p.oldMode != "" && !p.created && fileMode(p.oldMode) != fileMode(mode) {
+            fmt.Printf("warning: %s has mode %s, the patch expects %s\n", source, mode, p.oldMode)
+        }
+        patched, err := applyHunks(content, p.hunks, fuzz)
+        if err != nil {
+            return nil, fmt.Errorf("%s: %w", name, err)
+        }
+        if p.deleted {
+            if len(patched) > 0 {
+                return nil, fmt.Errorf("%s: would be deleted but lines are left over", name)
+            }
+            results[p.oldPath] = patchResult{deleted: true}
+            continue
+        }
+        if p.newMode != "" {
+            mode = p.newMode
+        }
+        if p.oldPath != "" && p.oldPath != p.newPath && !p.created {
+            // a rename leaves nothing at the old path
+            results[p.oldPath] = patchResult{deleted: true}
+        }
+        results[p.newPath] = patchResult{content: patched, mode: mode}
+    }
+    return results, nil
+}
+
+// readPatchFile reads a patch from file, or stdin for "-"
+func readPatchFile(file string) (string, error) {
+    var content []byte
+    var err error
+    if file == "-" {
+        content, err = io.ReadAll(os.Stdin)
+    } else {
+        content, err = os.ReadFile(file)
+    }
+    if err != nil {
+        return "", &PathError{"read patch", file, err}
+    }
+    return string(content), nil
+}
+
+// Apply applies a patch file ("-" for stdin) to the work tree: "apply
+// [--check] [--cached|--index] [--fuzz <n>] [-p<n>] <patch>...". "--cached"
+// patches the index instead, "--index" both, "--check" only reports
+// whether it would apply. Nothing is written unless every file applies
+func Apply(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    check, cached, index, fuzz, strip := false, false, false, 0, 1
+    files := []string{}
+    for i := 0; i < len(args); i++ {
+        arg := args[i]
+        switch {
+        case arg == "--check":
+            check = true
+        case arg == "--cached":
+            cached = true
+        case arg == "--index":
+            index = true
+        case arg == "--fuzz" || strings.HasPrefix(arg, "--fuzz="):
+            value := strings.TrimPrefix(arg, "--fuzz=")
+            if arg == "--fuzz" {
+                if i+1 >= len(args) {
+                    return errors.New("--fuzz needs a number")
+                }
+                i++
+                value = args[i]
+            }
+            fuzz, err = strconv.Atoi(value)
+            if err != nil || fuzz < 0 {
+                return fmt.Errorf("--fuzz needs a number, not \"%s\"", value)
+            }
+        case strings.HasPrefix(arg, "-p") && len(arg) > 2:
+            strip, err = strconv.Atoi(arg[2:])
+            if err != nil || strip < 0 {
+                return fmt.Errorf("Bad strip count \"%s\"", arg)
+            }
+        case strings.HasPrefix(arg, "-") && arg != "-":
+            return fmt.Errorf("Unknown apply option \"%s\"", arg)
+        default:
+            files = append(files, arg)
+        }
+    }
+    if len(files) == 0 {
+        return errors.New("Apply needs a patch file, \"-\" reads stdin")
+    }
+    patches := []filePatch{}
+    for _, file := range files {
+        text, err := readPatchFile(file)
+        if err != nil {
+            return err
+        }
+        found, err := parsePatch(text, strip)
+        if err != nil {
+            return err
+        }
+        if len(found) == 0 {
+            return fmt.Errorf("No patch found in %s", file)
+        }
+        patches = append(patches, found...)
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    staged := indexFiles(idx)
+    read := readWorkTreeFile
+    if cached || index {
+        read = func(p string) ([]byte, string, bool, error) {
+            entry, ok := staged[p]
+            if !ok {
+                return nil, "", false, nil
+            }
+            content, err := readTypedObject(entry.Hash, BLOB)
+            return content, entry.Mode, err == nil, err
+        }
+    }
+    if index {
+        // the work tree has to agree with the index on what is patched
+        for _, p := range patches {
+            for _, name := range []string{ p.oldPath, p.newPath } {
+                entry, ok := staged[name]
+                if name != "" && ok && workFileHash(name) != entry.Hash {
+                    return fmt.Errorf("%s does not match the index: %w", name, ErrDirtyWorkTree)
+                }
+            }
+        }
+    }
+    results, err := applyPatches(patches, fuzz, read)
+    if err != nil {
+        return err
+    }
+    if check {
+        fmt.Printf("The patch applies cleanly to %s\n", plural(len(results), "file"))
+        return nil
+    }
+
+    if cached || index {
+        files, err := stageResults(staged, results)
+        if err != nil {
+            return err
+        }
+        err = writeIndex(filesIndex(files))
+        if err != nil {
+            return err
+        }
+    }
+    if !cached {
+        for p, r := range results {
+            err = writeResult(p, r)
+            if err != nil {
+                return err
+            }
+        }
+    }
+    for _, p := range sortedResultPaths(results) {
+        switch {
+        case results[p].deleted:
+            fmt.Println("Deleted " + p)
+        default:
+            fmt.Println("Patched " + p)
+        }
+    }
+    return nil
+}
+
+// readWorkTreeFile is the work tree side of applyPatches' read
+func readWorkTreeFile(p string) ([]byte, string, bool, error) {
+    if beyondSymlink(p) {
+        return nil, "", false, fmt.Errorf("%s: beyond a symbolic link", p)
+    }
+    info, err := os.Lstat(BaseDir + p)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, "", false, nil
+    }
+    if err != nil {
+        return nil, "", false, &PathError{"stat", BaseDir + p, err}
+    }
+    mode := workMode(info.Mode())
+    if mode == "" || mode == DIR_MODE {
+        return nil, "", false, fmt.Errorf("%s: not a file", p)
+    }
+    content, err := readWorkContent(BaseDir + p, info)
+    if err != nil {
+        return nil, "", false, err
+    }
+    return content, mode, true, nil
+}
+
+// stageResults stores the patched files as blobs in a copy of files
+func stageResults(files map[string]models.IndexEntry, results map[string]patchResult) (map[string]models.IndexEntry, error) {
+    staged := map[string]models.IndexEntry{}
+    for p, entry := range files {
+        staged[p] = entry
+    }
+    for p, r := range results {
+        if r.deleted {
+            delete(staged, p)
+            continue
+        }
+        b := models.Blob{ Content: r.content }
+        err := storeBlob(b)
+        if err != nil {
+            return nil, err
+        }
+        hash, err := hashBlob(b)
+        if err != nil {
+            return nil, err
+        }
+        staged[p] = models.IndexEntry{ Path: p, Mode: r.mode, Hash: hash }
+    }
+    return staged, nil
+}
+
+// writeResult puts one patched file into the work tree
+func writeResult(p string, r patchResult) (error) {
+    full := BaseDir + p
+    if r.deleted {
+        err := os.Remove(full)
+        if err != nil && !errors.Is(err, fs.ErrNotExist) {
+            return &PathError{"remove", full, err}
+        }
+        removeEmptyParents(p)
+        return nil
+    }
+    return writeWorkContent(p, r.mode, r.content, false)
+}
+
+func sortedResultPaths(results map[string]patchResult) ([]string) {
+    paths := []string{}
+    for p := range results {
+        paths = append(paths, p)
+    }
+    sort.Strings(paths)
+    return paths
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestStripPath(t *testing.T) {
+    tests := []struct {
+        name  string
+        strip int
+        want  string
+    }{
+        {"a/file.txt", 1, "file.txt"},
+        {"a/dir/file.txt", 2, "file.txt"},
+        {"file.txt", 0, "file.txt"},
+        {"b/file.txt\t2024-01-01 10:00:00", 1, "file.txt"},
+        {"\"b/with space\"", 1, "with space"},
+        {"/dev/null", 1, ""},
+    }
+    for _, tt := range tests {
+        if got := stripPath(tt.name, tt.strip); got != tt.want {
+            t.Errorf("stripPath(%q, %d) = %q, want %q", tt.name, tt.strip, got, tt.want)
+        }
+    }
+}
+
+func TestParsePatchRefusesUnsafePaths(t *testing.T) {
+    hunk := "@@ -0,0 +1 @@\n+pwned\n"
+    tests := []struct {
+        name  string
+        patch string
+        strip int
+        err   error
+    }{
+        {"plain file", "--- a/file\n+++ b/file\n" + hunk, 1, nil},
+        {"new file", "--- /dev/null\n+++ b/dir/file\n" + hunk, 1, nil},
+        {"parent in new path", "--- /dev/null\n+++ b/../outside_gv\n" + hunk, 1, ErrUnsafePath},
+        {"parent in old path", "--- a/dir/../../outside_gv\n+++ b/file\n" + hunk, 1, ErrUnsafePath},
+        {"absolute path", "--- /etc/passwd\n+++ /etc/passwd\n" + hunk, 0, ErrUnsafePath},
+        {"into .goverse", "--- /dev/null\n+++ b/.goverse/head\n" + hunk, 1, ErrUnsafePath},
+        {"rename from outside", "diff --git a/x b/y\nrename from ../outside_gv\nrename to y\n", 1, ErrUnsafePath},
+        {"rename to outside", "diff --git a/x b/y\nrename from x\nrename to ../outside_gv\n", 1, ErrUnsafePath},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := parsePatch(tt.patch, tt.strip)
+            if tt.err == nil && err != nil {
+                t.Fatalf("parsePatch = %v, want no error", err)
+            }
+            if tt.err != nil && !errors.Is(err, tt.err) {
+                t.Fatalf("parsePatch = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
+
+func TestApplyStaysInsideWorkTree(t *testing.T) {
+    dir := testRepo(t)
+    outside := filepath.Join(filepath.Dir(filepath.Clean(dir)), "outside_gv")
+    patch := filepath.Join(t.TempDir(), "evil.patch")
+    err := os.WriteFile(patch, []byte("--- /dev/null\n+++ b/../outside_gv\n@@ -0,0 +1 @@\n+pwned\n"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, args := range [][]string{ {patch}, {"--index", patch} } {
+        err = Apply(args)
+        if !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("apply %v = %v, want ErrUnsafePath", args, err)
+        }
+    }
+    mustNotExist(t, outside)
+
+    mbox := filepath.Join(t.TempDir(), "evil.mbox")
+    mail := "From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001\n" +
+        "From: Evil <evil@example.com>\nDate: Mon, 1 Jan 2024 00:00:00 +0000\nSubject: [PATCH] evil\n\n" +
+        "---\ndiff --git a/x b/y\nrename from x\nrename to ../outside_gv\n"
+    err = os.WriteFile(mbox, []byte(mail), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = Am([]string{ mbox })
+    if !errors.Is(err, ErrUnsafePath) {
+        t.Errorf("am = %v, want ErrUnsafePath", err)
+    }
+    mustNotExist(t, outside)
+}
+
+func TestApplyHunksFuzz(t *testing.T) {
+    base := "one\ntwo\nthree\nfour\nfive\n"
+    tests := []struct {
+        name    string
+        content string
+        fuzz    int
+        want    string
+        ok      bool
+    }{
+        {"exact", base, 0, "one\ntwo\nTHREE\nfour\nfive\n", true},
+        {"shifted", "zero\n" + base, 0, "zero\none\ntwo\nTHREE\nfour\nfive\n", true},
+        {"changed context", strings.Replace(base, "two", "2", 1), 0, "", false},
+        {"changed context with fuzz", strings.Replace(base, "two", "2", 1), 1, "one\n2\nTHREE\nfour\nfive\n", true},
+    }
+    h := hunk{ oldStart: 2, old: []string{"two\n", "three\n", "four\n"}, new: []string{"two\n", "THREE\n", "four\n"}, leading: 1, trailing: 1 }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := applyHunks([]byte(tt.content), []hunk{ h }, tt.fuzz)
+            if (err == nil) != tt.ok {
+                t.Fatalf("applyHunks = %v, want ok %v", err, tt.ok)
+            }
+            if tt.ok && string(got) != tt.want {
+                t.Fatalf("applyHunks = %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
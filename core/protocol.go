@@ -0,0 +1,230 @@
+package core
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+//////////////
+// PROTOCOL //
+//////////////
+
+// What a client and a server that do not share a filesystem say to each
+// other, every message is lines of text and a pack may follow:
+//
+//     refs:         "<ref> <value>" per ref, value as advertiseRefs gives
+//                   it, then an empty line
+//     upload-pack:  "want <hash>", "have <hash>" and "depth <n>" lines,
+//                   answered with a pack of what the client lacks
+//     receive-pack: "update <ref> <old> <new> [force]" lines, old is
+//                   ZERO_HASH for a new ref, then an empty line and the
+//                   pack, answered with "ok <ref>" or "ng <ref> <reason>"
+
+func writeRefs(w io.Writer, refs map[string]string) (error) {
+    names := []string{}
+    for ref := range refs {
+        names = append(names, ref)
+    }
+    sort.Strings(names)
+    for _, ref := range names {
+        _, err := fmt.Fprintf(w, "%s %s\n", ref, refs[ref])
+        if err != nil {
+            return err
+        }
+    }
+    _, err := io.WriteString(w, "\n")
+    return err
+}
+
+// readRefs reads an advertisement up to its empty line
+func readRefs(r *bufio.Reader) (map[string]string, error) {
+    refs := map[string]string{}
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return nil, fmt.Errorf("Ref advertisement ended early: %w", err)
+        }
+        line = strings.TrimSuffix(line, "\n")
+        if line == "" {
+            return refs, nil
+        }
+        ref, value, found := strings.Cut(line, " ")
+        if !found {
+            return nil, fmt.Errorf("Bad ref line \"%s\" from remote", line)
+        }
+        err = checkAdvertisedRef(ref, value)
+        if err != nil {
+            return nil, err
+        }
+        refs[ref] = value
+    }
+}
+
+// checkAdvertisedRef refuses anything but HEAD, branches and tags with
+// valid names pointing at whole hashes, fetched ref names become paths
+// under .goverse/
+func checkAdvertisedRef(ref string, value string) (error) {
+    if ref == HEAD_REF {
+        if strings.HasPrefix(value, SYMREF_PREFIX) {
+            return checkAdvertisedName(strings.TrimPrefix(value, SYMREF_PREFIX))
+        }
+    } else {
+        err := checkAdvertisedName(ref)
+        if err != nil {
+            return err
+        }
+    }
+    if !isObjectHash(value) {
+        return fmt.Errorf("Remote advertised %s as \"%s\", which is not a hash: %w", ref, value, ErrCorruptObject)
+    }
+    return nil
+}
+
+func checkAdvertisedName(ref string) (error) {
+    for _, prefix := range []string{ BRANCHES_PREFIX, TAGS_PREFIX } {
+        if strings.HasPrefix(ref, prefix) {
+            err := checkRefName(strings.TrimPrefix(ref, prefix))
+            if err != nil {
+                return fmt.Errorf("Remote advertised a bad ref, %v: %w", err, ErrUnsafePath)
+            }
+            return nil
+        }
+    }
+    return fmt.Errorf("Remote advertised \"%s\", which is not a branch or tag: %w", ref, ErrUnsafePath)
+}
+
+func writeWants(w io.Writer, wants []string, haves []string, depth int) (error) {
+    var b strings.Builder
+    for _, want := range wants {
+        b.WriteString("want " + want + "\n")
+    }
+    for _, have := range haves {
+        b.WriteString("have " + have + "\n")
+    }
+    if depth > 0 {
+        fmt.Fprintf(&b, "depth %d\n", depth)
+    }
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// readWants reads an upload-pack request up to its end or an empty line
+func readWants(r *bufio.Reader) ([]string, []string, int, error) {
+    wants, haves, depth := []string{}, []string{}, 0
+    for {
+        line, err := r.ReadString('\n')
+        line = strings.TrimSpace(line)
+        if line == "" {
+            if err == nil || err == io.EOF {
+                return wants, haves, depth, nil
+            }
+            return nil, nil, 0, err
+        }
+        word, arg, _ := strings.Cut(line, " ")
+        switch {
+        case word == "want" && isHex(arg):
+            wants = append(wants, arg)
+        case word == "have" && isHex(arg):
+            haves = append(haves, arg)
+        case word == "depth":
+            depth, err = strconv.Atoi(arg)
+            if err != nil {
+                return nil, nil, 0, fmt.Errorf("Bad depth \"%s\"", arg)
+            }
+        default:
+            return nil, nil, 0, fmt.Errorf("Bad upload-pack line \"%s\"", line)
+        }
+        if err == io.EOF {
+            return wants, haves, depth, nil
+        }
+    }
+}
+
+// writeUpdates writes the update lines of a receive-pack request and the
+// empty line the pack follows
+func writeUpdates(w io.Writer, updates []refUpdate) (error) {
+    var b strings.Builder
+    for _, u := range updates {
+        old := u.old
+        if old == "" {
+            old = ZERO_HASH
+        }
+        fmt.Fprintf(&b, "update %s %s %s", u.ref, old, u.new)
+        if u.force {
+            b.WriteString(" force")
+        }
+        b.WriteString("\n")
+    }
+    b.WriteString("\n")
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// readUpdates reads update lines up to the empty line, leaving r at the pack
+func readUpdates(r *bufio.Reader) ([]refUpdate, error) {
+    updates := []refUpdate{}
+    for {
+        line, err := r.ReadString('\n')
+        if err != nil {
+            return nil, fmt.Errorf("Receive-pack request ended early: %w", err)
+        }
+        fields := strings.Fields(line)
+        if len(fields) == 0 {
+            return updates, nil
+        }
+        if fields[0] != "update" || len(fields) < 4 || len(fields) > 5 || !isHex(fields[2]) || !isHex(fields[3]) {
+            return nil, fmt.Errorf("Bad receive-pack line \"%s\"", strings.TrimSpace(line))
+        }
+        u := refUpdate{ref: fields[1], old: fields[2], new: fields[3], force: len(fields) == 5 && fields[4] == "force"}
+        if u.old == ZERO_HASH {
+            u.old = ""
+        }
+        updates = append(updates, u)
+    }
+}
+
+func writeResults(w io.Writer, updates []refUpdate, results []string) (error) {
+    var b strings.Builder
+    for i, u := range updates {
+        if results[i] == "" {
+            b.WriteString("ok " + u.ref + "\n")
+        } else {
+            b.WriteString("ng " + u.ref + " " + results[i] + "\n")
+        }
+    }
+    _, err := io.WriteString(w, b.String())
+    return err
+}
+
+// readResults matches "ok"/"ng" lines back up with the updates sent
+func readResults(r io.Reader, updates []refUpdate) ([]string, error) {
+    reasons := map[string]string{}
+    answered := map[string]bool{}
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        fields := strings.SplitN(scanner.Text(), " ", 3)
+        switch {
+        case len(fields) >= 2 && fields[0] == "ok":
+        case len(fields) == 3 && fields[0] == "ng":
+            reasons[fields[1]] = fields[2]
+        default:
+            return nil, fmt.Errorf("Bad receive-pack result \"%s\" from remote", scanner.Text())
+        }
+        answered[fields[1]] = true
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    results := make([]string, len(updates))
+    for i, u := range updates {
+        results[i] = reasons[u.ref]
+        if !answered[u.ref] {
+            results[i] = "no answer from remote"
+        }
+    }
+    return results, nil
+}
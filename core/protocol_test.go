@@ -0,0 +1,96 @@
+package core
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestReadRefs(t *testing.T) {
+    hash := strings.Repeat("a", 40)
+    tests := []struct {
+        name  string
+        input string
+        err   error
+    }{
+        {"branches and tags", "branches/main " + hash + "\ntags/v1.0 " + hash + "\n\n", nil},
+        {"symbolic head", "head ref: branches/main\nbranches/main " + hash + "\n\n", nil},
+        {"detached head", "head " + hash + "\n\n", nil},
+        {"tag escaping .goverse", "tags/../../../pwned_gv " + hash + "\n\n", ErrUnsafePath},
+        {"branch escaping .goverse", "branches/../config " + hash + "\n\n", ErrUnsafePath},
+        {"head pointing outside", "head ref: ../../pwned_gv\n\n", ErrUnsafePath},
+        {"not a branch or tag", "config " + hash + "\n\n", ErrUnsafePath},
+        {"remote-tracking ref", "remotes/origin/main " + hash + "\n\n", ErrUnsafePath},
+        {"short hash", "branches/main abc\n\n", ErrCorruptObject},
+        {"path as value", "branches/main ../../etc/passwd\n\n", ErrCorruptObject},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := readRefs(bufio.NewReader(strings.NewReader(tt.input)))
+            if tt.err == nil && err != nil {
+                t.Fatalf("readRefs = %v, want no error", err)
+            }
+            if tt.err != nil && !errors.Is(err, tt.err) {
+                t.Fatalf("readRefs = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
+
+// fakeRemote serves refs as its advertisement and an empty pack for every
+// upload-pack, whatever was asked for
+func fakeRemote(t *testing.T, refs string) (string) {
+    t.Helper()
+    mux := http.NewServeMux()
+    mux.HandleFunc("/refs", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, refs)
+    })
+    mux.HandleFunc("/upload-pack", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "%s %d 0\n", PACK_MAGIC, PACK_VERSION)
+    })
+    server := httptest.NewServer(mux)
+    t.Cleanup(server.Close)
+    return server.URL
+}
+
+func TestFetchRefusesTraversingRefs(t *testing.T) {
+    dir := testRepo(t)
+    head, err := getHead()
+    if err != nil {
+        t.Fatal(err)
+    }
+    url := fakeRemote(t, "tags/../../../pwned_gv " + head + "\n\n")
+    err = Remote([]string{ "add", "origin", url })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = fetchRemote("origin", nil, false, 0)
+    if !errors.Is(err, ErrUnsafePath) {
+        t.Fatalf("fetch = %v, want ErrUnsafePath", err)
+    }
+    mustNotExist(t, filepath.Join(filepath.Dir(filepath.Clean(dir)), "pwned_gv"))
+}
+
+func TestFetchNeedsEveryWantedObject(t *testing.T) {
+    testRepo(t)
+    missing := strings.Repeat("b", 40)
+    url := fakeRemote(t, "branches/main " + missing + "\ntags/v1 " + missing + "\n\n")
+    err := Remote([]string{ "add", "origin", url })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = fetchRemote("origin", nil, false, 0)
+    if !errors.Is(err, ErrObjectNotFound) {
+        t.Fatalf("fetch = %v, want ErrObjectNotFound", err)
+    }
+    for _, ref := range []string{ REMOTES_PREFIX + "origin/main", TAGS_PREFIX + "v1" } {
+        if refExists(ref) {
+            t.Errorf("%s was written without its object", ref)
+        }
+    }
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+//////////
+// PULL //
+//////////
+
+// A branch's upstream is the remote branch pull brings in and status
+// compares against, set as "branch.<name>.remote" and
+// "branch.<name>.merge" by clone and "push -u". A branch without one
+// follows the branch of the same name on origin.
+//
+// "pull.strategy" picks how pull integrates the upstream:
+//
+//     ff-only  only fast-forward, refusing when the branches have diverged
+//     merge    fast-forward when possible, otherwise commit a merge (default)
+//     rebase   replay the local commits on top of the upstream
+
+const (
+    STRATEGY_FF_ONLY = "ff-only"
+    STRATEGY_MERGE   = "merge"
+    STRATEGY_REBASE  = "rebase"
+)
+
+var PULL_STRATEGIES = map[string]bool{
+    STRATEGY_FF_ONLY: true,
+    STRATEGY_MERGE:   true,
+    STRATEGY_REBASE:  true,
+}
+
+// upstream returns the remote and the remote branch that branch follows
+func upstream(branch string) (string, string) {
+    remote := getConfig("branch." + branch + ".remote", DEFAULT_REMOTE)
+    merge := getConfig("branch." + branch + ".merge", branch)
+    return remote, merge
+}
+
+// setUpstream makes branch follow merge on remote
+func setUpstream(branch string, remote string, merge string) (error) {
+    err := setConfig("branch." + branch + ".remote", remote)
+    if err != nil {
+        return err
+    }
+    return setConfig("branch." + branch + ".merge", merge)
+}
+
+// trackingRef is the ref a fetch from remote keeps branch in
+func trackingRef(remote string, branch string) (string) {
+    return REMOTES_PREFIX + remote + "/" + branch
+}
+
+// aheadBehind counts the commits local has that upstream does not, and
+// the other way around
+func aheadBehind(local string, upstream string) (int, int, error) {
+    ahead, err := revList([]string{ local }, []string{ upstream })
+    if err != nil {
+        return 0, 0, err
+    }
+    behind, err := revList([]string{ upstream }, []string{ local })
+    if err != nil {
+        return 0, 0, err
+    }
+    return len(ahead), len(behind), nil
+}
+
+// trackingStatus describes where branch stands against its upstream, ""
+// when the upstream has not been fetched
+func trackingStatus(branch string, head string) (string, error) {
+    remote, merge := upstream(branch)
+    ref := trackingRef(remote, merge)
+    if !refExists(ref) {
+        return "", nil
+    }
+    theirs, err := readRef(ref)
+    if err != nil {
+        return "", err
+    }
+    ahead, behind, err := aheadBehind(head, theirs)
+    if err != nil {
+        return "", err
+    }
+    name := shortRef(ref)
+    switch {
+    case ahead > 0 && behind > 0:
+        return fmt.Sprintf("Your branch and '%s' have diverged,\nand have %d and %d different commits each, respectively.", name, ahead, behind), nil
+    case ahead > 0:
+        return fmt.Sprintf("Your branch is ahead of '%s' by %s.", name, plural(ahead, "commit")), nil
+    case behind > 0:
+        return fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.", name, plural(behind, "commit")), nil
+    }
+    return fmt.Sprintf("Your branch is up to date with '%s'.", name), nil
+}
+
+func plural(n int, word string) (string) {
+    if n == 1 {
+        return fmt.Sprintf("%d %s", n, word)
+    }
+    return fmt.Sprintf("%d %ss", n, word)
+}
+
+// Pull fetches the upstream of the checked out branch and integrates it
+// as "pull.strategy" says, or as "--ff-only", "--merge" or "--rebase" say.
+// A merge that stops on a conflict is followed up with "--continue",
+// "--skip" or "--abort", a rebase with "rebase --continue" and friends
+func Pull(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if len(args) == 1 {
+        switch args[0] {
+        case "--continue":
+            return continueSequence(REASON_PULL)
+        case "--skip":
+            return skipSequence(REASON_PULL)
+        case "--abort":
+            return abortSequence(REASON_PULL)
+        }
+    }
+    strategy := getConfig("pull.strategy", STRATEGY_MERGE)
+    for _, arg := range args {
+        switch arg {
+        case "--ff-only", "--merge", "--rebase":
+            strategy = strings.TrimPrefix(arg, "--")
+        default:
+            return fmt.Errorf("Unknown pull option \"%s\"", arg)
+        }
+    }
+    if !PULL_STRATEGIES[strategy] {
+        return fmt.Errorf("pull.strategy is \"%s\", not one of ff-only, merge or rebase", strategy)
+    }
+    if sequencerActive() {
+        owner, _ := readSequencerFile("command")
+        return fmt.Errorf("A %s is already in progress, use --continue or --abort", strings.TrimSpace(owner))
+    }
+    branch, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        return errors.New("HEAD is detached, check out a branch to pull into")
+    }
+
+    remote, merge := upstream(branch)
+    err = fetchRemote(remote, nil, false, 0)
+    if err != nil {
+        return err
+    }
+    ref := trackingRef(remote, merge)
+    if !refExists(ref) {
+        return fmt.Errorf("Remote \"%s\" has no branch \"%s\": %w", remote, merge, ErrObjectNotFound)
+    }
+    theirs, err := readRef(ref)
+    if err != nil {
+        return err
+    }
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+
+    switch {
+    case head != "" && isAncestor(theirs, head):
+        fmt.Println("Already up to date.")
+        return nil
+    case head == "" || isAncestor(head, theirs):
+        return fastForward(head, theirs, shortRef(ref))
+    case strategy == STRATEGY_FF_ONLY:
+        fmt.Printf("hint: Your branch and '%s' have diverged, set pull.strategy to merge or rebase\n", shortRef(ref))
+        return fmt.Errorf("Not possible to fast-forward: %w", ErrNotFastForward)
+    case strategy == STRATEGY_REBASE:
+        return Rebase([]string{ shortRef(ref) })
+    }
+    url, err := remoteURL(remote)
+    if err != nil {
+        return err
+    }
+    message := fmt.Sprintf("Merge branch '%s' of %s", merge, url)
+    err = startSequence(REASON_PULL, []todoItem{ {ACTION_MERGE, theirs, message} })
+    if err != nil {
+        return err
+    }
+    return runSequence(REASON_PULL)
+}
+
+// fastForward moves the checked out branch, index and work tree from head
+// up to theirs, keeping local edits to files the two agree on
+func fastForward(head string, theirs string, name string) (error) {
+    current := map[string]models.IndexEntry{}
+    if head != "" {
+        err := requireCleanIndex(head)
+        if err != nil {
+            return err
+        }
+        current, err = commitFiles(head)
+        if err != nil {
+            return err
+        }
+    }
+    files, err := commitFiles(theirs)
+    if err != nil {
+        return err
+    }
+    err = checkoutFiles(current, files, false)
+    if err != nil {
+        return err
+    }
+    err = writeIndex(filesIndex(files))
+    if err != nil {
+        return err
+    }
+    expected := head
+    if head == "" {
+        expected = ZERO_HASH
+    }
+    err = setHead(theirs, expected, REASON_PULL, "Fast-forward to " + name)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Updating %s..%s\nFast-forward\n", truncHash(head), truncHash(theirs))
+    return nil
+}
+
+// applyMerge merges the files of c into HEAD's against their merge base,
+// returning the paths left in conflict
+func applyMerge(c models.Commit) ([]mergeConflict, error) {
+    head, err := getHead()
+    if err != nil {
+        return nil, err
+    }
+    bases, err := mergeBases(head, c.Hash)
+    if err != nil {
+        return nil, err
+    }
+    base := map[string]models.IndexEntry{}
+    if len(bases) > 0 {
+        base, err = commitFiles(bases[0])
+        if err != nil {
+            return nil, err
+        }
+    }
+    ours, err := commitFiles(head)
+    if err != nil {
+        return nil, err
+    }
+    theirs, err := commitFiles(c.Hash)
+    if err != nil {
+        return nil, err
+    }
+    merged, conflicts, err := mergeFiles(base, ours, theirs, "HEAD", truncHash(c.Hash))
+    if err != nil {
+        return nil, err
+    }
+    err = checkoutFiles(ours, merged, false)
+    if err != nil {
+        return nil, err
+    }
+    return conflicts, writeIndex(filesIndex(merged))
+}
+
+// commitMerge commits the index with HEAD and theirs as parents
+func commitMerge(reason string, message string, theirs string) (error) {
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+    return commitTree(reason, head, tree, []string{ head, theirs }, message, getIdentity(), "")
+}
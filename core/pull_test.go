@@ -0,0 +1,118 @@
+package core
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestPull(t *testing.T) {
+    tests := []struct {
+        name string
+        args []string
+        // a local commit adds "keep", setting "file" to ours unless "",
+        // the remote sets "file" to theirs unless ""
+        commit bool
+        ours   string
+        theirs string
+        err    error
+        // where HEAD ends up, "merge" for a merge of ours and theirs
+        head string
+        file string
+    }{
+        {"fast-forward", nil, false, "", "theirs\n", nil, "theirs", "theirs\n"},
+        {"up to date", nil, true, "ours\n", "", nil, "ours", "ours\n"},
+        {"merge", nil, true, "", "theirs\n", nil, "merge", "theirs\n"},
+        {"conflict then abort", nil, true, "ours\n", "theirs\n", ErrConflict, "ours", "ours\n"},
+        {"ff-only diverged", []string{ "--ff-only" }, true, "ours\n", "theirs\n", ErrNotFastForward, "ours", "ours\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            upstream, base := remoteRepo(t)
+            named := map[string]string{ "theirs": base, "ours": base }
+            if tt.theirs != "" {
+                named["theirs"] = commitIn(t, upstream, tt.theirs)
+            }
+            if tt.commit {
+                writeTestFile(t, "keep", "keep\n")
+                if tt.ours != "" {
+                    writeTestFile(t, "file", tt.ours)
+                }
+                named["ours"] = commitAll(t, "ours")
+                // nothing pulled touches keep, so the edit has to survive
+                writeTestFile(t, "keep", "precious local edit\n")
+            }
+
+            err := Pull(tt.args)
+            if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+                t.Fatalf("pull = %v, want %v", err, tt.err)
+            }
+            if errors.Is(err, ErrConflict) {
+                err = Pull([]string{ "--abort" })
+                if err != nil {
+                    t.Fatalf("pull --abort: %v", err)
+                }
+            }
+
+            head, err := getHead()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tt.head == "merge" {
+                c, err := deserializeCommit(head)
+                want := []string{ named["ours"], named["theirs"] }
+                if err != nil || !reflect.DeepEqual(c.Parents, want) {
+                    t.Fatalf("HEAD has parents %v, %v, want ours and theirs", c.Parents, err)
+                }
+            } else if head != named[tt.head] {
+                t.Fatalf("HEAD is %s, want %s", head, tt.head)
+            }
+            if got := readTestFile(t, "file"); got != tt.file {
+                t.Fatalf("file holds %q, want %q", got, tt.file)
+            }
+            if got := readTestFile(t, "keep"); tt.commit && got != "precious local edit\n" {
+                t.Fatalf("the local edit to keep became %q", got)
+            }
+            if sequencerActive() {
+                t.Fatal("the pull was left in progress")
+            }
+        })
+    }
+}
+
+func TestTrackingStatus(t *testing.T) {
+    tests := []struct {
+        ours   int
+        theirs int
+        want   string
+    }{
+        {0, 0, "Your branch is up to date with 'origin/main'."},
+        {1, 0, "Your branch is ahead of 'origin/main' by 1 commit."},
+        {0, 2, "Your branch is behind 'origin/main' by 2 commits, and can be fast-forwarded."},
+        {2, 1, "Your branch and 'origin/main' have diverged,\nand have 2 and 1 different commits each, respectively."},
+    }
+    for _, tt := range tests {
+        upstream, _ := remoteRepo(t)
+        for i := 0; i < tt.theirs; i++ {
+            commitIn(t, upstream, string(rune('a' + i)) + "\n")
+        }
+        for i := 0; i < tt.ours; i++ {
+            writeTestFile(t, "other", string(rune('a' + i)) + "\n")
+            commitAll(t, "ours")
+        }
+        err := Fetch(nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        head, _ := getHead()
+        theirs, _ := resolveRef(trackingRef(DEFAULT_REMOTE, DEFAULT_BRANCH))
+        ahead, behind, err := aheadBehind(head, theirs)
+        if err != nil || ahead != tt.ours || behind != tt.theirs {
+            t.Errorf("aheadBehind = %d, %d, %v, want %d, %d", ahead, behind, err, tt.ours, tt.theirs)
+        }
+        got, err := trackingStatus(DEFAULT_BRANCH, head)
+        if err != nil || got != tt.want {
+            t.Errorf("trackingStatus = %q, %v, want %q", got, err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,292 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+////////////
+// REBASE //
+////////////
+
+// short forms accepted in a rebase todo file
+var TODO_ACTIONS = map[string]string{
+    "p": ACTION_PICK,   ACTION_PICK: ACTION_PICK,
+    "s": ACTION_SQUASH, ACTION_SQUASH: ACTION_SQUASH,
+    "f": ACTION_FIXUP,  ACTION_FIXUP: ACTION_FIXUP,
+    "r": ACTION_REWORD, ACTION_REWORD: ACTION_REWORD,
+    "d": ACTION_DROP,   ACTION_DROP: ACTION_DROP,
+}
+
+// Rebase replays the commits a branch has and upstream does not on top of
+// upstream, or of newbase with "--onto <newbase>":
+// "rebase [--onto <newbase>] <upstream> [<branch>]". "--todo <file>" reads
+// the list of "<action> <commit> [<message>]" lines to replay from file, with
+// actions pick, squash, fixup, reword (taking the line's message) and drop.
+// "--continue", "--skip" and "--abort" follow up on a conflict
+func Rebase(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if len(args) == 1 {
+        switch args[0] {
+        case "--continue":
+            return continueSequence(REASON_REBASE)
+        case "--skip":
+            return skipSequence(REASON_REBASE)
+        case "--abort":
+            return abortSequence(REASON_REBASE)
+        }
+    }
+    onto, todoFile := "", ""
+    positional := []string{}
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--onto" || args[i] == "--todo":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s needs a value", args[i])
+            }
+            if args[i] == "--onto" {
+                onto = args[i+1]
+            } else {
+                todoFile = args[i+1]
+            }
+            i++
+        case strings.HasPrefix(args[i], "-"):
+            return fmt.Errorf("Unknown rebase option \"%s\"", args[i])
+        default:
+            positional = append(positional, args[i])
+        }
+    }
+    if len(positional) == 0 || len(positional) > 2 {
+        return errors.New("Rebase needs an upstream and at most one branch")
+    }
+    if sequencerActive() {
+        owner, _ := readSequencerFile("command")
+        return fmt.Errorf("A %s is already in progress, use --continue or --abort", strings.TrimSpace(owner))
+    }
+    // resolve both before switching, so a bad one leaves HEAD alone and
+    // "HEAD" means where we started
+    upstream, err := resolveCommit(positional[0])
+    if err != nil {
+        return err
+    }
+    if onto == "" {
+        onto = positional[0]
+    }
+    ontoHash, err := resolveCommit(onto)
+    if err != nil {
+        return err
+    }
+    if len(positional) == 2 {
+        err = switchToBranch(positional[1])
+        if err != nil {
+            return err
+        }
+    }
+
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    if head == "" {
+        return errors.New("No commits yet, nothing to rebase")
+    }
+    branch, err := headTarget()
+    if err != nil {
+        return err
+    }
+    if todoFile == "" && ontoHash == upstream && isAncestor(upstream, head) {
+        fmt.Println("Current branch is up to date")
+        return nil
+    }
+
+    items, err := rebaseTodo(head, upstream, todoFile)
+    if err != nil {
+        return err
+    }
+    if len(items) == 0 && ontoHash == head {
+        fmt.Println("Current branch is up to date")
+        return nil
+    }
+
+    err = startSequence(REASON_REBASE, items)
+    if err != nil {
+        return err
+    }
+    err = writeSequencerFile("onto", ontoHash + "\n")
+    if err == nil {
+        err = writeSequencerFile("branch", branch + "\n")
+    }
+    if err == nil {
+        err = detachAt(ontoHash, "checkout " + onto)
+    }
+    if err != nil {
+        endSequence()
+        return err
+    }
+    return runSequence(REASON_REBASE)
+}
+
+// rebaseTodo lists what to replay, from todoFile when given, otherwise
+// every non-merge commit in upstream..head oldest first
+func rebaseTodo(head string, upstream string, todoFile string) ([]todoItem, error) {
+    if todoFile != "" {
+        content, err := os.ReadFile(todoFile)
+        if err != nil {
+            return nil, &PathError{"read todo", todoFile, err}
+        }
+        items, err := parseTodo(string(content))
+        if err != nil {
+            return nil, err
+        }
+        // a squash or fixup needs a commit of the rebase's own to fold into
+        picked := false
+        for i, item := range items {
+            action, ok := TODO_ACTIONS[item.action]
+            if !ok {
+                return nil, fmt.Errorf("Unknown todo action \"%s\"", item.action)
+            }
+            hash, err := resolveCommit(item.hash)
+            if err != nil {
+                return nil, err
+            }
+            if (action == ACTION_SQUASH || action == ACTION_FIXUP) && !picked {
+                return nil, fmt.Errorf("Cannot %s %s without a commit before it", action, item.hash)
+            }
+            picked = picked || action != ACTION_DROP
+            if action == ACTION_REWORD && item.subject == "" {
+                return nil, fmt.Errorf("reword %s needs the new message on its line", item.hash)
+            }
+            items[i].action, items[i].hash = action, hash
+        }
+        return items, nil
+    }
+
+    commits, err := revList([]string{ head }, []string{ upstream })
+    if err != nil {
+        return nil, err
+    }
+    items := []todoItem{}
+    for i := len(commits) - 1; i >= 0; i-- {
+        if len(commits[i].Parents) > 1 {
+            fmt.Printf("Dropping merge %s %s\n", truncHash(commits[i].Hash), firstLine(commits[i].Message))
+            continue
+        }
+        items = append(items, todoItem{ACTION_PICK, commits[i].Hash, firstLine(commits[i].Message)})
+    }
+    return items, nil
+}
+
+// detachAt points HEAD straight at hash and checks it out
+func detachAt(hash string, message string) (error) {
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    from, err := commitFiles(head)
+    if err != nil {
+        return err
+    }
+    to, err := commitFiles(hash)
+    if err != nil {
+        return err
+    }
+    err = checkoutFiles(from, to, false)
+    if err != nil {
+        return err
+    }
+    err = writeIndex(filesIndex(to))
+    if err != nil {
+        return err
+    }
+    return updateRef(HEAD_REF, hash, "", REASON_REBASE, message)
+}
+
+// switchToBranch checks out an existing branch before rebasing it
+func switchToBranch(name string) (error) {
+    ref := BRANCHES_PREFIX + name
+    if !refExists(ref) {
+        return fmt.Errorf("Branch \"%s\": %w", name, ErrObjectNotFound)
+    }
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    target, err := resolveRef(ref)
+    if err != nil {
+        return err
+    }
+    if head != "" {
+        err = requireCleanIndex(head)
+        if err != nil {
+            return err
+        }
+        from, err := commitFiles(head)
+        if err != nil {
+            return err
+        }
+        to, err := commitFiles(target)
+        if err != nil {
+            return err
+        }
+        err = checkoutFiles(from, to, false)
+        if err != nil {
+            return err
+        }
+        err = writeIndex(filesIndex(to))
+        if err != nil {
+            return err
+        }
+    }
+    err = setHeadRef(ref)
+    if err != nil {
+        return err
+    }
+    return appendReflog(HEAD_REF, head, target, REASON_CHECKOUT, "moving to " + name)
+}
+
+// finishRebase moves the rebased branch to where HEAD ended up and puts
+// HEAD back on it
+func finishRebase() (error) {
+    branch, err := readSequencerFile("branch")
+    if err != nil {
+        return err
+    }
+    branch = strings.TrimSpace(branch)
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        fmt.Println("Successfully rebased detached HEAD")
+        return nil
+    }
+    orig, err := readSequencerFile("head")
+    if err != nil {
+        return err
+    }
+    onto, err := readSequencerFile("onto")
+    if err != nil {
+        return err
+    }
+    err = updateRef(branch, head, strings.TrimSpace(orig), REASON_REBASE, "finished onto " + strings.TrimSpace(onto))
+    if err != nil {
+        return err
+    }
+    err = setHeadRef(branch)
+    if err != nil {
+        return err
+    }
+    fmt.Println("Successfully rebased and updated " + shortRef(branch))
+    return nil
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// forkedRepo makes main and feature diverge from a common base:
+// base - m1 on main and base - f1 on feature, with main checked out
+func forkedRepo(t *testing.T) (string, string, string) {
+    t.Helper()
+    testRepo(t)
+    writeTestFile(t, "base", "base\n")
+    base := commitAll(t, "base")
+    err := Branch([]string{ "feature" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "main", "main\n")
+    m1 := commitAll(t, "m1")
+    err = switchToBranch("feature")
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "feature", "feature\n")
+    f1 := commitAll(t, "f1")
+    err = switchToBranch(DEFAULT_BRANCH)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return base, m1, f1
+}
+
+func TestRebase(t *testing.T) {
+    tests := []struct {
+        name string
+        args []string
+        // what feature ends up on, "" for untouched
+        parent string
+        head   string
+        ok     bool
+    }{
+        {"up to date", []string{ "base", "feature" }, "", "feature", true},
+        {"HEAD is where we started", []string{ "HEAD", "feature" }, "m1", "feature", true},
+        {"onto main", []string{ "--onto", DEFAULT_BRANCH, "base", "feature" }, "m1", "feature", true},
+        {"bad upstream", []string{ "nosuch", "feature" }, "", DEFAULT_BRANCH, false},
+        {"bad onto", []string{ "--onto", "nosuch", "base", "feature" }, "", DEFAULT_BRANCH, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            base, m1, f1 := forkedRepo(t)
+            named := map[string]string{ "base": base, "m1": m1 }
+            for i, arg := range tt.args {
+                if hash, ok := named[arg]; ok {
+                    tt.args[i] = hash
+                }
+            }
+            err := Rebase(tt.args)
+            if (err == nil) != tt.ok {
+                t.Fatalf("rebase %v = %v, want ok %v", tt.args, err, tt.ok)
+            }
+            target, err := headTarget()
+            if err != nil || target != BRANCHES_PREFIX + tt.head {
+                t.Fatalf("HEAD is on %q, want %s", target, tt.head)
+            }
+            feature, err := resolveRef(BRANCHES_PREFIX + "feature")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tt.parent == "" {
+                if feature != f1 {
+                    t.Fatalf("feature moved to %s", feature)
+                }
+                return
+            }
+            c, err := deserializeCommit(feature)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(c.Parents) != 1 || c.Parents[0] != named[tt.parent] {
+                t.Fatalf("feature sits on %v, want %s", c.Parents, named[tt.parent])
+            }
+        })
+    }
+}
+
+func TestRebaseStoppedKeepsLocalEdits(t *testing.T) {
+    tests := []struct {
+        follow string
+        // what base holds and whether feature still ends at f2 afterwards
+        base  string
+        moved bool
+    }{
+        {"--skip", "main\n", true},
+        {"--abort", "feature\n", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.follow, func(t *testing.T) {
+            testRepo(t)
+            writeTestFile(t, "base", "base\n")
+            writeTestFile(t, "other", "base\n")
+            commitAll(t, "base")
+            err := Branch([]string{ "feature" })
+            if err != nil {
+                t.Fatal(err)
+            }
+            writeTestFile(t, "base", "main\n")
+            m1 := commitAll(t, "m1")
+            err = switchToBranch("feature")
+            if err != nil {
+                t.Fatal(err)
+            }
+            writeTestFile(t, "base", "feature\n")
+            commitAll(t, "f1")
+            writeTestFile(t, "extra", "extra\n")
+            f2 := commitAll(t, "f2")
+            writeTestFile(t, "other", "precious local edit\n")
+
+            err = Rebase([]string{ DEFAULT_BRANCH })
+            if !errors.Is(err, ErrConflict) {
+                t.Fatalf("rebase = %v, want a conflict on f1", err)
+            }
+            err = Rebase([]string{ tt.follow })
+            if err != nil {
+                t.Fatalf("rebase %s: %v", tt.follow, err)
+            }
+
+            feature, err := resolveRef(BRANCHES_PREFIX + "feature")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if moved := feature != f2; moved != tt.moved {
+                t.Fatalf("feature moved %v, want %v", moved, tt.moved)
+            }
+            if tt.moved {
+                if n := commitsSince(t, m1); n != 1 {
+                    t.Fatalf("%d commits on top of m1, want f2 alone", n)
+                }
+            }
+            if got := readTestFile(t, "base"); got != tt.base {
+                t.Fatalf("base holds %q, want %q", got, tt.base)
+            }
+            if got := readTestFile(t, "other"); got != "precious local edit\n" {
+                t.Fatalf("the local edit to other became %q", got)
+            }
+        })
+    }
+}
+
+func TestRebaseSquashNeedsOwnCommit(t *testing.T) {
+    tests := []struct {
+        name string
+        todo string
+        ok   bool
+    }{
+        {"fixup after a drop", "drop f1\nfixup dup\n", false},
+        {"squash after a drop", "drop dup\nsquash f1\n", false},
+        // dup is already in main, so the pick makes no commit to fold into
+        {"squash after an applied pick", "pick dup\nsquash f1\n", true},
+        {"fixup after an applied pick", "pick dup\nfixup f1\n", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, m1, f1 := forkedRepo(t)
+            err := switchToBranch("feature")
+            if err != nil {
+                t.Fatal(err)
+            }
+            writeTestFile(t, "main", "main\n")
+            dup := commitAll(t, "dup")
+            todo := strings.NewReplacer("f1", f1, "dup", dup).Replace(tt.todo)
+            file := filepath.Join(t.TempDir(), "todo")
+            err = os.WriteFile(file, []byte(todo), 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            err = Rebase([]string{ "--todo", file, DEFAULT_BRANCH })
+            if (err == nil) != tt.ok {
+                t.Fatalf("rebase = %v, want ok %v", err, tt.ok)
+            }
+            main, err := resolveRef(BRANCHES_PREFIX + DEFAULT_BRANCH)
+            if err != nil || main != m1 {
+                t.Fatalf("main moved to %s, %v", main, err)
+            }
+            feature, err := resolveRef(BRANCHES_PREFIX + "feature")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !tt.ok {
+                if feature != dup || sequencerActive() {
+                    t.Fatal("a refused todo still started the rebase")
+                }
+                return
+            }
+            // f1 comes out as a commit of its own on m1, never folded into m1
+            c, err := deserializeCommit(feature)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(c.Parents) != 1 || c.Parents[0] != m1 || firstLine(c.Message) != "f1" {
+                t.Fatalf("feature is %q on %v, want f1 on m1", firstLine(c.Message), c.Parents)
+            }
+        })
+    }
+}
+
+func TestSwitchToBranchReflog(t *testing.T) {
+    _, m1, f1 := forkedRepo(t)
+    feature := BRANCHES_PREFIX + "feature"
+    before, _ := readReflog(feature)
+    err := switchToBranch("feature")
+    if err != nil {
+        t.Fatal(err)
+    }
+    entries, err := readReflog(HEAD_REF)
+    if err != nil || len(entries) == 0 {
+        t.Fatalf("reflog of HEAD = %v, %v", entries, err)
+    }
+    last := entries[len(entries)-1]
+    if last.Reason != REASON_CHECKOUT || last.Old != m1 || last.New != f1 {
+        t.Errorf("HEAD last logged %s from %s to %s, want %s from %s to %s", last.Reason, last.Old, last.New, REASON_CHECKOUT, m1, f1)
+    }
+    if got, err := resolveRevision("HEAD@{1}"); err != nil || got != m1 {
+        t.Errorf("HEAD@{1} = %s, %v, want %s", got, err, m1)
+    }
+    // the branch itself did not move, so its log is untouched
+    if after, _ := readReflog(feature); len(after) != len(before) {
+        t.Errorf("checking out feature logged %d entries on it", len(after) - len(before))
+    }
+}
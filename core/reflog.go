@@ -0,0 +1,361 @@
+package core
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+////////////
+// REFLOG //
+////////////
+
+// Every ref update appends a line to .goverse/logs/<ref>, so the value a ref
+// had before a bad commit, reset or merge can always be found again
+
+const LOGS_DIR = GOVERSE_DIR + "logs/"
+
+// hash recorded as Old when a ref is created
+var ZERO_HASH = strings.Repeat("0", 40)
+
+// Reasons recorded with each reflog entry
+const (
+    REASON_INIT        = "init"
+    REASON_COMMIT      = "commit"
+    REASON_BRANCH      = "branch"
+    REASON_CHECKOUT    = "checkout"
+    REASON_MERGE       = "merge"
+    REASON_RESET       = "reset"
+    REASON_STASH       = "stash"
+    REASON_CHERRY_PICK = "cherry-pick"
+    REASON_REVERT      = "revert"
+    REASON_REBASE      = "rebase"
+    REASON_FETCH       = "fetch"
+    REASON_PUSH        = "push"
+    REASON_CLONE       = "clone"
+    REASON_PULL        = "pull"
+    REASON_FAST_IMPORT = "fast-import"
+    REASON_AM          = "am"
+)
+
+// Default expiry for "reflog expire", entries no longer reachable from the
+// ref's tip go sooner than the rest
+const (
+    REFLOG_EXPIRE             = 90 * 24 * time.Hour
+    REFLOG_EXPIRE_UNREACHABLE = 30 * 24 * time.Hour
+)
+
+func reflogPath(ref string) (string) {
+    return BaseDir + LOGS_DIR + ref
+}
+
+// appendReflog records ref moving from old to new, old may be "" for a new ref
+func appendReflog(ref string, old string, new string, reason string, message string) (error) {
+    if old == "" {
+        old = ZERO_HASH
+    }
+    entry := models.ReflogEntry {
+        Old: old,
+        New: new,
+        Identity: getIdentity(),
+        Timestamp: time.Now().Format(time.RFC3339),
+        Reason: reason,
+        Message: message,
+    }
+    line, err := json.Marshal(entry)
+    if err != nil {
+        return fmt.Errorf("Unable to serialize reflog entry for %s: %w", ref, err)
+    }
+
+    path := reflogPath(ref)
+    err = os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return &PathError{"create dir", filepath.Dir(path), err}
+    }
+    file, err := os.OpenFile(path, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644)
+    if err != nil {
+        return &PathError{"open reflog", path, err}
+    }
+    defer file.Close()
+    _, err = file.Write(append(line, '\n'))
+    if err != nil {
+        return &PathError{"append reflog", path, err}
+    }
+    return nil
+}
+
+// readReflog returns the entries of ref's reflog, oldest first
+func readReflog(ref string) ([]models.ReflogEntry, error) {
+    path := reflogPath(ref)
+    file, err := os.Open(path)
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil, nil
+        }
+        return nil, &PathError{"open reflog", path, err}
+    }
+    defer file.Close()
+
+    entries := []models.ReflogEntry{}
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        if strings.TrimSpace(scanner.Text()) == "" {
+            continue
+        }
+        var entry models.ReflogEntry
+        err := json.Unmarshal(scanner.Bytes(), &entry)
+        if err != nil {
+            return nil, &PathError{"parse reflog", path, fmt.Errorf("%v: %w", err, ErrCorruptObject)}
+        }
+        entries = append(entries, entry)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, &PathError{"read reflog", path, err}
+    }
+    return entries, nil
+}
+
+func writeReflog(ref string, entries []models.ReflogEntry) (error) {
+    content := []byte{}
+    for _, entry := range entries {
+        line, err := json.Marshal(entry)
+        if err != nil {
+            return fmt.Errorf("Unable to serialize reflog entry for %s: %w", ref, err)
+        }
+        content = append(content, line...)
+        content = append(content, '\n')
+    }
+    path := reflogPath(ref)
+    err := os.WriteFile(path + ".tmp", content, 0644)
+    if err != nil {
+        return &PathError{"write reflog", path, err}
+    }
+    err = os.Rename(path + ".tmp", path)
+    if err != nil {
+        return &PathError{"write reflog", path, err}
+    }
+    return nil
+}
+
+func deleteReflog(ref string) (error) {
+    err := os.Remove(reflogPath(ref))
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return &PathError{"delete reflog", reflogPath(ref), err}
+    }
+    return nil
+}
+
+// listReflogs returns every ref that has a reflog
+func listReflogs() ([]string, error) {
+    refs := []string{}
+    root := BaseDir + LOGS_DIR
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if errors.Is(err, fs.ErrNotExist) {
+                return nil
+            }
+            return err
+        }
+        if !d.IsDir() && !strings.HasSuffix(path, ".tmp") {
+            rel, err := filepath.Rel(root, path)
+            if err != nil {
+                return err
+            }
+            refs = append(refs, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, &PathError{"list reflogs", root, err}
+    }
+    return refs, nil
+}
+
+// reflogEntry returns the value ref had n updates ago
+func reflogEntry(ref string, n int) (string, error) {
+    entries, err := readReflog(ref)
+    if err != nil {
+        return "", err
+    }
+    if len(entries) == 0 && n == 0 {
+        return resolveRef(ref)
+    }
+    if n >= len(entries) {
+        return "", fmt.Errorf("Reflog of %s has only %d entries: %w", displayRef(ref), len(entries), ErrObjectNotFound)
+    }
+    hash := entries[len(entries)-1-n].New
+    if hash == ZERO_HASH {
+        return "", fmt.Errorf("%s did not exist at %s@{%d}: %w", displayRef(ref), displayRef(ref), n, ErrObjectNotFound)
+    }
+    return hash, nil
+}
+
+// displayRef is how a ref is written in reflog output, HEAD for head
+func displayRef(ref string) (string) {
+    if ref == HEAD_REF {
+        return "HEAD"
+    }
+    return shortRef(ref)
+}
+
+// parseExpiry reads "90d", "12h", "30m", "now" or "never", never is 0
+func parseExpiry(value string) (time.Duration, error) {
+    switch value {
+    case "never":
+        return 0, nil
+    case "now", "all":
+        return time.Nanosecond, nil
+    }
+    if days, found := strings.CutSuffix(value, "d"); found {
+        n, err := strconv.Atoi(days)
+        if err != nil || n < 0 {
+            return 0, fmt.Errorf("\"%s\" is not a valid expiry", value)
+        }
+        return time.Duration(n) * 24 * time.Hour, nil
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("\"%s\" is not a valid expiry", value)
+    }
+    return d, nil
+}
+
+// expireReflog drops entries older than expire, and entries older than
+// expireUnreachable whose commit is no longer reachable from the ref,
+// a zero duration keeps everything it would have applied to
+func expireReflog(ref string, expire time.Duration, expireUnreachable time.Duration) (int, error) {
+    // hold the ref so no update lands between reading and rewriting its log
+    path, err := refPath(ref)
+    if err != nil {
+        return 0, err
+    }
+    lock, err := acquireLock(path, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return 0, err
+    }
+    defer lock.rollback()
+
+    entries, err := readReflog(ref)
+    if err != nil {
+        return 0, err
+    }
+    reachable := map[string]bool{}
+    if tip, err := resolveRef(ref); err == nil && expireUnreachable > 0 {
+        reachable, err = ancestors(tip)
+        if err != nil {
+            return 0, err
+        }
+    }
+
+    now := time.Now()
+    kept := []models.ReflogEntry{}
+    for _, entry := range entries {
+        stamp, err := time.Parse(time.RFC3339, entry.Timestamp)
+        if err != nil {
+            kept = append(kept, entry)
+            continue
+        }
+        age := now.Sub(stamp)
+        if expire > 0 && age >= expire {
+            continue
+        }
+        if expireUnreachable > 0 && age >= expireUnreachable && !reachable[entry.New] {
+            continue
+        }
+        kept = append(kept, entry)
+    }
+    pruned := len(entries) - len(kept)
+    if pruned == 0 {
+        return 0, nil
+    }
+    return pruned, writeReflog(ref, kept)
+}
+
+// Reflog shows a ref's reflog (HEAD by default) newest first, or with
+// "expire [--expire=<age>] [--expire-unreachable=<age>] [--all | <ref>...]"
+// prunes old entries
+func Reflog(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(args) > 0 && args[0] == "expire" {
+        return reflogExpire(args[1:])
+    }
+    if len(args) > 0 && args[0] == "show" {
+        args = args[1:]
+    }
+
+    name := "HEAD"
+    if len(args) > 0 {
+        name = args[0]
+    }
+    ref, err := resolveRefName(name)
+    if err != nil {
+        return err
+    }
+    entries, err := readReflog(ref)
+    if err != nil {
+        return err
+    }
+    for i := len(entries) - 1; i >= 0; i-- {
+        entry := entries[i]
+        fmt.Printf("%s %s@{%d}: %s: %s\n", truncHash(entry.New), displayRef(ref), len(entries)-1-i, entry.Reason, entry.Message)
+    }
+    return nil
+}
+
+func reflogExpire(args []string) (error) {
+    expire := REFLOG_EXPIRE
+    expireUnreachable := REFLOG_EXPIRE_UNREACHABLE
+    refs := []string{}
+    all := false
+    for _, arg := range args {
+        var err error
+        switch {
+        case strings.HasPrefix(arg, "--expire="):
+            expire, err = parseExpiry(strings.TrimPrefix(arg, "--expire="))
+        case strings.HasPrefix(arg, "--expire-unreachable="):
+            expireUnreachable, err = parseExpiry(strings.TrimPrefix(arg, "--expire-unreachable="))
+        case arg == "--all":
+            all = true
+        default:
+            var ref string
+            ref, err = resolveRefName(arg)
+            refs = append(refs, ref)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    if all {
+        var err error
+        refs, err = listReflogs()
+        if err != nil {
+            return err
+        }
+    }
+    if len(refs) == 0 {
+        refs = []string{HEAD_REF}
+    }
+
+    for _, ref := range refs {
+        pruned, err := expireReflog(ref, expire, expireUnreachable)
+        if err != nil {
+            return err
+        }
+        if pruned > 0 {
+            fmt.Printf("%s: pruned %d entries\n", displayRef(ref), pruned)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+    "time"
+
+    "goverse/internal/models"
+)
+
+// reflogReasons lists the reasons in ref's reflog, oldest first
+func reflogReasons(t *testing.T, ref string) ([]string) {
+    t.Helper()
+    entries, err := readReflog(ref)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reasons := []string{}
+    for _, entry := range entries {
+        reasons = append(reasons, entry.Reason)
+    }
+    return reasons
+}
+
+func TestReflog(t *testing.T) {
+    testRepo(t)
+    root, _ := getHead()
+    writeTestFile(t, "file", "one\n")
+    c1 := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    c2 := commitAll(t, "two")
+    err := Branch([]string{ "topic", c1 })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    main := BRANCHES_PREFIX + DEFAULT_BRANCH
+    logs := []struct {
+        ref  string
+        want []string
+    }{
+        {HEAD_REF, []string{ REASON_INIT, REASON_COMMIT, REASON_COMMIT }},
+        {main, []string{ REASON_INIT, REASON_COMMIT, REASON_COMMIT }},
+        {BRANCHES_PREFIX + "topic", []string{ REASON_BRANCH }},
+    }
+    for _, tt := range logs {
+        if got := reflogReasons(t, tt.ref); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("reflog of %s = %v, want %v", tt.ref, got, tt.want)
+        }
+    }
+    entries, _ := readReflog(main)
+    if last := entries[len(entries)-1]; last.Old != c1 || last.New != c2 {
+        t.Errorf("last entry of main moves %s to %s, want %s to %s", last.Old, last.New, c1, c2)
+    }
+    entries, _ = readReflog(BRANCHES_PREFIX + "topic")
+    if entries[0].Old != ZERO_HASH || entries[0].New != c1 {
+        t.Errorf("topic was created as %s to %s, want %s to %s", entries[0].Old, entries[0].New, ZERO_HASH, c1)
+    }
+
+    revs := []struct {
+        rev  string
+        want string
+    }{
+        {"HEAD@{0}", c2},
+        {"HEAD@{1}", c1},
+        {"HEAD@{2}", root},
+        {DEFAULT_BRANCH + "@{1}", c1},
+        {"topic@{0}", c1},
+        {"HEAD@{1}~1", root},
+    }
+    for _, tt := range revs {
+        got, err := resolveRevision(tt.rev)
+        if err != nil || got != tt.want {
+            t.Errorf("%s = %s, %v, want %s", tt.rev, got, err, tt.want)
+        }
+    }
+    if _, err := resolveRevision("HEAD@{3}"); !errors.Is(err, ErrObjectNotFound) {
+        t.Errorf("HEAD@{3} = %v, want it past the end of the reflog", err)
+    }
+}
+
+func TestReflogExpire(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    kept := commitAll(t, "one")
+    // a commit only the reflog still knows about
+    lost := testCommit(t, "lost")
+    ago := func(days int) (string) {
+        return time.Now().Add(-time.Duration(days) * 24 * time.Hour).Format(time.RFC3339)
+    }
+    entry := func(hash string, days int) (models.ReflogEntry) {
+        return models.ReflogEntry{ Old: ZERO_HASH, New: hash, Timestamp: ago(days), Reason: REASON_COMMIT, Message: hash }
+    }
+
+    tests := []struct {
+        name string
+        args []string
+        want []models.ReflogEntry
+    }{
+        // 90 days for every entry, 30 for unreachable ones
+        {"defaults", nil, []models.ReflogEntry{ entry(kept, 40), entry(lost, 10), entry(kept, 0) }},
+        {"expire", []string{ "--expire=20d" }, []models.ReflogEntry{ entry(lost, 10), entry(kept, 0) }},
+        {"unreachable now", []string{ "--expire=never", "--expire-unreachable=now" }, []models.ReflogEntry{ entry(kept, 100), entry(kept, 40), entry(kept, 0) }},
+        {"never", []string{ "--expire=never", "--expire-unreachable=never" }, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            all := []models.ReflogEntry{ entry(kept, 100), entry(lost, 100), entry(kept, 40), entry(lost, 40), entry(lost, 10), entry(kept, 0) }
+            err := writeReflog(HEAD_REF, all)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = Reflog(append([]string{ "expire" }, tt.args...))
+            if err != nil {
+                t.Fatal(err)
+            }
+            want := tt.want
+            if want == nil {
+                want = all
+            }
+            got, err := readReflog(HEAD_REF)
+            if err != nil || !reflect.DeepEqual(got, want) {
+                t.Errorf("reflog after expire %v =\n%v, %v\nwant\n%v", tt.args, got, err, want)
+            }
+        })
+    }
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+//////////
+// REFS //
+//////////
+
+// Refs are files under .goverse/ named by their path relative to it,
+// e.g. "head", "branches/main" or "tags/v1.0"
+const (
+    HEAD_REF        = "head"
+    BRANCHES_PREFIX = "branches/"
+    TAGS_PREFIX     = "tags/"
+    SYMREF_PREFIX   = "ref: "
+    DEFAULT_BRANCH  = "main"
+    STASH_REF       = "stash"
+)
+
+// refPath is the file ref lives in, refusing names that would put it
+// anywhere but below .goverse/
+func refPath(ref string) (string, error) {
+    if err := checkPath(ref); err != nil {
+        return "", fmt.Errorf("Ref \"%s\" is outside %s: %w", ref, GOVERSE_DIR, ErrUnsafePath)
+    }
+    return BaseDir + GOVERSE_DIR + ref, nil
+}
+
+// readRef returns the raw value of a ref, a hash or "ref: <target>"
+func readRef(ref string) (string, error) {
+    path := BaseDir + GOVERSE_DIR + ref
+    bytes, err := os.ReadFile(path)
+    if err != nil {
+        return "", &PathError{"read ref", ref, err}
+    }
+    return strings.TrimSpace(string(bytes)), nil
+}
+
+// resolveRef reads a ref and follows it when it is symbolic
+func resolveRef(ref string) (string, error) {
+    value, err := readRef(ref)
+    if err != nil {
+        return "", err
+    }
+    if strings.HasPrefix(value, SYMREF_PREFIX) {
+        return readRef(strings.TrimPrefix(value, SYMREF_PREFIX))
+    }
+    return value, nil
+}
+
+// writeRef sets a ref without touching its reflog, for symbolic refs and tags
+func writeRef(ref string, value string) (error) {
+    return swapRef(ref, value, "", nil)
+}
+
+// updateRef points ref at hash and records the move in its reflog. The
+// update only happens while ref still holds expectedOld: "" skips the
+// check and ZERO_HASH insists the ref does not exist yet
+func updateRef(ref string, hash string, expectedOld string, reason string, message string) (error) {
+    return swapRef(ref, hash, expectedOld, func(old string) error {
+        return appendReflog(ref, old, hash, reason, message)
+    })
+}
+
+// swapRef replaces ref with value under ref.lock, checking expectedOld and
+// calling beforeCommit with the old hash while the lock is still held
+func swapRef(ref string, value string, expectedOld string, beforeCommit func(old string) error) (error) {
+    path, err := refPath(ref)
+    if err != nil {
+        return err
+    }
+    err = os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return &PathError{"create dir", filepath.Dir(path), err}
+    }
+    lock, err := acquireLock(path, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return err
+    }
+
+    old, err := resolveRef(ref)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        lock.rollback()
+        return err
+    }
+    err = checkExpected(ref, old, expectedOld)
+    if err != nil {
+        lock.rollback()
+        return err
+    }
+    if beforeCommit != nil {
+        err = beforeCommit(old)
+        if err != nil {
+            lock.rollback()
+            return err
+        }
+    }
+    return lock.commit([]byte(value + "\n"))
+}
+
+func checkExpected(ref string, old string, expectedOld string) (error) {
+    switch {
+    case expectedOld == "":
+        return nil
+    case expectedOld == ZERO_HASH && old != "":
+        return fmt.Errorf("%s already exists: %w", ref, ErrRefChanged)
+    case expectedOld != ZERO_HASH && old != expectedOld:
+        if old == "" {
+            return fmt.Errorf("%s no longer exists: %w", ref, ErrRefChanged)
+        }
+        return fmt.Errorf("%s is at %s, expected %s: %w", ref, truncHash(old), truncHash(expectedOld), ErrRefChanged)
+    }
+    return nil
+}
+
+// deleteRef removes a ref along with its reflog
+func deleteRef(ref string) (error) {
+    path, err := refPath(ref)
+    if err != nil {
+        return err
+    }
+    lock, err := acquireLock(path, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return err
+    }
+    defer lock.rollback()
+    err = os.Remove(path)
+    if err != nil {
+        return &PathError{"delete ref", ref, err}
+    }
+    return deleteReflog(ref)
+}
+
+func refExists(ref string) (bool) {
+    info, err := os.Stat(BaseDir + GOVERSE_DIR + ref)
+    return err == nil && !info.IsDir()
+}
+
+// listRefs returns every ref below prefix ("branches/", "tags/"), sorted
+func listRefs(prefix string) ([]string, error) {
+    refs := []string{}
+    root := BaseDir + GOVERSE_DIR + prefix
+    err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            if errors.Is(err, fs.ErrNotExist) {
+                return nil
+            }
+            return err
+        }
+        if !d.IsDir() && !strings.HasSuffix(path, LOCK_SUFFIX) {
+            rel, err := filepath.Rel(BaseDir + GOVERSE_DIR, path)
+            if err != nil {
+                return err
+            }
+            refs = append(refs, filepath.ToSlash(rel))
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, &PathError{"list refs", root, err}
+    }
+    sort.Strings(refs)
+    return refs, nil
+}
+
+// checkRefName rejects names that could not be typed back into a revision
+func checkRefName(name string) (error) {
+    invalid := name == "" || name == "HEAD" || name == "@" ||
+        strings.HasPrefix(name, "-") || strings.HasPrefix(name, "/") ||
+        strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") ||
+        strings.HasSuffix(name, LOCK_SUFFIX) ||
+        strings.Contains(name, "..") || strings.Contains(name, "//") ||
+        strings.Contains(name, "@{") || strings.ContainsAny(name, " ~^:?*[\\\t\n")
+    if invalid {
+        return fmt.Errorf("\"%s\" is not a valid ref name", name)
+    }
+    return nil
+}
+
+//////////
+// HEAD //
+//////////
+
+// headTarget returns the ref HEAD points at, or "" when HEAD is detached
+func headTarget() (string, error) {
+    value, err := readRef(HEAD_REF)
+    if err != nil {
+        if rerr := requireRepository(); rerr != nil {
+            return "", rerr
+        }
+        return "", err
+    }
+    if strings.HasPrefix(value, SYMREF_PREFIX) {
+        return strings.TrimPrefix(value, SYMREF_PREFIX), nil
+    }
+    return "", nil
+}
+
+// getHead returns the commit HEAD resolves to, or "" on an unborn branch
+func getHead() (string, error) {
+    target, err := headTarget()
+    if err != nil {
+        return "", err
+    }
+    if target == "" {
+        return readRef(HEAD_REF)
+    }
+    hash, err := readRef(target)
+    if errors.Is(err, fs.ErrNotExist) {
+        return "", nil
+    }
+    return hash, err
+}
+
+// setHead moves the current branch to hash, or HEAD itself when detached,
+// HEAD's reflog records the move either way. expectedOld is as for updateRef
+func setHead(hash string, expectedOld string, reason string, message string) (error) {
+    target, err := headTarget()
+    if err != nil {
+        return err
+    }
+    if target == "" {
+        return updateRef(HEAD_REF, hash, expectedOld, reason, message)
+    }
+    return swapRef(target, hash, expectedOld, func(old string) error {
+        err := appendReflog(target, old, hash, reason, message)
+        if err != nil {
+            return err
+        }
+        return appendReflog(HEAD_REF, old, hash, reason, message)
+    })
+}
+
+// setHeadRef attaches HEAD to a branch ref such as "branches/main"
+func setHeadRef(ref string) (error) {
+    return writeRef(HEAD_REF, SYMREF_PREFIX + ref)
+}
+
+// currentBranch is the short name of the checked out branch, "" when detached
+func currentBranch() (string, error) {
+    target, err := headTarget()
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimPrefix(target, BRANCHES_PREFIX), nil
+}
+
+// shortRef strips the namespace from a ref for display
+func shortRef(ref string) (string) {
+    for _, prefix := range []string{BRANCHES_PREFIX, TAGS_PREFIX, REMOTES_PREFIX} {
+        if strings.HasPrefix(ref, prefix) {
+            return strings.TrimPrefix(ref, prefix)
+        }
+    }
+    return ref
+}
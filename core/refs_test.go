@@ -0,0 +1,137 @@
+package core
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestCheckRefName(t *testing.T) {
+    tests := []struct {
+        name string
+        ok   bool
+    }{
+        {"main", true},
+        {"feature/x", true},
+        {"v1.0", true},
+        {"", false},
+        {"HEAD", false},
+        {"@", false},
+        {"-main", false},
+        {"/main", false},
+        {"main/", false},
+        {"main.", false},
+        {"main.lock", false},
+        {"a..b", false},
+        {"a//b", false},
+        {"main@{1}", false},
+        {"has space", false},
+        {"tilde~1", false},
+        {"caret^", false},
+        {"colon:x", false},
+        {"star*", false},
+    }
+    for _, tt := range tests {
+        if err := checkRefName(tt.name); (err == nil) != tt.ok {
+            t.Errorf("checkRefName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+        }
+    }
+}
+
+func TestUpdateRefChecksOldValue(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    c1 := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    c2 := commitAll(t, "two")
+    ref := BRANCHES_PREFIX + "topic"
+
+    tests := []struct {
+        name     string
+        hash     string
+        expected string
+        err      error
+        after    string
+    }{
+        {"create", c1, ZERO_HASH, nil, c1},
+        {"create again", c2, ZERO_HASH, ErrRefChanged, c1},
+        {"stale old value", c2, c2, ErrRefChanged, c1},
+        {"right old value", c2, c1, nil, c2},
+        {"unchecked", c1, "", nil, c1},
+    }
+    // the steps build on each other, in order
+    for _, tt := range tests {
+        err := updateRef(ref, tt.hash, tt.expected, REASON_BRANCH, tt.name)
+        if (tt.err == nil) != (err == nil) || (tt.err != nil && !errors.Is(err, tt.err)) {
+            t.Fatalf("%s: updateRef = %v, want %v", tt.name, err, tt.err)
+        }
+        got, err := resolveRef(ref)
+        if err != nil || got != tt.after {
+            t.Fatalf("%s: %s is at %s, %v, want %s", tt.name, ref, got, err, tt.after)
+        }
+        if refExists(ref + LOCK_SUFFIX) {
+            t.Fatalf("%s: the lock was left behind", tt.name)
+        }
+    }
+    entries, err := readReflog(ref)
+    if err != nil || len(entries) != 3 {
+        t.Fatalf("reflog has %d entries, %v, want one per update", len(entries), err)
+    }
+}
+
+func TestUpdateRefWaitsForLock(t *testing.T) {
+    testRepo(t)
+    head, err := getHead()
+    if err != nil {
+        t.Fatal(err)
+    }
+    lock, err := acquireLock(BaseDir + GOVERSE_DIR + BRANCHES_PREFIX + "held", REF_LOCK_TIMEOUT)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer lock.rollback()
+    err = updateRef(BRANCHES_PREFIX + "held", head, "", REASON_BRANCH, "held")
+    if !errors.Is(err, ErrLocked) {
+        t.Fatalf("updateRef under someone else's lock = %v, want ErrLocked", err)
+    }
+}
+
+func TestDeleteChecksRefName(t *testing.T) {
+    tests := []struct {
+        name string
+        ok   bool
+    }{
+        {"old", true},
+        {"../" + HEAD_REF, false},
+        {"../../outside_gv", false},
+        {"-d", false},
+    }
+    for _, tt := range tests {
+        for _, del := range []func([]string) error{ Branch, Tag } {
+            dir := testRepo(t)
+            writeTestFile(t, "file", "content\n")
+            commitAll(t, "file")
+            err := Branch([]string{ "old" })
+            if err == nil {
+                err = Tag([]string{ "old" })
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = os.WriteFile(filepath.Join(filepath.Dir(filepath.Clean(dir)), "outside_gv"), nil, 0644)
+            if err != nil {
+                t.Fatal(err)
+            }
+            err = del([]string{ "-d", tt.name })
+            if (err == nil) != tt.ok {
+                t.Errorf("deleting %q = %v, want ok %v", tt.name, err, tt.ok)
+            }
+            for _, kept := range []string{ dir + GOVERSE_DIR + HEAD_REF, filepath.Join(filepath.Dir(filepath.Clean(dir)), "outside_gv") } {
+                if _, err := os.Stat(kept); err != nil {
+                    t.Errorf("deleting %q removed %s", tt.name, kept)
+                }
+            }
+        }
+    }
+}
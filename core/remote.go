@@ -0,0 +1,873 @@
+package core
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+/////////////
+// REMOTES //
+/////////////
+
+// A remote is another repository named in config as "remote.<name>.url".
+// Fetch copies its branches to remotes/<name>/<branch> and its tags to
+// tags/, push sends local branches to it. Objects travel as a pack stream
+
+const (
+    REMOTES_PREFIX = "remotes/"
+    DEFAULT_REMOTE = "origin"
+    FILE_SCHEME    = "file://"
+)
+
+// refUpdate asks a remote to move ref from old to new, old is "" when the
+// ref should not exist yet
+type refUpdate struct {
+    ref   string
+    old   string
+    new   string
+    force bool
+}
+
+// transport is a connection to a remote repository
+type transport interface {
+    // advertise lists the remote's refs and their hashes, HEAD_REF as it is
+    // stored ("ref: branches/main" or a hash)
+    advertise() (map[string]string, error)
+    // fetchPack stores the objects reachable from wants and not from haves
+    // in this repository, returning what was received. depth above zero
+    // stops history that many commits below each want
+    fetchPack(wants []string, haves []string, depth int) ([]string, error)
+    // sendPack hands the remote hashes and the updates to make with them,
+    // returning why each update was rejected, "" when it was made
+    sendPack(updates []refUpdate, hashes []string) ([]string, error)
+}
+
+// remoteURL looks up where the remote called name lives
+func remoteURL(name string) (string, error) {
+    url := getConfig("remote." + name + ".url", "")
+    if url == "" {
+        return "", fmt.Errorf("No remote named \"%s\": %w", name, ErrObjectNotFound)
+    }
+    return url, nil
+}
+
+// openTransport connects to the repository at url
+func openTransport(url string) (transport, error) {
+    if isHTTP(url) {
+        return &httpTransport{strings.TrimRight(url, "/")}, nil
+    }
+    if isSSH(url) {
+        return newSSHTransport(url)
+    }
+    dir := strings.TrimPrefix(url, FILE_SCHEME)
+    if strings.Contains(dir, "://") {
+        return nil, fmt.Errorf("Unsupported remote url \"%s\"", url)
+    }
+    if isBundle(dir) {
+        return &bundleTransport{dir}, nil
+    }
+    if !strings.HasSuffix(dir, "/") {
+        dir += "/"
+    }
+    info, err := os.Stat(dir + GOVERSE_DIR)
+    if err != nil || !info.IsDir() {
+        return nil, &PathError{"open remote", url, ErrNotARepository}
+    }
+    return &fileTransport{dir}, nil
+}
+
+// normalizeURL keeps urls as they are and turns a local path into an
+// absolute file:// url, so it works from anywhere. A relative path is
+// taken from BaseDir like every other path a command is given
+func normalizeURL(url string) (string, error) {
+    if strings.Contains(url, "://") || isSSH(url) {
+        return url, nil
+    }
+    if !filepath.IsAbs(url) {
+        url = filepath.Join(BaseDir, url)
+    }
+    abs, err := filepath.Abs(url)
+    if err != nil {
+        return "", &PathError{"resolve", url, err}
+    }
+    return FILE_SCHEME + abs, nil
+}
+
+// remoteNames lists every configured remote
+func remoteNames() ([]string, error) {
+    config, err := readConfig()
+    if err != nil {
+        return nil, err
+    }
+    names := []string{}
+    for key := range config {
+        if strings.HasPrefix(key, "remote.") && strings.HasSuffix(key, ".url") {
+            names = append(names, strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url"))
+        }
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+// Remote lists remotes ("-v" with their urls), "add <name> <url>" names a
+// new one and "remove <name>" forgets one along with its tracking refs
+func Remote(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(args) == 0 || args[0] == "-v" {
+        names, err := remoteNames()
+        if err != nil {
+            return err
+        }
+        for _, name := range names {
+            if len(args) == 0 {
+                fmt.Println(name)
+            } else {
+                fmt.Println(name + "\t" + getConfig("remote." + name + ".url", ""))
+            }
+        }
+        return nil
+    }
+    switch {
+    case args[0] == "add" && len(args) == 3:
+        name, url := args[1], args[2]
+        err := checkRefName(name)
+        if err != nil {
+            return err
+        }
+        if strings.Contains(name, "/") || strings.Contains(name, ".") {
+            return fmt.Errorf("\"%s\" is not a valid remote name", name)
+        }
+        if _, err := remoteURL(name); err == nil {
+            return fmt.Errorf("Remote \"%s\" already exists", name)
+        }
+        url, err = normalizeURL(url)
+        if err != nil {
+            return err
+        }
+        return setConfig("remote." + name + ".url", url)
+    case (args[0] == "remove" || args[0] == "rm") && len(args) == 2:
+        name := args[1]
+        if _, err := remoteURL(name); err != nil {
+            return err
+        }
+        unlock, err := lockRepository()
+        if err != nil {
+            return err
+        }
+        defer unlock()
+        refs, err := listRefs(REMOTES_PREFIX + name + "/")
+        if err != nil {
+            return err
+        }
+        for _, ref := range refs {
+            err := deleteRef(ref)
+            if err != nil {
+                return err
+            }
+        }
+        return setConfig("remote." + name + ".url", "")
+    }
+    return errors.New("Remote takes -v, \"add <name> <url>\" or \"remove <name>\"")
+}
+
+////////////
+// SERVER //
+////////////
+
+// the side of a transfer that runs inside the remote repository
+
+// advertiseRefs lists HEAD, branches and tags with their stored values
+func advertiseRefs() (map[string]string, error) {
+    refs := map[string]string{}
+    head, err := readRef(HEAD_REF)
+    if err != nil {
+        return nil, err
+    }
+    refs[HEAD_REF] = head
+    for _, prefix := range []string{ BRANCHES_PREFIX, TAGS_PREFIX } {
+        found, err := listRefs(prefix)
+        if err != nil {
+            return nil, err
+        }
+        for _, ref := range found {
+            hash, err := readRef(ref)
+            if err != nil {
+                return nil, err
+            }
+            refs[ref] = hash
+        }
+    }
+    return refs, nil
+}
+
+// objectsBetween lists what is reachable from wants but not from haves,
+// haves this repository does not know are ignored
+func objectsBetween(wants []string, haves []string) ([]string, error) {
+    seen := map[string]bool{}
+    for _, have := range haves {
+        if objectExists(have) {
+            err := markReachable(have, seen)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    before := make(map[string]bool, len(seen))
+    for hash := range seen {
+        before[hash] = true
+    }
+    for _, want := range wants {
+        err := markReachable(want, seen)
+        if err != nil {
+            return nil, err
+        }
+    }
+    hashes := []string{}
+    for hash := range seen {
+        if !before[hash] {
+            hashes = append(hashes, hash)
+        }
+    }
+    sort.Strings(hashes)
+    return hashes, nil
+}
+
+// shallowObjects is objectsBetween keeping only the first depth commits
+// of history below each want
+func shallowObjects(wants []string, haves []string, depth int) ([]string, error) {
+    seen := map[string]bool{}
+    for _, have := range haves {
+        if objectExists(have) {
+            err := markReachable(have, seen)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    before := make(map[string]bool, len(seen))
+    for hash := range seen {
+        before[hash] = true
+    }
+    // breadth first, so a commit is reached at its lowest depth
+    type pending struct {
+        hash  string
+        depth int
+    }
+    queue := []pending{}
+    for _, want := range wants {
+        queue = append(queue, pending{want, 1})
+    }
+    for len(queue) > 0 {
+        next := queue[0]
+        queue = queue[1:]
+        if seen[next.hash] {
+            continue
+        }
+        kind, _, err := readObject(next.hash)
+        if err != nil {
+            return nil, err
+        }
+        switch kind {
+        case COMMIT:
+            seen[next.hash] = true
+            c, err := deserializeCommit(next.hash)
+            if err != nil {
+                return nil, err
+            }
+            err = markReachable(c.Tree, seen)
+            if err != nil {
+                return nil, err
+            }
+            if next.depth < depth {
+                for _, parent := range c.Parents {
+                    queue = append(queue, pending{parent, next.depth + 1})
+                }
+            }
+        case TAG:
+            seen[next.hash] = true
+            t, err := deserializeTag(next.hash)
+            if err != nil {
+                return nil, err
+            }
+            queue = append(queue, pending{t.Commit, next.depth})
+        default:
+            err := markReachable(next.hash, seen)
+            if err != nil {
+                return nil, err
+            }
+        }
+    }
+    hashes := []string{}
+    for hash := range seen {
+        if !before[hash] {
+            hashes = append(hashes, hash)
+        }
+    }
+    sort.Strings(hashes)
+    return hashes, nil
+}
+
+// packObjects lists what a fetch of wants by a client with haves gets
+func packObjects(wants []string, haves []string, depth int) ([]string, error) {
+    if depth > 0 {
+        return shallowObjects(wants, haves, depth)
+    }
+    return objectsBetween(wants, haves)
+}
+
+// uploadPack writes the pack a fetch asked for to w
+func uploadPack(w io.Writer, wants []string, haves []string, depth int) (error) {
+    hashes, err := packObjects(wants, haves, depth)
+    if err != nil {
+        return err
+    }
+    return writePack(w, hashes)
+}
+
+// receivePack stores a pushed pack and makes the updates it came with,
+// returning why each one was rejected, "" when it was made
+func receivePack(r io.Reader, updates []refUpdate) ([]string, error) {
+    unlock, err := lockRepository()
+    if err != nil {
+        return nil, err
+    }
+    defer unlock()
+    _, err = readPack(r)
+    if err != nil {
+        return nil, err
+    }
+    target, err := headTarget()
+    if err != nil {
+        return nil, err
+    }
+    results := make([]string, len(updates))
+    for i, u := range updates {
+        results[i] = checkUpdate(u, target)
+        if results[i] != "" {
+            continue
+        }
+        expected := u.old
+        if u.force {
+            expected = ""
+        } else if expected == "" {
+            expected = ZERO_HASH
+        }
+        err := updateRef(u.ref, u.new, expected, REASON_PUSH, "push")
+        if errors.Is(err, ErrRefChanged) {
+            results[i] = "fetch first"
+        } else if err != nil {
+            return nil, err
+        }
+    }
+    return results, nil
+}
+
+// checkUpdate says why a pushed update may not be made, "" if it may
+func checkUpdate(u refUpdate, checkedOut string) (string) {
+    if !strings.HasPrefix(u.ref, BRANCHES_PREFIX) && !strings.HasPrefix(u.ref, TAGS_PREFIX) {
+        return "not a branch or tag"
+    }
+    if checkRefName(shortRef(u.ref)) != nil {
+        return "invalid ref name"
+    }
+    if !objectExists(u.new) {
+        return "missing objects"
+    }
+    // the work tree of the remote would no longer match its branch
+    if u.ref == checkedOut && getConfig("receive.denyCurrentBranch", "refuse") != "ignore" {
+        return "branch is checked out"
+    }
+    if u.force {
+        return ""
+    }
+    current, err := readRef(u.ref)
+    if err != nil {
+        current = ""
+    }
+    if current == "" {
+        return ""
+    }
+    if current != u.old {
+        return "fetch first"
+    }
+    if strings.HasPrefix(u.ref, TAGS_PREFIX) {
+        return "already exists"
+    }
+    if !isAncestor(current, u.new) {
+        return "non-fast-forward"
+    }
+    return ""
+}
+
+////////////////////
+// FILE TRANSPORT //
+////////////////////
+
+// fileTransport reaches a repository on this machine by switching BaseDir
+// over to it for the length of each call
+type fileTransport struct {
+    dir string
+}
+
+// inRepository runs fn with dir as the current repository, the pack index
+// and lock depth belong to a repository so they are swapped along with it
+func inRepository(dir string, fn func() error) (error) {
+    base, packs, depth := BaseDir, packIndex, repoLockDepth
+    BaseDir, packIndex, repoLockDepth = dir, nil, 0
+    defer func() {
+        BaseDir, packIndex, repoLockDepth = base, packs, depth
+    }()
+    return fn()
+}
+
+func (t *fileTransport) advertise() (map[string]string, error) {
+    var refs map[string]string
+    err := inRepository(t.dir, func() (error) {
+        var err error
+        refs, err = advertiseRefs()
+        return err
+    })
+    return refs, err
+}
+
+func (t *fileTransport) fetchPack(wants []string, haves []string, depth int) ([]string, error) {
+    var pack bytes.Buffer
+    err := inRepository(t.dir, func() (error) {
+        return uploadPack(&pack, wants, haves, depth)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return readPack(&pack)
+}
+
+func (t *fileTransport) sendPack(updates []refUpdate, hashes []string) ([]string, error) {
+    var pack bytes.Buffer
+    err := writePack(&pack, hashes)
+    if err != nil {
+        return nil, err
+    }
+    var results []string
+    err = inRepository(t.dir, func() (error) {
+        var err error
+        results, err = receivePack(&pack, updates)
+        return err
+    })
+    return results, err
+}
+
+///////////
+// FETCH //
+///////////
+
+// Fetch copies the branches of a remote (origin by default) to
+// remotes/<remote>/ along with any tags it has that this repository does
+// not. Naming branches after the remote, or setting them as
+// "remote.<name>.branches", fetches only those and no tags. "--prune"
+// drops tracking refs for branches the remote no longer has
+func Fetch(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    prune := false
+    positional := []string{}
+    for _, arg := range args {
+        switch {
+        case arg == "--prune" || arg == "-p":
+            prune = true
+        case strings.HasPrefix(arg, "-"):
+            return fmt.Errorf("Unknown fetch option \"%s\"", arg)
+        default:
+            positional = append(positional, arg)
+        }
+    }
+    name := DEFAULT_REMOTE
+    if len(positional) > 0 {
+        name, positional = positional[0], positional[1:]
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+    return fetchRemote(name, positional, prune, 0)
+}
+
+// fetchRemote does a fetch under the repository lock, the configured
+// branches or every branch when branches is empty, see uploadPack for depth
+func fetchRemote(name string, branches []string, prune bool, depth int) (error) {
+    url, err := remoteURL(name)
+    if err != nil {
+        return err
+    }
+    if len(branches) == 0 {
+        branches = strings.Fields(getConfig("remote." + name + ".branches", ""))
+    }
+    t, err := openTransport(url)
+    if err != nil {
+        return err
+    }
+    refs, err := t.advertise()
+    if err != nil {
+        return err
+    }
+
+    wanted := map[string]string{}
+    for _, branch := range branches {
+        hash, ok := refs[BRANCHES_PREFIX + branch]
+        if !ok {
+            return fmt.Errorf("Remote \"%s\" has no branch \"%s\": %w", name, branch, ErrObjectNotFound)
+        }
+        wanted[BRANCHES_PREFIX + branch] = hash
+    }
+    for ref, hash := range refs {
+        if len(branches) == 0 && (strings.HasPrefix(ref, BRANCHES_PREFIX) || strings.HasPrefix(ref, TAGS_PREFIX)) {
+            wanted[ref] = hash
+        }
+    }
+    wants := []string{}
+    for _, hash := range wanted {
+        if !objectExists(hash) {
+            wants = append(wants, hash)
+        }
+    }
+    if len(wants) > 0 {
+        haves, err := localTips()
+        if err != nil {
+            return err
+        }
+        received, err := t.fetchPack(wants, haves, depth)
+        if err != nil {
+            return err
+        }
+        err = markShallow(received)
+        if err != nil {
+            return err
+        }
+    }
+    // a ref is only moved once what it points at is here
+    for ref, hash := range wanted {
+        if !objectExists(hash) {
+            return fmt.Errorf("Remote did not send %s for %s: %w", truncHash(hash), ref, ErrObjectNotFound)
+        }
+    }
+
+    fmt.Println("From " + url)
+    names := []string{}
+    for ref := range wanted {
+        names = append(names, ref)
+    }
+    sort.Strings(names)
+    for _, ref := range names {
+        if strings.HasPrefix(ref, TAGS_PREFIX) {
+            err = fetchTag(ref, wanted[ref])
+        } else {
+            err = fetchBranch(name, ref, wanted[ref], url)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    if !prune {
+        return nil
+    }
+    tracking, err := listRefs(REMOTES_PREFIX + name + "/")
+    if err != nil {
+        return err
+    }
+    for _, ref := range tracking {
+        branch := strings.TrimPrefix(ref, REMOTES_PREFIX + name + "/")
+        if _, ok := refs[BRANCHES_PREFIX + branch]; ok {
+            continue
+        }
+        err := deleteRef(ref)
+        if err != nil {
+            return err
+        }
+        fmt.Printf(" - [deleted]         %-10s -> %s\n", "(none)", shortRef(ref))
+    }
+    return nil
+}
+
+// localTips lists the hashes every local ref points at, the starting
+// points a remote can leave out of a pack
+func localTips() ([]string, error) {
+    refs, err := allRefs()
+    if err != nil {
+        return nil, err
+    }
+    tips := []string{}
+    for _, ref := range refs {
+        hash, err := resolveRef(ref)
+        if err == nil && hash != "" {
+            tips = append(tips, hash)
+        }
+    }
+    return tips, nil
+}
+
+// fetchBranch moves the tracking ref for a remote branch, tracking refs
+// follow the remote even when it rewrote history
+func fetchBranch(remote string, ref string, hash string, url string) (error) {
+    branch := shortRef(ref)
+    tracking := REMOTES_PREFIX + remote + "/" + branch
+    old, err := resolveRef(tracking)
+    if err != nil {
+        old = ""
+    }
+    if old == hash {
+        return nil
+    }
+    err = updateRef(tracking, hash, "", REASON_FETCH, "fetch from " + url)
+    if err != nil {
+        return err
+    }
+    switch {
+    case old == "":
+        fmt.Printf(" * [new branch]      %-10s -> %s\n", branch, shortRef(tracking))
+    case isAncestor(old, hash):
+        fmt.Printf("   %s..%s  %-10s -> %s\n", truncHash(old), truncHash(hash), branch, shortRef(tracking))
+    default:
+        fmt.Printf(" + %s...%s %-10s -> %s (forced update)\n", truncHash(old), truncHash(hash), branch, shortRef(tracking))
+    }
+    return nil
+}
+
+// fetchTag creates a tag the remote has, one already here is never moved
+func fetchTag(ref string, hash string) (error) {
+    old, err := readRef(ref)
+    if err == nil && old != "" {
+        if old != hash {
+            fmt.Printf(" ! [rejected]        %-10s -> %s (would clobber existing tag)\n", shortRef(ref), shortRef(ref))
+        }
+        return nil
+    }
+    err = writeRef(ref, hash)
+    if err != nil {
+        return err
+    }
+    fmt.Printf(" * [new tag]         %-10s -> %s\n", shortRef(ref), shortRef(ref))
+    return nil
+}
+
+//////////
+// PUSH //
+//////////
+
+// Push sends "<src>[:<dst>]" to a remote (origin by default), src is a
+// local branch or revision and dst the remote branch, both default to the
+// checked out branch. "--tags" sends every tag too. An update that would
+// lose commits on the remote is rejected unless "--force" is given.
+// "--set-upstream" makes each pushed branch follow its remote branch
+func Push(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    force, tags, track := false, false, false
+    positional := []string{}
+    for _, arg := range args {
+        switch {
+        case arg == "--force" || arg == "-f":
+            force = true
+        case arg == "--tags":
+            tags = true
+        case arg == "--set-upstream" || arg == "-u":
+            track = true
+        case strings.HasPrefix(arg, "-"):
+            return fmt.Errorf("Unknown push option \"%s\"", arg)
+        default:
+            positional = append(positional, arg)
+        }
+    }
+    name := DEFAULT_REMOTE
+    if len(positional) > 0 {
+        name, positional = positional[0], positional[1:]
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    url, err := remoteURL(name)
+    if err != nil {
+        return err
+    }
+    if len(positional) == 0 && !tags {
+        branch, err := currentBranch()
+        if err != nil {
+            return err
+        }
+        if branch == "" {
+            return errors.New("HEAD is detached, name what to push as <src>:<dst>")
+        }
+        positional = []string{ branch }
+    }
+    updates := []refUpdate{}
+    for _, spec := range positional {
+        u, err := pushUpdate(spec, force)
+        if err != nil {
+            return err
+        }
+        updates = append(updates, u)
+    }
+    if tags {
+        found, err := listRefs(TAGS_PREFIX)
+        if err != nil {
+            return err
+        }
+        for _, ref := range found {
+            hash, err := readRef(ref)
+            if err != nil {
+                return err
+            }
+            updates = append(updates, refUpdate{ref, "", hash, force})
+        }
+    }
+
+    t, err := openTransport(url)
+    if err != nil {
+        return err
+    }
+    refs, err := t.advertise()
+    if err != nil {
+        return err
+    }
+    err = pushUpdates(t, name, url, refs, updates)
+    if err != nil || !track {
+        return err
+    }
+    for _, spec := range positional {
+        src, dst, _ := strings.Cut(spec, ":")
+        if !refExists(BRANCHES_PREFIX + src) {
+            continue
+        }
+        if dst == "" {
+            dst = src
+        }
+        err = setUpstream(src, name, dst)
+        if err != nil {
+            return err
+        }
+        fmt.Printf("branch '%s' set up to track '%s/%s'.\n", src, name, dst)
+    }
+    return nil
+}
+
+// pushUpdate turns "<src>[:<dst>]" into the update it asks for
+func pushUpdate(spec string, force bool) (refUpdate, error) {
+    src, dst, _ := strings.Cut(spec, ":")
+    if src == "" {
+        return refUpdate{}, fmt.Errorf("\"%s\" names nothing to push", spec)
+    }
+    if dst == "" {
+        if !refExists(BRANCHES_PREFIX + src) {
+            return refUpdate{}, fmt.Errorf("\"%s\" is not a branch, name the remote branch as %s:<dst>", src, src)
+        }
+        dst = src
+    }
+    hash, err := resolveCommit(src)
+    if err != nil {
+        return refUpdate{}, err
+    }
+    err = checkRefName(dst)
+    if err != nil {
+        return refUpdate{}, err
+    }
+    return refUpdate{BRANCHES_PREFIX + dst, "", hash, force}, nil
+}
+
+// pushUpdates sends what the remote is missing and reports each update
+func pushUpdates(t transport, name string, url string, refs map[string]string, updates []refUpdate) (error) {
+    fmt.Println("To " + url)
+    pending := []refUpdate{}
+    rejected, behind := false, false
+    for _, u := range updates {
+        u.old = refs[u.ref]
+        label := fmt.Sprintf("%s -> %s", shortRef(u.ref), shortRef(u.ref))
+        switch {
+        case u.old == u.new:
+            if !strings.HasPrefix(u.ref, TAGS_PREFIX) {
+                fmt.Printf(" = [up to date]      %s\n", label)
+            }
+            continue
+        case u.old != "" && !u.force && strings.HasPrefix(u.ref, TAGS_PREFIX):
+            fmt.Printf(" ! [rejected]        %s (already exists)\n", label)
+            rejected = true
+            continue
+        case u.old != "" && !u.force && !objectExists(u.old):
+            fmt.Printf(" ! [rejected]        %s (fetch first)\n", label)
+            rejected, behind = true, true
+            continue
+        case u.old != "" && !u.force && !isAncestor(u.old, u.new):
+            fmt.Printf(" ! [rejected]        %s (non-fast-forward)\n", label)
+            rejected, behind = true, true
+            continue
+        }
+        pending = append(pending, u)
+    }
+
+    if len(pending) > 0 {
+        wants, haves := []string{}, []string{}
+        for _, u := range pending {
+            wants = append(wants, u.new)
+        }
+        for _, hash := range refs {
+            if isHex(hash) && objectExists(hash) {
+                haves = append(haves, hash)
+            }
+        }
+        hashes, err := objectsBetween(wants, haves)
+        if err != nil {
+            return err
+        }
+        results, err := t.sendPack(pending, hashes)
+        if err != nil {
+            return err
+        }
+        for i, u := range pending {
+            label := fmt.Sprintf("%s -> %s", shortRef(u.ref), shortRef(u.ref))
+            if results[i] != "" {
+                fmt.Printf(" ! [remote rejected] %s (%s)\n", label, results[i])
+                rejected = true
+                behind = behind || results[i] == "fetch first" || results[i] == "non-fast-forward"
+                continue
+            }
+            switch {
+            case u.old == "" && strings.HasPrefix(u.ref, TAGS_PREFIX):
+                fmt.Printf(" * [new tag]         %s\n", label)
+            case u.old == "":
+                fmt.Printf(" * [new branch]      %s\n", label)
+            case isAncestor(u.old, u.new):
+                fmt.Printf("   %s..%s  %s\n", truncHash(u.old), truncHash(u.new), label)
+            default:
+                fmt.Printf(" + %s...%s %s (forced update)\n", truncHash(u.old), truncHash(u.new), label)
+            }
+            if strings.HasPrefix(u.ref, BRANCHES_PREFIX) {
+                tracking := REMOTES_PREFIX + name + "/" + shortRef(u.ref)
+                err := updateRef(tracking, u.new, "", REASON_PUSH, "push to " + url)
+                if err != nil {
+                    return err
+                }
+            }
+        }
+    }
+    if behind {
+        fmt.Println("hint: the remote has commits you do not, fetch them first or push with --force")
+    }
+    if rejected {
+        return fmt.Errorf("Failed to push some refs to %s: %w", url, ErrRejected)
+    }
+    return nil
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+    "errors"
+    "testing"
+)
+
+// remoteRepo makes an upstream repository with one commit on main, and a
+// second repository, left current, with it as origin and main checked out
+// from it. Pushing to the upstream's checked out main is allowed
+func remoteRepo(t *testing.T) (string, string) {
+    t.Helper()
+    upstream := testRepo(t)
+    writeTestFile(t, "file", "base\n")
+    base := commitAll(t, "base")
+    err := setConfig("receive.denyCurrentBranch", "ignore")
+    if err != nil {
+        t.Fatal(err)
+    }
+    testRepo(t)
+    err = Remote([]string{ "add", DEFAULT_REMOTE, upstream })
+    if err == nil {
+        err = Fetch(nil)
+    }
+    if err == nil {
+        err = Reset([]string{ "--hard", REMOTES_PREFIX + DEFAULT_REMOTE + "/" + DEFAULT_BRANCH })
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return upstream, base
+}
+
+// commitIn commits content as "file" in the repository at dir
+func commitIn(t *testing.T, dir string, content string) (string) {
+    t.Helper()
+    var hash string
+    err := inRepository(dir, func() (error) {
+        writeTestFile(t, "file", content)
+        hash = commitAll(t, content)
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return hash
+}
+
+// refIn reads ref in the repository at dir
+func refIn(t *testing.T, dir string, ref string) (string) {
+    t.Helper()
+    var hash string
+    err := inRepository(dir, func() (error) {
+        var err error
+        hash, err = resolveRef(ref)
+        return err
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return hash
+}
+
+func TestFetch(t *testing.T) {
+    upstream, base := remoteRepo(t)
+    tracking := REMOTES_PREFIX + DEFAULT_REMOTE + "/" + DEFAULT_BRANCH
+    if got, _ := resolveRef(tracking); got != base {
+        t.Fatalf("%s is %s, want %s", tracking, got, base)
+    }
+    next := commitIn(t, upstream, "next\n")
+    err := inRepository(upstream, func() (error) {
+        return Branch([]string{ "topic" })
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = Fetch(nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ref := range []string{ tracking, REMOTES_PREFIX + DEFAULT_REMOTE + "/topic" } {
+        if got, err := resolveRef(ref); err != nil || got != next {
+            t.Errorf("%s is %s, %v, want %s", ref, got, err, next)
+        }
+    }
+    // fetching leaves the local branch where it was
+    if head, _ := getHead(); head != base {
+        t.Errorf("fetch moved HEAD to %s", head)
+    }
+}
+
+// movingTransport moves a ref on the remote between advertising it and
+// receiving the push, like another push landing in between
+type movingTransport struct {
+    fileTransport
+    ref string
+    to  string
+}
+
+func (m *movingTransport) sendPack(updates []refUpdate, hashes []string) ([]string, error) {
+    err := inRepository(m.dir, func() (error) {
+        return writeRef(m.ref, m.to)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return m.fileTransport.sendPack(updates, hashes)
+}
+
+func TestPush(t *testing.T) {
+    main := BRANCHES_PREFIX + DEFAULT_BRANCH
+    tests := []struct {
+        name string
+        // the remote gets a commit of its own before the push, or during it
+        ahead bool
+        race  bool
+        err   error
+    }{
+        {"fast-forward", false, false, nil},
+        {"non-fast-forward", true, false, ErrRejected},
+        {"remote moved during push", false, true, ErrRejected},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            upstream, base := remoteRepo(t)
+            theirs := base
+            if tt.ahead || tt.race {
+                theirs = commitIn(t, upstream, "theirs\n")
+            }
+            if tt.race {
+                // the push sees base, the remote is at theirs when it lands
+                err := inRepository(upstream, func() (error) {
+                    return writeRef(main, base)
+                })
+                if err != nil {
+                    t.Fatal(err)
+                }
+            }
+            writeTestFile(t, "other", "ours\n")
+            ours := commitAll(t, "ours")
+
+            var err error
+            if tt.race {
+                url, _ := remoteURL(DEFAULT_REMOTE)
+                mover := &movingTransport{ fileTransport{ upstream }, main, theirs }
+                refs, err := mover.advertise()
+                if err != nil {
+                    t.Fatal(err)
+                }
+                err = pushUpdates(mover, DEFAULT_REMOTE, url, refs, []refUpdate{ {main, "", ours, false} })
+                if !errors.Is(err, tt.err) {
+                    t.Fatalf("push = %v, want %v", err, tt.err)
+                }
+            } else {
+                err = Push(nil)
+                if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+                    t.Fatalf("push = %v, want %v", err, tt.err)
+                }
+            }
+
+            want := ours
+            if tt.err != nil {
+                want = theirs
+            }
+            if got := refIn(t, upstream, main); got != want {
+                t.Fatalf("remote main is %s, want %s", got, want)
+            }
+            tracking, _ := resolveRef(REMOTES_PREFIX + DEFAULT_REMOTE + "/" + DEFAULT_BRANCH)
+            if tt.err == nil && tracking != ours || tt.err != nil && tracking == ours {
+                t.Fatalf("tracking ref is %s after push %v", tracking, tt.err)
+            }
+        })
+    }
+}
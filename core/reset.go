@@ -0,0 +1,214 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// RESET //
+///////////
+
+// Reset modes, each does everything the one before it does
+const (
+    RESET_SOFT  = "soft"   // move the branch
+    RESET_MIXED = "mixed"  // and make the index match it
+    RESET_HARD  = "hard"   // and make the work tree match it
+)
+
+// RESET_MERGE is a hard reset that only rewrites the files the index and
+// the target differ on, what "--abort" needs to undo a stopped sequence
+// without losing local edits to files it never touched
+const RESET_MERGE = "merge"
+
+// Reset moves the current branch, or HEAD when detached, to a revision:
+// "reset [--soft|--mixed|--hard] [<rev>]". Given paths,
+// "reset [<rev>] [--] <path>..." instead unstages them back to what rev holds
+func Reset(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    mode, explicitMode := RESET_MIXED, false
+    positional, paths := []string{}, []string{}
+    dashes := false
+    for _, arg := range args {
+        switch {
+        case dashes:
+            paths = append(paths, arg)
+        case arg == "--":
+            dashes = true
+        case arg == "--soft" || arg == "--mixed" || arg == "--hard":
+            mode, explicitMode = strings.TrimPrefix(arg, "--"), true
+        case strings.HasPrefix(arg, "-"):
+            return fmt.Errorf("Unknown reset option \"%s\"", arg)
+        default:
+            positional = append(positional, arg)
+        }
+    }
+
+    // the first argument is a revision if it names one, otherwise a path
+    rev := "HEAD"
+    if len(positional) > 0 {
+        _, err := resolveCommit(positional[0])
+        switch {
+        case err == nil || dashes:
+            rev, positional = positional[0], positional[1:]
+        case explicitMode || !isTrackedOrPresent(positional[0]):
+            return err
+        }
+    }
+    paths = append(positional, paths...)
+
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if len(paths) > 0 {
+        if explicitMode {
+            return fmt.Errorf("Cannot do a --%s reset with paths", mode)
+        }
+        return resetPaths(rev, paths)
+    }
+    return resetHead(rev, mode)
+}
+
+// resetHead moves HEAD to rev and then syncs the index and work tree as mode asks
+func resetHead(rev string, mode string) (error) {
+    target, err := resolveCommit(rev)
+    if err != nil {
+        return err
+    }
+    c, err := deserializeCommit(target)
+    if err != nil {
+        return err
+    }
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    expectedHead := head
+    if head == "" {
+        expectedHead = ZERO_HASH
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+
+    err = setHead(target, expectedHead, REASON_RESET, "moving to " + rev)
+    if err != nil {
+        return err
+    }
+    if mode == RESET_SOFT {
+        return nil
+    }
+    newIdx, err := indexFromTree(c.Tree)
+    if err != nil {
+        return err
+    }
+    err = writeIndex(newIdx)
+    if err != nil {
+        return err
+    }
+    // the index knows every tracked file, including ones only staged
+    old, new := indexFiles(idx), indexFiles(newIdx)
+    switch mode {
+    case RESET_MERGE:
+        old, new = changedFiles(old, new)
+    case RESET_MIXED:
+        return nil
+    }
+    err = checkoutFiles(old, new, true)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("HEAD is now at %s %s\n", truncHash(target), firstLine(c.Message))
+    return nil
+}
+
+// resetPaths puts the index entries under paths back to what rev holds,
+// leaving HEAD and the work tree alone
+func resetPaths(rev string, paths []string) (error) {
+    files := map[string]models.IndexEntry{}
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    // on an unborn branch unstaging just empties the index
+    if head != "" || rev != "HEAD" {
+        target, err := resolveCommit(rev)
+        if err != nil {
+            return err
+        }
+        c, err := deserializeCommit(target)
+        if err != nil {
+            return err
+        }
+        files, err = treeFiles(c.Tree)
+        if err != nil {
+            return err
+        }
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+
+    for _, file := range paths {
+        rel, err := relPath(file)
+        if err != nil {
+            return err
+        }
+        under := func(p string) (bool) {
+            return rel == "" || p == rel || strings.HasPrefix(p, rel + "/")
+        }
+        matched := false
+        kept := []models.IndexEntry{}
+        for _, entry := range idx.Entries {
+            if under(entry.Path) {
+                matched = true
+            } else {
+                kept = append(kept, entry)
+            }
+        }
+        for p, entry := range files {
+            if under(p) {
+                matched = true
+                kept = append(kept, entry)
+            }
+        }
+        if !matched {
+            return errors.New("Path \"" + file + "\" is not in the index or " + rev)
+        }
+        idx.Entries = kept
+    }
+    return writeIndex(idx)
+}
+
+// isTrackedOrPresent reports whether file exists in the work tree or the index
+func isTrackedOrPresent(file string) (bool) {
+    rel, err := relPath(file)
+    if err != nil {
+        return false
+    }
+    if _, err := os.Lstat(BaseDir + rel); err == nil {
+        return true
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return false
+    }
+    for _, entry := range idx.Entries {
+        if entry.Path == rel || strings.HasPrefix(entry.Path, rel + "/") {
+            return true
+        }
+    }
+    return false
+}
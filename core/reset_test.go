@@ -0,0 +1,94 @@
+package core
+
+import (
+    "os"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestReset(t *testing.T) {
+    blob := func(content string) (string) {
+        hash, _ := hashBlob(models.Blob{ Content: []byte(content) })
+        return hash
+    }
+    tests := []struct {
+        name  string
+        args  []string
+        head  string
+        // what the index and the work tree hold for "file", "" for gone
+        index string
+        work  string
+        added bool
+    }{
+        {"soft", []string{ "--soft", "HEAD~1" }, "c1", "three\n", "three\n", true},
+        {"mixed", []string{ "HEAD~1" }, "c1", "one\n", "three\n", true},
+        {"hard", []string{ "--hard", "HEAD~1" }, "c1", "one\n", "one\n", false},
+        {"paths", []string{ "HEAD", "--", "file" }, "c2", "two\n", "three\n", true},
+        {"path without revision", []string{ "file" }, "c2", "two\n", "three\n", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            testRepo(t)
+            writeTestFile(t, "file", "one\n")
+            c1 := commitAll(t, "one")
+            writeTestFile(t, "file", "two\n")
+            writeTestFile(t, "added", "added\n")
+            c2 := commitAll(t, "two")
+            writeTestFile(t, "file", "three\n")
+            if err := Add("file"); err != nil {
+                t.Fatal(err)
+            }
+            commits := map[string]string{ "c1": c1, "c2": c2 }
+
+            err := Reset(tt.args)
+            if err != nil {
+                t.Fatalf("reset %v: %v", tt.args, err)
+            }
+            head, err := getHead()
+            if err != nil || head != commits[tt.head] {
+                t.Fatalf("HEAD = %s, %v, want %s", head, err, tt.head)
+            }
+            idx, err := readIndex()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := indexFiles(idx)["file"].Hash; got != blob(tt.index) {
+                t.Errorf("index has %s for file, want %q", got, tt.index)
+            }
+            content, err := os.ReadFile(BaseDir + "file")
+            if err != nil || string(content) != tt.work {
+                t.Errorf("work tree has %q, %v, want %q", content, err, tt.work)
+            }
+            if _, err := os.Stat(BaseDir + "added"); (err == nil) != tt.added {
+                t.Errorf("added exists %v, want %v", err == nil, tt.added)
+            }
+        })
+    }
+}
+
+func TestResetReflog(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    c1 := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    c2 := commitAll(t, "two")
+    err := Reset([]string{ "--hard", "HEAD~1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ref := range []string{ HEAD_REF, BRANCHES_PREFIX + DEFAULT_BRANCH } {
+        entries, err := readReflog(ref)
+        if err != nil || len(entries) == 0 {
+            t.Fatalf("reflog of %s = %v, %v", ref, entries, err)
+        }
+        last := entries[len(entries)-1]
+        if last.Reason != REASON_RESET || last.Old != c2 || last.New != c1 {
+            t.Errorf("%s last logged %s from %s to %s, want %s from %s to %s", ref, last.Reason, last.Old, last.New, REASON_RESET, c2, c1)
+        }
+    }
+    // the commit reset away from is still reachable through the reflog
+    if got, err := resolveRevision("HEAD@{1}"); err != nil || got != c2 {
+        t.Errorf("HEAD@{1} = %s, %v, want %s", got, err, c2)
+    }
+}
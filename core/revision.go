@@ -0,0 +1,487 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+///////////////
+// REVISIONS //
+///////////////
+
+// shortest hash prefix accepted as an abbreviation
+const MIN_ABBREV = 4
+
+// RevisionError records a revision expression that could not be resolved
+type RevisionError struct {
+    Rev string
+    Err error
+}
+
+func (e *RevisionError) Error() (string) {
+    return fmt.Sprintf("Unable to resolve revision \"%s\": %v", e.Rev, e.Err)
+}
+
+func (e *RevisionError) Unwrap() (error) {
+    return e.Err
+}
+
+// resolveRevision turns an expression such as "main~2", "v1.0^2", "a1b2c3",
+// "HEAD@{1}" or a full hash into the hash of the object it names,
+// "<rev>:<path>" names the tree or blob at path in rev
+func resolveRevision(rev string) (string, error) {
+    if colon := strings.Index(rev, ":"); colon >= 0 {
+        commit, err := resolveCommit(orHead(rev[:colon]))
+        if err != nil {
+            return "", err
+        }
+        c, err := deserializeCommit(commit)
+        if err != nil {
+            return "", err
+        }
+        hash, err := treeLookup(c.Tree, rev[colon+1:])
+        if err != nil {
+            return "", &RevisionError{rev, err}
+        }
+        return hash, nil
+    }
+
+    // the base runs up to the first ancestry operator
+    end := strings.IndexAny(rev, "~^")
+    if end < 0 {
+        end = len(rev)
+    }
+    hash, err := resolveBase(rev[:end])
+    if err != nil {
+        return "", err
+    }
+
+    suffix := rev[end:]
+    for suffix != "" {
+        op := suffix[0]
+        suffix = suffix[1:]
+        if op != '~' && op != '^' {
+            return "", &RevisionError{rev, fmt.Errorf("unexpected \"%c\"", op)}
+        }
+        digits := 0
+        for digits < len(suffix) && suffix[digits] >= '0' && suffix[digits] <= '9' {
+            digits++
+        }
+        n := 1
+        if digits > 0 {
+            n, err = strconv.Atoi(suffix[:digits])
+            if err != nil {
+                return "", &RevisionError{rev, err}
+            }
+        }
+        suffix = suffix[digits:]
+
+        hash, err = peelToCommit(hash)
+        if err != nil {
+            return "", &RevisionError{rev, err}
+        }
+        if op == '~' {
+            for i := 0; i < n; i++ {
+                hash, err = nthParent(hash, 1)
+                if err != nil {
+                    return "", &RevisionError{rev, err}
+                }
+            }
+        } else if n > 0 {
+            hash, err = nthParent(hash, n)
+            if err != nil {
+                return "", &RevisionError{rev, err}
+            }
+        }
+    }
+    return hash, nil
+}
+
+// resolveCommit is resolveRevision peeled down to a commit
+func resolveCommit(rev string) (string, error) {
+    hash, err := resolveRevision(rev)
+    if err != nil {
+        return "", err
+    }
+    hash, err = peelToCommit(hash)
+    if err != nil {
+        return "", &RevisionError{rev, err}
+    }
+    return hash, nil
+}
+
+// resolveRange expands one argument into commits to include and exclude:
+// "A..B" is B without A, "A...B" is A and B without their merge bases and
+// "^A" excludes A, an empty side of a range means HEAD
+func resolveRange(expr string) ([]string, []string, error) {
+    if a, b, found := strings.Cut(expr, "..."); found {
+        ha, err := resolveCommit(orHead(a))
+        if err != nil {
+            return nil, nil, err
+        }
+        hb, err := resolveCommit(orHead(b))
+        if err != nil {
+            return nil, nil, err
+        }
+        bases, err := mergeBases(ha, hb)
+        if err != nil {
+            return nil, nil, err
+        }
+        return []string{ha, hb}, bases, nil
+    }
+    if a, b, found := strings.Cut(expr, ".."); found {
+        ha, err := resolveCommit(orHead(a))
+        if err != nil {
+            return nil, nil, err
+        }
+        hb, err := resolveCommit(orHead(b))
+        if err != nil {
+            return nil, nil, err
+        }
+        return []string{hb}, []string{ha}, nil
+    }
+    if strings.HasPrefix(expr, "^") {
+        hash, err := resolveCommit(expr[1:])
+        if err != nil {
+            return nil, nil, err
+        }
+        return nil, []string{hash}, nil
+    }
+    hash, err := resolveCommit(expr)
+    if err != nil {
+        return nil, nil, err
+    }
+    return []string{hash}, nil, nil
+}
+
+func orHead(rev string) (string) {
+    if rev == "" {
+        return "HEAD"
+    }
+    return rev
+}
+
+// resolveBase resolves the part of an expression before any ~ or ^
+func resolveBase(base string) (string, error) {
+    if base == "" {
+        return "", &RevisionError{base, errors.New("empty revision")}
+    }
+
+    // <ref>@{n} is the nth previous value of ref
+    if at := strings.LastIndex(base, "@{"); at >= 0 && strings.HasSuffix(base, "}") {
+        n, err := strconv.Atoi(base[at+2 : len(base)-1])
+        if err != nil || n < 0 {
+            return "", &RevisionError{base, errors.New("only @{<n>} is supported")}
+        }
+        ref, err := resolveRefName(base[:at])
+        if err != nil {
+            return "", err
+        }
+        hash, err := reflogEntry(ref, n)
+        if err != nil {
+            return "", &RevisionError{base, err}
+        }
+        return hash, nil
+    }
+
+    if base == "HEAD" || base == HEAD_REF || base == "@" {
+        hash, err := getHead()
+        if err != nil {
+            return "", err
+        }
+        if hash == "" {
+            return "", &RevisionError{base, fmt.Errorf("HEAD has no commits yet: %w", ErrObjectNotFound)}
+        }
+        return hash, nil
+    }
+
+    ref, err := resolveRefName(base)
+    if err == nil {
+        return resolveRef(ref)
+    }
+    if !errors.Is(err, ErrObjectNotFound) {
+        return "", err
+    }
+
+    if len(base) >= MIN_ABBREV && isHex(base) {
+        matches, err := findObjects(base)
+        if err != nil {
+            return "", err
+        }
+        if len(matches) == 1 {
+            return matches[0], nil
+        }
+        if len(matches) > 1 {
+            return "", &RevisionError{base, fmt.Errorf("%w, could be %s", ErrAmbiguousRevision, strings.Join(matches, ", "))}
+        }
+    }
+    return "", &RevisionError{base, ErrObjectNotFound}
+}
+
+// treeLookup walks path down from tree, "" or "." is tree itself
+func treeLookup(tree string, path string) (string, error) {
+    hash := tree
+    for _, name := range strings.Split(path, "/") {
+        if name == "" || name == "." {
+            continue
+        }
+        kind, err := objectKind(hash)
+        if err != nil {
+            return "", err
+        }
+        if kind != TREE {
+            return "", fmt.Errorf("\"%s\" is not a directory: %w", path, ErrObjectNotFound)
+        }
+        // a tree that does not read is corrupt, not missing
+        t, err := deserializeTree(hash)
+        if err != nil {
+            return "", err
+        }
+        found := false
+        for _, entry := range t.Entries {
+            if entry.Name == name {
+                hash, found = entry.Hash, true
+                break
+            }
+        }
+        if !found {
+            return "", fmt.Errorf("\"%s\" is not in the tree: %w", path, ErrObjectNotFound)
+        }
+    }
+    return hash, nil
+}
+
+// resolveRefName maps a short name like "main" or "v1.0" to its ref,
+// an empty name or HEAD stands for the ref HEAD is attached to
+func resolveRefName(name string) (string, error) {
+    if name == "" || name == "HEAD" || name == "@" {
+        target, err := headTarget()
+        if err != nil {
+            return "", err
+        }
+        if target == "" || name != "" {
+            return HEAD_REF, nil
+        }
+        return target, nil
+    }
+    if name == HEAD_REF || name == STASH_REF {
+        return name, nil
+    }
+    if strings.HasPrefix(name, BRANCHES_PREFIX) || strings.HasPrefix(name, TAGS_PREFIX) || strings.HasPrefix(name, REMOTES_PREFIX) {
+        if refExists(name) {
+            return name, nil
+        }
+    }
+
+    candidates := []string{}
+    for _, prefix := range []string{TAGS_PREFIX, BRANCHES_PREFIX, REMOTES_PREFIX} {
+        if refExists(prefix + name) {
+            candidates = append(candidates, prefix + name)
+        }
+    }
+    if len(candidates) > 1 {
+        return "", &RevisionError{name, fmt.Errorf("%w, could be %s", ErrAmbiguousRevision, strings.Join(candidates, ", "))}
+    }
+    if len(candidates) == 0 {
+        return "", &RevisionError{name, ErrObjectNotFound}
+    }
+    return candidates[0], nil
+}
+
+// findObjects lists stored objects whose hash starts with prefix
+func findObjects(prefix string) ([]string, error) {
+    entries, err := os.ReadDir(BaseDir + OBJECTS_DIR)
+    if err != nil {
+        if errors.Is(err, fs.ErrNotExist) {
+            return nil, requireRepository()
+        }
+        return nil, &PathError{"read dir", BaseDir + OBJECTS_DIR, err}
+    }
+    matches := []string{}
+    for _, entry := range entries {
+        // temp files of an unfinished write are not objects yet
+        if !entry.IsDir() && isObjectHash(entry.Name()) && strings.HasPrefix(entry.Name(), prefix) {
+            matches = append(matches, entry.Name())
+        }
+    }
+    packed, err := packedObjects()
+    if err != nil {
+        return nil, err
+    }
+    for _, hash := range packed {
+        if strings.HasPrefix(hash, prefix) && !objectIsLoose(hash) {
+            matches = append(matches, hash)
+        }
+    }
+    return matches, nil
+}
+
+/////////////
+// HISTORY //
+/////////////
+
+// nthParent returns the nth parent of a commit, counting from 1
+func nthParent(hash string, n int) (string, error) {
+    c, err := deserializeCommit(hash)
+    if err != nil {
+        return "", err
+    }
+    if n > len(c.Parents) {
+        return "", fmt.Errorf("Commit %s has no parent %d: %w", truncHash(hash), n, ErrObjectNotFound)
+    }
+    return c.Parents[n-1], nil
+}
+
+// ancestors returns every commit reachable from hashes, themselves included
+func ancestors(hashes ...string) (map[string]bool, error) {
+    parents, err := ancestorParents(hashes...)
+    if err != nil {
+        return nil, err
+    }
+    seen := make(map[string]bool, len(parents))
+    for hash := range parents {
+        seen[hash] = true
+    }
+    return seen, nil
+}
+
+// isAncestor says whether commit a is b or in b's history
+func isAncestor(a string, b string) (bool) {
+    if a == b {
+        return true
+    }
+    seen, err := ancestors(b)
+    return err == nil && seen[a]
+}
+
+// ancestorParents maps every commit reachable from hashes to its parents
+func ancestorParents(hashes ...string) (map[string][]string, error) {
+    parents := map[string][]string{}
+    queue := append([]string{}, hashes...)
+    for len(queue) > 0 {
+        hash := queue[0]
+        queue = queue[1:]
+        if _, seen := parents[hash]; seen {
+            continue
+        }
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return nil, err
+        }
+        parents[hash] = c.Parents
+        queue = append(queue, c.Parents...)
+    }
+    return parents, nil
+}
+
+// mergeBases returns the best common ancestors of a and b, those no other
+// common ancestor descends from
+func mergeBases(a string, b string) ([]string, error) {
+    fromA, err := ancestors(a)
+    if err != nil {
+        return nil, err
+    }
+    fromB, err := ancestorParents(b)
+    if err != nil {
+        return nil, err
+    }
+    // everything below a common ancestor is common too, so one that is
+    // another's ancestor is the parent of some common commit
+    covered := map[string]bool{}
+    for hash, parents := range fromB {
+        if fromA[hash] {
+            for _, parent := range parents {
+                covered[parent] = true
+            }
+        }
+    }
+    bases := []string{}
+    for hash := range fromB {
+        if fromA[hash] && !covered[hash] {
+            bases = append(bases, hash)
+        }
+    }
+    sort.Strings(bases)
+    return bases, nil
+}
+
+// revList walks history from include, skipping anything reachable from
+// exclude, newest first and never listing a commit before its children
+func revList(include []string, exclude []string) ([]models.Commit, error) {
+    hidden, err := ancestors(exclude...)
+    if err != nil {
+        return nil, err
+    }
+    commits := map[string]models.Commit{}
+    order := []string{}
+    queue := append([]string{}, include...)
+    for len(queue) > 0 {
+        hash := queue[0]
+        queue = queue[1:]
+        if hidden[hash] {
+            continue
+        }
+        if _, ok := commits[hash]; ok {
+            continue
+        }
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return nil, err
+        }
+        commits[hash] = c
+        order = append(order, hash)
+        queue = append(queue, c.Parents...)
+    }
+
+    children := map[string]int{}
+    for _, hash := range order {
+        for _, parent := range commits[hash].Parents {
+            if _, ok := commits[parent]; ok {
+                children[parent]++
+            }
+        }
+    }
+    ready := []string{}
+    for _, hash := range order {
+        if children[hash] == 0 {
+            ready = append(ready, hash)
+        }
+    }
+
+    list := []models.Commit{}
+    for len(ready) > 0 {
+        // pick the newest commit whose children have all been listed
+        best := 0
+        for i := range ready {
+            if commitTime(commits[ready[i]]).After(commitTime(commits[ready[best]])) {
+                best = i
+            }
+        }
+        hash := ready[best]
+        ready = append(ready[:best], ready[best+1:]...)
+        list = append(list, commits[hash])
+        for _, parent := range commits[hash].Parents {
+            if _, ok := commits[parent]; !ok {
+                continue
+            }
+            children[parent]--
+            if children[parent] == 0 {
+                ready = append(ready, parent)
+            }
+        }
+    }
+    return list, nil
+}
+
+func commitTime(c models.Commit) (time.Time) {
+    t, _ := time.Parse(time.RFC3339, c.Timestamp)
+    return t
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "reflect"
+    "sort"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+// testCommit stores a commit of the empty tree on parents, message keeps
+// otherwise equal commits apart
+func testCommit(t *testing.T, message string, parents ...string) (string) {
+    t.Helper()
+    tree := storeRawTree(t)
+    hash, err := storeCommit(models.Commit{ Tree: tree, Parents: parents, Message: message, Author: "A <a@example.com>", Timestamp: "2024-01-01T00:00:00Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return hash
+}
+
+func TestMergeBases(t *testing.T) {
+    testRepo(t)
+    // root - x - a1 - a2          criss-cross: p - q1 - m1 (q1 + q2)
+    //          \                                \  /
+    //           b1 - b2                          \/
+    //                                            /\
+    //                                       p - q2 - m2 (q2 + q1)
+    root := testCommit(t, "root")
+    x := testCommit(t, "x", root)
+    a1 := testCommit(t, "a1", x)
+    a2 := testCommit(t, "a2", a1)
+    b1 := testCommit(t, "b1", x)
+    b2 := testCommit(t, "b2", b1)
+    other := testCommit(t, "unrelated root")
+    q1 := testCommit(t, "q1", root)
+    q2 := testCommit(t, "q2", root)
+    m1 := testCommit(t, "m1", q1, q2)
+    m2 := testCommit(t, "m2", q2, q1)
+    merged := testCommit(t, "merge", a2, b2)
+
+    tests := []struct {
+        name string
+        a    string
+        b    string
+        want []string
+    }{
+        {"same commit", a2, a2, []string{ a2 }},
+        {"ancestor", a2, x, []string{ x }},
+        {"fork", a2, b2, []string{ x }},
+        {"after a merge", merged, b2, []string{ b2 }},
+        {"unrelated", a2, other, []string{}},
+        {"criss-cross", m1, m2, []string{ q1, q2 }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := mergeBases(tt.a, tt.b)
+            if err != nil {
+                t.Fatal(err)
+            }
+            want := append([]string{}, tt.want...)
+            sort.Strings(want)
+            if !reflect.DeepEqual(got, want) {
+                t.Fatalf("mergeBases = %v, want %v", got, want)
+            }
+        })
+    }
+}
+
+// every commit of a long shared history is a common ancestor, which has
+// to stay one walk and not one per pair of them
+func TestMergeBasesLongHistory(t *testing.T) {
+    testRepo(t)
+    tip := testCommit(t, "0")
+    for i := 1; i < 2000; i++ {
+        tip = testCommit(t, fmt.Sprint(i), tip)
+    }
+    a := testCommit(t, "a", tip)
+    b := testCommit(t, "b", tip)
+    got, err := mergeBases(a, b)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(got) != 1 || got[0] != tip {
+        t.Fatalf("mergeBases = %v, want %s", got, tip)
+    }
+}
+
+func TestResolveRevision(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    c1 := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    c2 := commitAll(t, "two")
+    writeTestFile(t, "dir/file", "three\n")
+    c3 := commitAll(t, "three")
+    blob, _ := hashBlob(models.Blob{ Content: []byte("three\n") })
+    // left behind by a write that never finished, it must not make c3's
+    // prefix ambiguous
+    err := os.WriteFile(BaseDir + OBJECTS_DIR + c3 + ".tmp-1", nil, 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, ref := range []string{ TAGS_PREFIX + "v1", TAGS_PREFIX + "dup", BRANCHES_PREFIX + "dup" } {
+        if err := writeRef(ref, c2); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        rev  string
+        want string
+        err  error
+    }{
+        {"HEAD", c3, nil},
+        {"@", c3, nil},
+        {"HEAD~1", c2, nil},
+        {"HEAD~2", c1, nil},
+        {"HEAD^", c2, nil},
+        {"HEAD^^", c1, nil},
+        {"HEAD~1^", c1, nil},
+        {DEFAULT_BRANCH, c3, nil},
+        {BRANCHES_PREFIX + DEFAULT_BRANCH, c3, nil},
+        {"v1", c2, nil},
+        {"v1~1", c1, nil},
+        {c3[:MIN_ABBREV], c3, nil},
+        {c3, c3, nil},
+        {"HEAD@{1}", c2, nil},
+        {"HEAD:dir/file", blob, nil},
+        {"nosuch", "", ErrObjectNotFound},
+        {"HEAD^2", "", ErrObjectNotFound},
+        {"dup", "", ErrAmbiguousRevision},
+        {"HEAD:nosuch", "", ErrObjectNotFound},
+    }
+    for _, tt := range tests {
+        got, err := resolveRevision(tt.rev)
+        if tt.err != nil {
+            if !errors.Is(err, tt.err) {
+                t.Errorf("resolveRevision(%q) = %s, %v, want %v", tt.rev, got, err, tt.err)
+            }
+            continue
+        }
+        if err != nil || got != tt.want {
+            t.Errorf("resolveRevision(%q) = %s, %v, want %s", tt.rev, got, err, tt.want)
+        }
+    }
+}
+
+func TestResolveRange(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    c1 := commitAll(t, "one")
+    writeTestFile(t, "file", "two\n")
+    c2 := commitAll(t, "two")
+    tests := []struct {
+        expr    string
+        include []string
+        exclude []string
+    }{
+        {c1 + ".." + c2, []string{ c2 }, []string{ c1 }},
+        {c1 + "..", []string{ c2 }, []string{ c1 }},
+        {"^" + c1, nil, []string{ c1 }},
+        {c2, []string{ c2 }, nil},
+        {c1 + "..." + c2, []string{ c1, c2 }, []string{ c1 }},
+    }
+    for _, tt := range tests {
+        include, exclude, err := resolveRange(tt.expr)
+        if err != nil || !reflect.DeepEqual(include, tt.include) || !reflect.DeepEqual(exclude, tt.exclude) {
+            t.Errorf("resolveRange(%q) = %v, %v, %v, want %v, %v", tt.expr, include, exclude, err, tt.include, tt.exclude)
+        }
+    }
+}
@@ -0,0 +1,601 @@
+package core
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+
+    "goverse/internal/models"
+)
+
+///////////////
+// SEQUENCER //
+///////////////
+
+// Commands that replay commits one at a time keep their progress in
+// .goverse/sequencer/ so a conflict can stop them and "--continue" or
+// "--abort" can pick up where they left off:
+//
+//     command   the command that owns the sequence, e.g. "cherry-pick"
+//     head      HEAD before the sequence started, "--abort" returns to it
+//     todo      one "<action> <hash> <subject>" line per commit still to go,
+//               the first is the one that stopped
+//     onto      for a rebase, the commit the branch is replayed onto
+//     branch    for a rebase, the branch ref to move once done, empty when
+//               HEAD was detached
+
+const SEQUENCER_DIR = GOVERSE_DIR + "sequencer/"
+
+// Sequencer actions, squash and fixup fold a commit into the one before it
+// keeping both messages or only the first, reword takes the todo line's
+// subject as the new message and merge commits the commit as a second
+// parent with the subject as its message
+const (
+    ACTION_PICK   = "pick"
+    ACTION_REVERT = "revert"
+    ACTION_SQUASH = "squash"
+    ACTION_FIXUP  = "fixup"
+    ACTION_REWORD = "reword"
+    ACTION_DROP   = "drop"
+    ACTION_MERGE  = "merge"
+)
+
+type todoItem struct {
+    action  string
+    hash    string
+    subject string
+}
+
+func sequencerActive() (bool) {
+    _, err := os.Stat(BaseDir + SEQUENCER_DIR)
+    return err == nil
+}
+
+func readSequencerFile(name string) (string, error) {
+    content, err := os.ReadFile(BaseDir + SEQUENCER_DIR + name)
+    if err != nil {
+        return "", &PathError{"read sequencer state", SEQUENCER_DIR + name, err}
+    }
+    return string(content), nil
+}
+
+func writeSequencerFile(name string, content string) (error) {
+    path := BaseDir + SEQUENCER_DIR + name
+    err := os.MkdirAll(BaseDir + SEQUENCER_DIR, 0755)
+    if err != nil {
+        return &PathError{"create dir", BaseDir + SEQUENCER_DIR, err}
+    }
+    err = os.WriteFile(path + ".tmp", []byte(content), 0644)
+    if err == nil {
+        err = os.Rename(path + ".tmp", path)
+    }
+    if err != nil {
+        return &PathError{"write sequencer state", path, err}
+    }
+    return nil
+}
+
+func readTodo() ([]todoItem, error) {
+    content, err := readSequencerFile("todo")
+    if err != nil {
+        return nil, err
+    }
+    return parseTodo(content)
+}
+
+// parseTodo reads todo lines, skipping blanks and "#" comments
+func parseTodo(content string) ([]todoItem, error) {
+    items := []todoItem{}
+    scanner := bufio.NewScanner(strings.NewReader(content))
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        fields := strings.SplitN(line, " ", 3)
+        if len(fields) < 2 {
+            return nil, fmt.Errorf("Bad todo line \"%s\"", line)
+        }
+        item := todoItem{action: fields[0], hash: fields[1]}
+        if len(fields) > 2 {
+            item.subject = fields[2]
+        }
+        items = append(items, item)
+    }
+    return items, nil
+}
+
+func formatTodo(items []todoItem) (string) {
+    var out strings.Builder
+    for _, item := range items {
+        fmt.Fprintf(&out, "%s %s %s\n", item.action, item.hash, item.subject)
+    }
+    return out.String()
+}
+
+// startSequence records where command started and what it has to do
+func startSequence(command string, items []todoItem) (error) {
+    if sequencerActive() {
+        owner, _ := readSequencerFile("command")
+        return fmt.Errorf("A %s is already in progress, use --continue or --abort", strings.TrimSpace(owner))
+    }
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    if head == "" {
+        return fmt.Errorf("No commits yet, nothing to %s onto", command)
+    }
+    err = requireCleanIndex(head)
+    if err != nil {
+        return err
+    }
+    err = writeSequencerFile("command", command + "\n")
+    if err == nil {
+        err = writeSequencerFile("head", head + "\n")
+    }
+    if err == nil {
+        err = writeSequencerFile("todo", formatTodo(items))
+    }
+    return err
+}
+
+// requireCleanIndex fails when changes are staged on top of head
+func requireCleanIndex(head string) (error) {
+    hc, err := deserializeCommit(head)
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+    if tree != hc.Tree {
+        return fmt.Errorf("Staged changes would be lost, commit or stash them first: %w", ErrDirtyWorkTree)
+    }
+    return nil
+}
+
+func endSequence() (error) {
+    err := os.RemoveAll(BaseDir + SEQUENCER_DIR)
+    if err != nil {
+        return &PathError{"remove", BaseDir + SEQUENCER_DIR, err}
+    }
+    return nil
+}
+
+// sequenceCommand names the command owning the sequence in progress, or
+// fails when there is none or another command owns it
+func sequenceCommand(expected string) (error) {
+    if !sequencerActive() {
+        return fmt.Errorf("No %s in progress", expected)
+    }
+    owner, err := readSequencerFile("command")
+    if err != nil {
+        return err
+    }
+    owner = strings.TrimSpace(owner)
+    if owner != expected {
+        return fmt.Errorf("A %s is in progress, not a %s", owner, expected)
+    }
+    return nil
+}
+
+// runSequence works through the todo list, stopping at the first conflict
+// with that item still at the top of the todo for "--continue"
+func runSequence(command string) (error) {
+    for {
+        items, err := readTodo()
+        if err != nil {
+            return err
+        }
+        if len(items) == 0 {
+            return finishSequence(command)
+        }
+        item := items[0]
+        if item.action != ACTION_DROP {
+            c, err := deserializeCommit(item.hash)
+            if err != nil {
+                return err
+            }
+            conflicts, err := applyChange(item.action, c)
+            if err != nil {
+                return err
+            }
+            if len(conflicts) > 0 {
+                fmt.Printf("Could not %s %s %s\n", item.action, truncHash(c.Hash), firstLine(c.Message))
+                fmt.Printf("Fix the conflicts and add them, then run \"%s --continue\", \"%s --skip\" to leave this commit out, or \"%s --abort\" to give up\n", command, command, command)
+                return conflictError(conflicts)
+            }
+            err = commitItem(command, item, c)
+            if err != nil {
+                return err
+            }
+        }
+        err = writeSequencerFile("todo", formatTodo(items[1:]))
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// commitItem commits the applied change of a todo item with the message
+// and author its action calls for
+func commitItem(command string, item todoItem, c models.Commit) (error) {
+    switch item.action {
+    case ACTION_REVERT:
+        message := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", firstLine(c.Message), c.Hash)
+        return commitChange(command, message, getIdentity(), false)
+    case ACTION_REWORD:
+        return commitChange(command, item.subject, c.Author, false)
+    case ACTION_SQUASH, ACTION_FIXUP:
+        head, err := getHead()
+        if err != nil {
+            return err
+        }
+        // HEAD is still onto when everything before was dropped or already
+        // applied, and a commit outside the rebase must not be rewritten
+        onto, err := readSequencerFile("onto")
+        if err != nil {
+            return err
+        }
+        if head == strings.TrimSpace(onto) {
+            fmt.Printf("Nothing to %s %s into, picking it\n", item.action, truncHash(c.Hash))
+            return commitChange(command, c.Message, c.Author, false)
+        }
+        hc, err := deserializeCommit(head)
+        if err != nil {
+            return err
+        }
+        message := hc.Message
+        if item.action == ACTION_SQUASH {
+            message = strings.TrimRight(hc.Message, "\n") + "\n\n" + c.Message
+        }
+        return commitChange(command, message, hc.Author, true)
+    case ACTION_MERGE:
+        return commitMerge(command, item.subject, c.Hash)
+    }
+    if command == REASON_CHERRY_PICK {
+        message := strings.TrimRight(c.Message, "\n") + "\n\n(cherry picked from commit " + c.Hash + ")"
+        return commitChange(command, message, c.Author, false)
+    }
+    return commitChange(command, c.Message, c.Author, false)
+}
+
+// finishSequence runs once the todo is empty
+func finishSequence(command string) (error) {
+    if command == REASON_REBASE {
+        err := finishRebase()
+        if err != nil {
+            return err
+        }
+    }
+    return endSequence()
+}
+
+// applyChange merges the change c made, or its inverse for a revert, into
+// the index and work tree, returning the paths left in conflict
+func applyChange(action string, c models.Commit) ([]mergeConflict, error) {
+    if action == ACTION_MERGE {
+        return applyMerge(c)
+    }
+    if len(c.Parents) > 1 {
+        return nil, fmt.Errorf("Cannot %s %s, it is a merge commit", action, truncHash(c.Hash))
+    }
+    parent := map[string]models.IndexEntry{}
+    if len(c.Parents) == 1 {
+        var err error
+        parent, err = commitFiles(c.Parents[0])
+        if err != nil {
+            return nil, err
+        }
+    }
+    changed, err := treeFiles(c.Tree)
+    if err != nil {
+        return nil, err
+    }
+    base, theirs := parent, changed
+    if action == ACTION_REVERT {
+        base, theirs = changed, parent
+    }
+
+    head, err := getHead()
+    if err != nil {
+        return nil, err
+    }
+    ours, err := commitFiles(head)
+    if err != nil {
+        return nil, err
+    }
+    label := truncHash(c.Hash) + " (" + firstLine(c.Message) + ")"
+    if action == ACTION_REVERT {
+        label = "parent of " + label
+    }
+    merged, conflicts, err := mergeFiles(base, ours, theirs, "HEAD", label)
+    if err != nil {
+        return nil, err
+    }
+    err = checkoutFiles(ours, merged, false)
+    if err != nil {
+        return nil, err
+    }
+    return conflicts, writeIndex(filesIndex(merged))
+}
+
+// commitChange commits the index on top of HEAD, logged under reason, or
+// with amend replaces HEAD. A change that turned out to be empty is skipped
+func commitChange(reason string, message string, author string, amend bool) (error) {
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    hc, err := deserializeCommit(head)
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    tree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+    parents := []string{ head }
+    if amend {
+        parents = hc.Parents
+    } else if tree == hc.Tree {
+        fmt.Printf("Skipping \"%s\", it is already applied\n", firstLine(message))
+        return nil
+    }
+    return commitTree(reason, head, tree, parents, message, author, "")
+}
+
+// commitTree stores a commit of tree and moves HEAD from head to it, dated
+// timestamp or now when that is ""
+func commitTree(reason string, head string, tree string, parents []string, message string, author string, timestamp string) (error) {
+    if timestamp == "" {
+        timestamp = time.Now().Format(time.RFC3339)
+    }
+    hash, err := storeCommit(models.Commit {
+        Tree: tree,
+        Parents: parents,
+        Message: message,
+        Author: author,
+        Timestamp: timestamp,
+    })
+    if err != nil {
+        return err
+    }
+    err = setHead(hash, head, reason, firstLine(message))
+    if err != nil {
+        return err
+    }
+    branch, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        branch = "detached HEAD"
+    }
+    fmt.Printf("[%s %s] %s\n", branch, truncHash(hash), firstLine(message))
+    return nil
+}
+
+// continueSequence commits the resolved conflict and carries on
+func continueSequence(command string) (error) {
+    err := sequenceCommand(command)
+    if err != nil {
+        return err
+    }
+    items, err := readTodo()
+    if err != nil {
+        return err
+    }
+    if len(items) == 0 {
+        return finishSequence(command)
+    }
+    err = requireResolved()
+    if err != nil {
+        return err
+    }
+    c, err := deserializeCommit(items[0].hash)
+    if err != nil {
+        return err
+    }
+    err = commitItem(command, items[0], c)
+    if err != nil {
+        return err
+    }
+    err = writeSequencerFile("todo", formatTodo(items[1:]))
+    if err != nil {
+        return err
+    }
+    return runSequence(command)
+}
+
+// requireResolved fails while a staged file still holds conflict markers
+func requireResolved() (error) {
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    unresolved := []string{}
+    for _, entry := range idx.Entries {
+        content, err := readTypedObject(entry.Hash, BLOB)
+        if err != nil {
+            return err
+        }
+        if bytes.Contains(content, []byte("\n" + MARKER_BASE + "\n")) && bytes.Contains(content, []byte(MARKER_OURS + " ")) {
+            unresolved = append(unresolved, entry.Path)
+        }
+    }
+    if len(unresolved) > 0 {
+        return fmt.Errorf("%s still has conflict markers, fix and add it first: %w", strings.Join(unresolved, ", "), ErrConflict)
+    }
+    return nil
+}
+
+// skipSequence throws away the stopped item's change and carries on
+func skipSequence(command string) (error) {
+    err := sequenceCommand(command)
+    if err != nil {
+        return err
+    }
+    items, err := readTodo()
+    if err != nil {
+        return err
+    }
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    headFiles, err := commitFiles(head)
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    err = writeIndex(filesIndex(headFiles))
+    if err != nil {
+        return err
+    }
+    // only what the stopped item changed goes, local edits to the rest stay
+    old, new := changedFiles(indexFiles(idx), headFiles)
+    err = checkoutFiles(old, new, true)
+    if err != nil {
+        return err
+    }
+    if len(items) > 0 {
+        err = writeSequencerFile("todo", formatTodo(items[1:]))
+        if err != nil {
+            return err
+        }
+    }
+    return runSequence(command)
+}
+
+// abortSequence puts HEAD, the index and the files the sequence changed
+// back to where it started, reattaching HEAD to the branch a rebase was on
+func abortSequence(command string) (error) {
+    err := sequenceCommand(command)
+    if err != nil {
+        return err
+    }
+    head, err := readSequencerFile("head")
+    if err != nil {
+        return err
+    }
+    err = resetHead(strings.TrimSpace(head), RESET_MERGE)
+    if err != nil {
+        return err
+    }
+    if branch, err := readSequencerFile("branch"); err == nil && strings.TrimSpace(branch) != "" {
+        err = setHeadRef(strings.TrimSpace(branch))
+        if err != nil {
+            return err
+        }
+    }
+    return endSequence()
+}
+
+// sequenceCommits expands revisions and A..B ranges into commits, oldest
+// first unless newestFirst
+func sequenceCommits(args []string, newestFirst bool) ([]models.Commit, error) {
+    commits := []models.Commit{}
+    for _, arg := range args {
+        if strings.Contains(arg, "..") || strings.HasPrefix(arg, "^") {
+            include, exclude, err := resolveRange(arg)
+            if err != nil {
+                return nil, err
+            }
+            found, err := revList(include, exclude)
+            if err != nil {
+                return nil, err
+            }
+            if !newestFirst {
+                for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+                    found[i], found[j] = found[j], found[i]
+                }
+            }
+            commits = append(commits, found...)
+            continue
+        }
+        hash, err := resolveCommit(arg)
+        if err != nil {
+            return nil, err
+        }
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return nil, err
+        }
+        commits = append(commits, c)
+    }
+    if len(commits) == 0 {
+        return nil, errors.New("No commits given")
+    }
+    return commits, nil
+}
+
+// replayCommand is the shared body of cherry-pick and revert
+func replayCommand(command string, action string, args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if len(args) == 1 {
+        switch args[0] {
+        case "--continue":
+            return continueSequence(command)
+        case "--abort":
+            return abortSequence(command)
+        case "--skip":
+            return skipSequence(command)
+        }
+    }
+    for _, arg := range args {
+        if strings.HasPrefix(arg, "--") {
+            return fmt.Errorf("Unknown %s option \"%s\"", command, arg)
+        }
+    }
+    commits, err := sequenceCommits(args, action == ACTION_REVERT)
+    if err != nil {
+        return err
+    }
+    items := []todoItem{}
+    for _, c := range commits {
+        items = append(items, todoItem{action, c.Hash, firstLine(c.Message)})
+    }
+    err = startSequence(command, items)
+    if err != nil {
+        return err
+    }
+    return runSequence(command)
+}
+
+// CherryPick applies the changes made by each given commit or A..B range
+// on top of HEAD, "--continue", "--skip" and "--abort" follow up on a conflict
+func CherryPick(args []string) (error) {
+    return replayCommand(REASON_CHERRY_PICK, ACTION_PICK, args)
+}
+
+// Revert commits the inverse of each given commit, newest first
+func Revert(args []string) (error) {
+    return replayCommand(REASON_REVERT, ACTION_REVERT, args)
+}
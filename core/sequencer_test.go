@@ -0,0 +1,192 @@
+package core
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+// pickRepo commits a topic line and then a main line on top of base, with
+// main checked out:
+//
+//     base - t1 (file: topic) - t2 (adds extra)
+//         \
+//          m1 (file: main)
+//
+// t1 conflicts with m1, t2 applies cleanly
+func pickRepo(t *testing.T) (string, string, string) {
+    t.Helper()
+    testRepo(t)
+    writeTestFile(t, "file", "base\n")
+    writeTestFile(t, "other", "base\n")
+    base := commitAll(t, "base")
+    writeTestFile(t, "file", "topic\n")
+    t1 := commitAll(t, "t1")
+    writeTestFile(t, "extra", "extra\n")
+    t2 := commitAll(t, "t2")
+    err := Reset([]string{ "--hard", base })
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, "file", "main\n")
+    m1 := commitAll(t, "m1")
+    return m1, t1, t2
+}
+
+// commitsSince counts the first-parent commits from HEAD down to since
+func commitsSince(t *testing.T, since string) (int) {
+    t.Helper()
+    hash, err := getHead()
+    n := 0
+    for err == nil && hash != since {
+        c, err := deserializeCommit(hash)
+        if err != nil || len(c.Parents) == 0 {
+            t.Fatalf("%s is not below HEAD", since)
+        }
+        hash = c.Parents[0]
+        n++
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    return n
+}
+
+func TestCherryPick(t *testing.T) {
+    tests := []struct {
+        name  string
+        picks []string
+        // how a stopped pick is followed up, "" when it must not stop
+        follow  string
+        file    string
+        extra   bool
+        commits int
+    }{
+        {"clean pick", []string{ "t2" }, "", "main\n", true, 1},
+        {"continue", []string{ "t1", "t2" }, "--continue", "resolved\n", true, 2},
+        {"skip", []string{ "t1", "t2" }, "--skip", "main\n", true, 1},
+        {"abort", []string{ "t1", "t2" }, "--abort", "main\n", false, 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            m1, t1, t2 := pickRepo(t)
+            named := map[string]string{ "t1": t1, "t2": t2 }
+            args := []string{}
+            for _, pick := range tt.picks {
+                args = append(args, named[pick])
+            }
+            // an edit nothing picked touches has to survive whatever happens
+            writeTestFile(t, "other", "precious local edit\n")
+
+            err := CherryPick(args)
+            if tt.follow == "" {
+                if err != nil {
+                    t.Fatal(err)
+                }
+            } else {
+                if !errors.Is(err, ErrConflict) {
+                    t.Fatalf("cherry-pick %v = %v, want a conflict", tt.picks, err)
+                }
+                if tt.follow == "--continue" {
+                    writeTestFile(t, "file", "resolved\n")
+                    err = Add("file")
+                    if err != nil {
+                        t.Fatal(err)
+                    }
+                }
+                err = CherryPick([]string{ tt.follow })
+                if err != nil {
+                    t.Fatalf("cherry-pick %s: %v", tt.follow, err)
+                }
+            }
+
+            if sequencerActive() {
+                t.Fatal("the sequence was left in progress")
+            }
+            if n := commitsSince(t, m1); n != tt.commits {
+                t.Fatalf("%d commits on top of m1, want %d", n, tt.commits)
+            }
+            if got := readTestFile(t, "file"); got != tt.file {
+                t.Fatalf("file holds %q, want %q", got, tt.file)
+            }
+            if got := readTestFile(t, "extra"); (got != "") != tt.extra {
+                t.Fatalf("extra holds %q, want it there %v", got, tt.extra)
+            }
+            if got := readTestFile(t, "other"); got != "precious local edit\n" {
+                t.Fatalf("the local edit to other became %q", got)
+            }
+            head, err := getHead()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if c, _ := deserializeCommit(head); tt.commits > 0 && !strings.Contains(c.Message, "(cherry picked from commit " + t2 + ")") {
+                t.Fatalf("HEAD says %q, want it to name t2", c.Message)
+            }
+        })
+    }
+}
+
+func TestRevert(t *testing.T) {
+    tests := []struct {
+        name   string
+        revert string
+        ok     bool
+        file   string
+        other  string
+    }{
+        {"latest change", "c2", true, "b\n", "x\n"},
+        // c2 builds on what c1 did, so undoing c1 alone conflicts
+        {"under a later change", "c1", false, "c\n", "x\n"},
+        {"unrelated file", "c3", true, "c\n", "base\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            testRepo(t)
+            writeTestFile(t, "file", "a\n")
+            writeTestFile(t, "other", "base\n")
+            commitAll(t, "base")
+            writeTestFile(t, "file", "b\n")
+            c1 := commitAll(t, "c1")
+            writeTestFile(t, "file", "c\n")
+            c2 := commitAll(t, "c2")
+            writeTestFile(t, "other", "x\n")
+            c3 := commitAll(t, "c3")
+            named := map[string]string{ "c1": c1, "c2": c2, "c3": c3 }
+
+            err := Revert([]string{ named[tt.revert] })
+            if !tt.ok {
+                if !errors.Is(err, ErrConflict) {
+                    t.Fatalf("revert = %v, want a conflict", err)
+                }
+                err = Revert([]string{ "--abort" })
+                if err != nil {
+                    t.Fatal(err)
+                }
+            } else if err != nil {
+                t.Fatal(err)
+            }
+
+            want := 0
+            if tt.ok {
+                want = 1
+            }
+            if n := commitsSince(t, c3); n != want {
+                t.Fatalf("%d commits on top of c3, want %d", n, want)
+            }
+            if got := readTestFile(t, "file"); got != tt.file {
+                t.Fatalf("file holds %q, want %q", got, tt.file)
+            }
+            if got := readTestFile(t, "other"); got != tt.other {
+                t.Fatalf("other holds %q, want %q", got, tt.other)
+            }
+            if !tt.ok {
+                return
+            }
+            head, _ := getHead()
+            c, err := deserializeCommit(head)
+            if err != nil || !strings.HasPrefix(c.Message, "Revert \"" + tt.revert + "\"") {
+                t.Fatalf("HEAD says %q, %v", c.Message, err)
+            }
+        })
+    }
+}
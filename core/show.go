@@ -0,0 +1,170 @@
+package core
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+//////////
+// SHOW //
+//////////
+
+// Show prints each object revs name in the way that suits its kind: a
+// commit's header and patch, a tree's listing, a blob's content, or a tag's
+// metadata followed by what it tags. No revs means HEAD
+func Show(revs []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    if len(revs) == 0 {
+        revs = []string{"HEAD"}
+    }
+    for _, rev := range revs {
+        hash, err := resolveRevision(rev)
+        if err != nil {
+            return err
+        }
+        err = showObject(hash, rev)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func showObject(hash string, name string) (error) {
+    kind, payload, err := readObject(hash)
+    if err != nil {
+        return err
+    }
+    switch kind {
+    case COMMIT:
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return err
+        }
+        printCommit(c)
+        // merges are shown against their first parent
+        parentTree := ""
+        if len(c.Parents) > 0 {
+            parent, err := deserializeCommit(c.Parents[0])
+            if err != nil {
+                return err
+            }
+            parentTree = parent.Tree
+        }
+        old, err := treeFiles(parentTree)
+        if err != nil {
+            return err
+        }
+        new, err := treeFiles(c.Tree)
+        if err != nil {
+            return err
+        }
+        return writeFilesDiff(os.Stdout, old, new, true)
+    case TREE:
+        fmt.Printf("tree %s\n\n", name)
+        return printTreeListing(hash)
+    case BLOB:
+        _, err = os.Stdout.Write(payload)
+        return err
+    case TAG:
+        t, err := deserializeTag(hash)
+        if err != nil {
+            return err
+        }
+        fmt.Println("tag " + t.Name)
+        fmt.Println("Tagger: " + t.Tagger)
+        fmt.Println("Date:   " + t.Timestamp)
+        if t.Version != "" && t.Version != t.Name {
+            fmt.Println("Version: " + t.Version)
+        }
+        fmt.Println()
+        return showObject(t.Commit, t.Commit)
+    }
+    return &ObjectError{"show", truncHash(hash), fmt.Errorf("unknown kind \"%s\": %w", kind, ErrCorruptObject)}
+}
+
+// printTreeListing prints "<mode> <kind> <hash>\t<name>" for each entry
+func printTreeListing(hash string) (error) {
+    t, err := deserializeTree(hash)
+    if err != nil {
+        return err
+    }
+    for _, entry := range t.Entries {
+        kind := TREE
+        if entry.IsBlob {
+            kind = BLOB
+        }
+        fmt.Printf("%s %s %s\t%s\n", entry.Mode, kind, entry.Hash, entry.Name)
+    }
+    return nil
+}
+
+// CatObject is show for scripts: "-t" prints the kind of the object rev
+// names, "-s" its payload size in bytes and "-p" its content, with commits
+// and tags as "<field> <value>" lines and trees as a listing
+func CatObject(flag string, rev string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    hash, err := resolveRevision(rev)
+    if err != nil {
+        return err
+    }
+    kind, payload, err := readObject(hash)
+    if err != nil {
+        return err
+    }
+    switch flag {
+    case "-t":
+        fmt.Println(kind)
+        return nil
+    case "-s":
+        fmt.Println(len(payload))
+        return nil
+    case "-p":
+    default:
+        return fmt.Errorf("Unknown cat-object option \"%s\"", flag)
+    }
+
+    switch kind {
+    case COMMIT:
+        c, err := deserializeCommit(hash)
+        if err != nil {
+            return err
+        }
+        fmt.Println("tree " + c.Tree)
+        for _, parent := range c.Parents {
+            fmt.Println("parent " + parent)
+        }
+        fmt.Println("author " + c.Author)
+        fmt.Println("date " + c.Timestamp)
+        fmt.Println()
+        fmt.Println(strings.TrimRight(c.Message, "\n"))
+        return nil
+    case TAG:
+        t, err := deserializeTag(hash)
+        if err != nil {
+            return err
+        }
+        target, _, err := readObject(t.Commit)
+        if err != nil {
+            return err
+        }
+        fmt.Println("object " + t.Commit)
+        fmt.Println("type " + target)
+        fmt.Println("tag " + t.Name)
+        fmt.Println("version " + t.Version)
+        fmt.Println("tagger " + t.Tagger)
+        fmt.Println("date " + t.Timestamp)
+        return nil
+    case TREE:
+        return printTreeListing(hash)
+    }
+    _, err = os.Stdout.Write(payload)
+    return err
+}
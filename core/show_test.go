@@ -0,0 +1,160 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+// captureStdout runs fn with os.Stdout going to a pipe and returns what
+// it printed
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+    t.Helper()
+    return captureOutput(t, &os.Stdout, fn)
+}
+
+// captureOutput does the same for os.Stdout or os.Stderr
+func captureOutput(t *testing.T, file **os.File, fn func() error) (string, error) {
+    t.Helper()
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    saved := *file
+    *file = w
+    printed := make(chan string)
+    go func() {
+        out, _ := io.ReadAll(r)
+        printed <- string(out)
+    }()
+    err = fn()
+    *file = saved
+    w.Close()
+    return <-printed, err
+}
+
+// showRepo commits a file and a dir/sub file and tags the commit v1
+func showRepo(t *testing.T) (models.Commit, models.Tag, string, string) {
+    t.Helper()
+    testRepo(t)
+    writeTestFile(t, "file", "hello\n")
+    writeTestFile(t, "dir/sub", "sub\n")
+    head := commitAll(t, "first\n\nwith a body")
+    err := Tag([]string{ "v1" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    c, err := deserializeCommit(head)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tagHash, err := readRef(TAGS_PREFIX + "v1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    tag, err := deserializeTag(tagHash)
+    if err != nil {
+        t.Fatal(err)
+    }
+    blob, _ := hashBlob(models.Blob{ Content: []byte("hello\n") })
+    dir, err := treeLookup(c.Tree, "dir")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return c, tag, blob, dir
+}
+
+func TestCatObject(t *testing.T) {
+    c, tag, blob, dir := showRepo(t)
+    sub, _ := hashBlob(models.Blob{ Content: []byte("sub\n") })
+    tests := []struct {
+        flag string
+        rev  string
+        want string
+    }{
+        {"-t", "HEAD", "commit\n"},
+        {"-t", "HEAD:", "tree\n"},
+        {"-t", "HEAD:file", "blob\n"},
+        {"-t", TAGS_PREFIX + "v1", "tag\n"},
+        {"-s", "HEAD:file", "6\n"},
+        {"-p", "HEAD:file", "hello\n"},
+        {"-p", "HEAD:dir", FILE_MODE + " blob " + sub + "\tsub\n"},
+        {"-p", "HEAD", fmt.Sprintf("tree %s\nparent %s\nauthor %s\ndate %s\n\nfirst\n\nwith a body\n", c.Tree, c.Parents[0], c.Author, c.Timestamp)},
+        {"-p", TAGS_PREFIX + "v1", fmt.Sprintf("object %s\ntype commit\ntag v1\nversion v1\ntagger %s\ndate %s\n", c.Hash, tag.Tagger, tag.Timestamp)},
+        {"-p", "HEAD:", fmt.Sprintf("%s tree %s\tdir\n%s blob %s\tfile\n", DIR_MODE, dir, FILE_MODE, blob)},
+    }
+    for _, tt := range tests {
+        got, err := captureStdout(t, func() (error) {
+            return CatObject(tt.flag, tt.rev)
+        })
+        if err != nil || got != tt.want {
+            t.Errorf("cat-object %s %s = %q, %v, want %q", tt.flag, tt.rev, got, err, tt.want)
+        }
+    }
+}
+
+func TestShow(t *testing.T) {
+    c, tag, _, _ := showRepo(t)
+    commit := fmt.Sprintf("commit %s\nAuthor: %s\nDate:   %s\n\n    first\n    \n    with a body\n\n", c.Hash, c.Author, c.Timestamp)
+    tests := []struct {
+        rev string
+        // what the output starts with and has somewhere after that
+        prefix   string
+        contains string
+    }{
+        {"HEAD", commit, "+hello\n"},
+        {"HEAD:file", "hello\n", ""},
+        {"HEAD:dir", "tree HEAD:dir\n\n", "\tsub\n"},
+        {TAGS_PREFIX + "v1", fmt.Sprintf("tag v1\nTagger: %s\nDate:   %s\n\n", tag.Tagger, tag.Timestamp), commit},
+    }
+    for _, tt := range tests {
+        got, err := captureStdout(t, func() (error) {
+            return Show([]string{ tt.rev })
+        })
+        if err != nil || !strings.HasPrefix(got, tt.prefix) || !strings.Contains(got[len(tt.prefix):], tt.contains) {
+            t.Errorf("show %s = %q, %v, want %q then %q", tt.rev, got, err, tt.prefix, tt.contains)
+        }
+    }
+}
+
+func TestTreeLookup(t *testing.T) {
+    c, _, blob, dir := showRepo(t)
+    // a directory entry whose tree does not parse
+    broken := strings.Repeat("ab", 20)
+    err := os.WriteFile(BaseDir + OBJECTS_DIR + broken, []byte("tree 7\x00garbage"), 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    root := storeRawTree(t, models.TreeEntry{ Name: "broken", Mode: DIR_MODE, Hash: broken })
+
+    tests := []struct {
+        tree string
+        path string
+        want string
+        err  error
+    }{
+        {c.Tree, "file", blob, nil},
+        {c.Tree, "dir", dir, nil},
+        {c.Tree, "", c.Tree, nil},
+        {c.Tree, "nosuch", "", ErrObjectNotFound},
+        {c.Tree, "file/below", "", ErrObjectNotFound},
+        {root, "broken/file", "", ErrCorruptObject},
+    }
+    for _, tt := range tests {
+        got, err := treeLookup(tt.tree, tt.path)
+        if tt.err != nil {
+            if !errors.Is(err, tt.err) {
+                t.Errorf("treeLookup(%q) = %v, want %v", tt.path, err, tt.err)
+            }
+            continue
+        }
+        if err != nil || got != tt.want {
+            t.Errorf("treeLookup(%q) = %s, %v, want %s", tt.path, got, err, tt.want)
+        }
+    }
+}
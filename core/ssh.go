@@ -0,0 +1,249 @@
+package core
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+/////////
+// SSH //
+/////////
+
+// A repository behind ssh is reached by running "goverse <path>
+// upload-pack" or "receive-pack" on the far side, which speak protocol.go
+// over stdin and stdout. Each starts with the ref advertisement.
+//
+// Remotes look like "ssh://[user@]host/path" or "[user@]host:path". The
+// client runs "transport.command" (ssh by default) with the host and the
+// remote command line as its last two arguments, and "transport.program"
+// names goverse on the far side. GOVERSE_SSH_COMMAND and
+// GOVERSE_SSH_PROGRAM override both, which clone needs as its new
+// repository has no config yet
+
+const (
+    SSH_SCHEME         = "ssh://"
+    DEFAULT_SSH        = "ssh"
+    DEFAULT_SSH_REMOTE = "goverse"
+)
+
+// UploadPack serves one fetch over stdin and stdout: the ref advertisement,
+// then wants and haves in and a pack out. A client that only wanted the
+// refs closes stdin without asking for anything
+func UploadPack() (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    out := bufio.NewWriter(os.Stdout)
+    refs, err := advertiseRefs()
+    if err != nil {
+        return err
+    }
+    err = writeRefs(out, refs)
+    if err == nil {
+        err = out.Flush()
+    }
+    if err != nil {
+        return err
+    }
+    wants, haves, depth, err := readWants(bufio.NewReader(os.Stdin))
+    if err != nil {
+        return err
+    }
+    if len(wants) == 0 {
+        return nil
+    }
+    err = uploadPack(out, wants, haves, depth)
+    if err != nil {
+        return err
+    }
+    return out.Flush()
+}
+
+// ReceivePack serves one push over stdin and stdout: the ref advertisement,
+// then updates and a pack in and a result per update out
+func ReceivePack() (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    out := bufio.NewWriter(os.Stdout)
+    refs, err := advertiseRefs()
+    if err != nil {
+        return err
+    }
+    err = writeRefs(out, refs)
+    if err == nil {
+        err = out.Flush()
+    }
+    if err != nil {
+        return err
+    }
+    in := bufio.NewReader(os.Stdin)
+    if _, err := in.Peek(1); err == io.EOF {
+        return nil
+    }
+    updates, err := readUpdates(in)
+    if err != nil {
+        return err
+    }
+    results, err := receivePack(in, updates)
+    if err != nil {
+        return err
+    }
+    err = writeResults(out, updates, results)
+    if err != nil {
+        return err
+    }
+    return out.Flush()
+}
+
+///////////////////
+// SSH TRANSPORT //
+///////////////////
+
+type sshTransport struct {
+    host string
+    path string
+}
+
+// isSSH says whether url names a repository behind ssh, either as
+// ssh://host/path or as host:path with no slash before the colon
+func isSSH(url string) (bool) {
+    if strings.HasPrefix(url, SSH_SCHEME) {
+        return true
+    }
+    colon := strings.Index(url, ":")
+    return colon > 0 && !strings.Contains(url[:colon], "/") && !strings.Contains(url, "://")
+}
+
+func newSSHTransport(url string) (*sshTransport, error) {
+    host, path := "", ""
+    if strings.HasPrefix(url, SSH_SCHEME) {
+        rest := strings.TrimPrefix(url, SSH_SCHEME)
+        slash := strings.Index(rest, "/")
+        if slash < 0 {
+            return nil, fmt.Errorf("\"%s\" names no repository path", url)
+        }
+        host, path = rest[:slash], rest[slash:]
+    } else {
+        host, path, _ = strings.Cut(url, ":")
+    }
+    if host == "" || path == "" {
+        return nil, fmt.Errorf("\"%s\" needs both a host and a path", url)
+    }
+    // ssh would take "-oProxyCommand=..." as an option, not a host
+    if strings.HasPrefix(host, "-") {
+        return nil, fmt.Errorf("\"%s\" is not a valid ssh host: %w", host, ErrUnsafePath)
+    }
+    return &sshTransport{host, path}, nil
+}
+
+// sshArgs is the command line to run remote on host with argv, ssh itself
+// gets "--" so nothing after it is read as an option
+func sshArgs(argv []string, host string, remote string) ([]string) {
+    args := append([]string{}, argv[1:]...)
+    if filepath.Base(argv[0]) == DEFAULT_SSH {
+        args = append(args, "--")
+    }
+    return append(args, host, remote)
+}
+
+// shellQuote wraps s in single quotes for the remote shell
+func shellQuote(s string) (string) {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// run starts service on the far side, reads its ref advertisement, writes
+// the request and hands what comes back to response
+func (t *sshTransport) run(service string, request func(w io.Writer) error, response func(r *bufio.Reader) error) (map[string]string, error) {
+    command := os.Getenv("GOVERSE_SSH_COMMAND")
+    if command == "" {
+        command = getConfig("transport.command", DEFAULT_SSH)
+    }
+    argv := strings.Fields(command)
+    if len(argv) == 0 {
+        argv = []string{ DEFAULT_SSH }
+    }
+    program := os.Getenv("GOVERSE_SSH_PROGRAM")
+    if program == "" {
+        program = getConfig("transport.program", DEFAULT_SSH_REMOTE)
+    }
+    remote := program + " " + shellQuote(t.path) + " " + service
+    cmd := exec.Command(argv[0], sshArgs(argv, t.host, remote)...)
+    cmd.Stderr = os.Stderr
+    stdin, err := cmd.StdinPipe()
+    if err != nil {
+        return nil, err
+    }
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return nil, err
+    }
+    err = cmd.Start()
+    if err != nil {
+        return nil, fmt.Errorf("Unable to run \"%s\": %w", argv[0], err)
+    }
+
+    out := bufio.NewReader(stdout)
+    refs, err := readRefs(out)
+    if err == nil && request != nil {
+        err = request(stdin)
+    }
+    stdin.Close()
+    if err == nil && response != nil {
+        err = response(out)
+    }
+    // whatever is left unread would block the far side from exiting
+    io.Copy(io.Discard, out)
+    // the far side failing explains a cut off stream better than the stream
+    waitErr := cmd.Wait()
+    if waitErr != nil {
+        return nil, fmt.Errorf("Remote %s on %s failed: %w", service, t.host, waitErr)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return refs, nil
+}
+
+func (t *sshTransport) advertise() (map[string]string, error) {
+    return t.run("upload-pack", nil, nil)
+}
+
+func (t *sshTransport) fetchPack(wants []string, haves []string, depth int) ([]string, error) {
+    var received []string
+    _, err := t.run("upload-pack", func(w io.Writer) (error) {
+        return writeWants(w, wants, haves, depth)
+    }, func(r *bufio.Reader) (error) {
+        var err error
+        received, err = readPack(r)
+        return err
+    })
+    return received, err
+}
+
+func (t *sshTransport) sendPack(updates []refUpdate, hashes []string) ([]string, error) {
+    var results []string
+    _, err := t.run("receive-pack", func(w io.Writer) (error) {
+        buffered := bufio.NewWriter(w)
+        err := writeUpdates(buffered, updates)
+        if err == nil {
+            err = writePack(buffered, hashes)
+        }
+        if err == nil {
+            err = buffered.Flush()
+        }
+        return err
+    }, func(r *bufio.Reader) (error) {
+        var err error
+        results, err = readResults(r, updates)
+        return err
+    })
+    return results, err
+}
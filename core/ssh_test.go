@@ -0,0 +1,160 @@
+package core
+
+import (
+    "errors"
+    "flag"
+    "os"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestIsSSH(t *testing.T) {
+    tests := []struct {
+        url string
+        ssh bool
+    }{
+        {"ssh://host/repo", true},
+        {"user@host:repo", true},
+        {"host:/srv/repo", true},
+        {"/srv/repo", false},
+        {"./dir:with/colon", false},
+        {"http://host/repo", false},
+        {"repo.bundle", false},
+    }
+    for _, tt := range tests {
+        if got := isSSH(tt.url); got != tt.ssh {
+            t.Errorf("isSSH(%q) = %v, want %v", tt.url, got, tt.ssh)
+        }
+    }
+}
+
+func TestNewSSHTransport(t *testing.T) {
+    tests := []struct {
+        url  string
+        host string
+        path string
+        err  error
+    }{
+        {"ssh://user@host/srv/repo", "user@host", "/srv/repo", nil},
+        {"host:repo", "host", "repo", nil},
+        {"ssh://-oProxyCommand=touch${IFS}pwned/repo", "", "", ErrUnsafePath},
+        {"-oProxyCommand=touch pwned:repo", "", "", ErrUnsafePath},
+    }
+    for _, tt := range tests {
+        transport, err := newSSHTransport(tt.url)
+        if tt.err != nil {
+            if !errors.Is(err, tt.err) {
+                t.Errorf("newSSHTransport(%q) = %v, want %v", tt.url, err, tt.err)
+            }
+            continue
+        }
+        if err != nil || transport.host != tt.host || transport.path != tt.path {
+            t.Errorf("newSSHTransport(%q) = %+v, %v, want %s %s", tt.url, transport, err, tt.host, tt.path)
+        }
+    }
+    if _, err := newSSHTransport("ssh://host"); err == nil {
+        t.Error("a url without a path was accepted")
+    }
+}
+
+func TestSSHArgs(t *testing.T) {
+    tests := []struct {
+        argv []string
+        want []string
+    }{
+        {[]string{"ssh"}, []string{"--", "host", "cmd"}},
+        {[]string{"/usr/bin/ssh", "-p", "2222"}, []string{"-p", "2222", "--", "host", "cmd"}},
+        {[]string{"./fake-ssh"}, []string{"host", "cmd"}},
+    }
+    for _, tt := range tests {
+        if got := sshArgs(tt.argv, "host", "cmd"); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("sshArgs(%q) = %q, want %q", tt.argv, got, tt.want)
+        }
+    }
+}
+
+// TestSSHHelperProcess stands in for ssh in TestSSHTransport: it gets the
+// host and the remote command line as its last two arguments and runs the
+// command here. It does nothing when run on its own
+func TestSSHHelperProcess(t *testing.T) {
+    if os.Getenv("GOVERSE_SSH_HELPER") == "" {
+        t.Skip("only run by TestSSHTransport")
+    }
+    args := flag.Args()
+    if len(args) != 2 {
+        t.Fatalf("want a host and a command, got %q", args)
+    }
+    // "<program> '<path>' <service>", as run quotes it
+    command := args[1]
+    first, last := strings.Index(command, " "), strings.LastIndex(command, " ")
+    path := strings.ReplaceAll(strings.Trim(command[first+1:last], "'"), `'\''`, "'")
+    BaseDir = path + "/"
+    var err error
+    switch service := command[last+1:]; service {
+    case "upload-pack":
+        err = UploadPack()
+    case "receive-pack":
+        err = ReceivePack()
+    default:
+        t.Fatalf("unknown service %q", service)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    // anything the test binary prints after this would end up in the stream
+    os.Exit(0)
+}
+
+func TestSSHTransport(t *testing.T) {
+    if testing.Short() {
+        t.Skip("starts ssh stand-in processes")
+    }
+    t.Setenv("GOVERSE_SSH_COMMAND", os.Args[0] + " -test.run=^TestSSHHelperProcess$")
+    t.Setenv("GOVERSE_SSH_HELPER", "1")
+    upstream := testRepo(t)
+    writeTestFile(t, "file", "one\n")
+    one := commitAll(t, "one")
+    err := setConfig("receive.denyCurrentBranch", "ignore")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // clone, then push a commit back
+    dest := t.TempDir() + "/"
+    err = Clone([]string{ "ssh://localhost" + upstream, dest })
+    if err != nil {
+        t.Fatal(err)
+    }
+    var two string
+    err = inRepository(dest, func() (error) {
+        if head, err := getHead(); err != nil || head != one {
+            t.Errorf("clone is at %s, %v, want %s", head, err, one)
+        }
+        writeTestFile(t, "file", "two\n")
+        two = commitAll(t, "two")
+        return Push(nil)
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := refIn(t, upstream, BRANCHES_PREFIX + DEFAULT_BRANCH); got != two {
+        t.Fatalf("remote main is %s after the push, want %s", got, two)
+    }
+
+    // a second repository fetches what the first pushed, host:path style
+    testRepo(t)
+    err = Remote([]string{ "add", DEFAULT_REMOTE, "localhost:" + upstream })
+    if err == nil {
+        err = Fetch(nil)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := resolveRef(REMOTES_PREFIX + DEFAULT_REMOTE + "/" + DEFAULT_BRANCH); got != two {
+        t.Fatalf("fetched origin/main is %s, want %s", got, two)
+    }
+    if !objectExists(one) {
+        t.Fatal("the fetch left out history")
+    }
+}
@@ -0,0 +1,482 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////
+// STASH //
+///////////
+
+// A stash entry is a commit W of the work tree whose parents are the HEAD
+// it was made on, a commit I of the index and, with untracked files, a
+// root commit U of those. The stash ref points at the newest W and its
+// reflog is the stack, so stash@{n} is the usual reflog syntax
+
+const (
+    STASH_LABEL_OURS   = "Updated upstream"
+    STASH_LABEL_THEIRS = "Stashed changes"
+)
+
+// Stash saves and restores uncommitted work: "stash [push] [-u] [-m <message>]",
+// "stash list", "stash show [-p] [<stash>]", "stash apply [--index] [<stash>]",
+// "stash pop [--index] [<stash>]", "stash drop [<stash>]" and "stash clear"
+func Stash(args []string) (error) {
+    err := requireRepository()
+    if err != nil {
+        return err
+    }
+    sub := "push"
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        sub, args = args[0], args[1:]
+    }
+    if sub == "list" {
+        return stashList()
+    }
+    if sub == "show" {
+        return stashShow(args)
+    }
+
+    unlock, err := lockRepository()
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    switch sub {
+    case "push", "save":
+        return stashPush(args)
+    case "apply", "pop":
+        n, restoreIndex := 0, false
+        for _, arg := range args {
+            if arg == "--index" {
+                restoreIndex = true
+                continue
+            }
+            n, err = stashIndex(arg)
+            if err != nil {
+                return err
+            }
+        }
+        err := stashApply(n, restoreIndex)
+        if err != nil {
+            if sub == "pop" && errors.Is(err, ErrConflict) {
+                fmt.Println("The stash entry is kept in case you need it again.")
+            }
+            return err
+        }
+        if sub == "pop" {
+            return stashDrop(n)
+        }
+        return nil
+    case "drop":
+        n := 0
+        if len(args) > 0 {
+            n, err = stashIndex(args[0])
+            if err != nil {
+                return err
+            }
+        }
+        return stashDrop(n)
+    case "clear":
+        if !refExists(STASH_REF) {
+            return nil
+        }
+        return deleteRef(STASH_REF)
+    }
+    return fmt.Errorf("Unknown stash command \"%s\"", sub)
+}
+
+// stashIndex reads "stash@{n}" or a bare "n"
+func stashIndex(arg string) (int, error) {
+    arg = strings.TrimPrefix(arg, STASH_REF + "@{")
+    arg = strings.TrimSuffix(arg, "}")
+    n, err := strconv.Atoi(arg)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("\"%s\" is not a stash entry", arg)
+    }
+    return n, nil
+}
+
+// stashCommit is the W commit of stash@{n}
+func stashCommit(n int) (models.Commit, error) {
+    if !refExists(STASH_REF) {
+        return models.Commit{}, fmt.Errorf("No stash entries: %w", ErrObjectNotFound)
+    }
+    hash, err := reflogEntry(STASH_REF, n)
+    if err != nil {
+        return models.Commit{}, err
+    }
+    c, err := deserializeCommit(hash)
+    if err != nil {
+        return c, err
+    }
+    if len(c.Parents) < 2 {
+        return c, fmt.Errorf("%s is not a stash commit: %w", truncHash(hash), ErrCorruptObject)
+    }
+    return c, nil
+}
+
+func stashPush(args []string) (error) {
+    untracked, message := false, ""
+    for i, arg := range args {
+        if arg == "-u" || arg == "--include-untracked" {
+            untracked = true
+        } else if arg == "-m" || arg == "--message" {
+            message = strings.Join(args[i+1:], " ")
+            break
+        } else {
+            return fmt.Errorf("Unknown stash option \"%s\"", arg)
+        }
+    }
+
+    head, err := getHead()
+    if err != nil {
+        return err
+    }
+    if head == "" {
+        return errors.New("No commits yet, a stash needs a commit to stash on")
+    }
+    hc, err := deserializeCommit(head)
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    indexTree, err := writeTreeFromIndex(idx)
+    if err != nil {
+        return err
+    }
+    work, err := workTreeSnapshot(idx)
+    if err != nil {
+        return err
+    }
+    workTree, err := writeTreeFromIndex(filesIndex(work))
+    if err != nil {
+        return err
+    }
+    extra := map[string]models.IndexEntry{}
+    if untracked {
+        extra, err = untrackedFiles(idx)
+        if err != nil {
+            return err
+        }
+    }
+    if indexTree == hc.Tree && workTree == hc.Tree && len(extra) == 0 {
+        fmt.Println("No local changes to save")
+        return nil
+    }
+
+    branch, err := currentBranch()
+    if err != nil {
+        return err
+    }
+    if branch == "" {
+        branch = "(no branch)"
+    }
+    summary := fmt.Sprintf("%s: %s %s", branch, truncHash(head), firstLine(hc.Message))
+    indexCommit, err := createCommit(indexTree, []string{ head }, "index on " + summary)
+    if err != nil {
+        return err
+    }
+    parents := []string{ head, indexCommit }
+    if len(extra) > 0 {
+        extraTree, err := writeTreeFromIndex(filesIndex(extra))
+        if err != nil {
+            return err
+        }
+        extraCommit, err := createCommit(extraTree, []string{}, "untracked files on " + summary)
+        if err != nil {
+            return err
+        }
+        parents = append(parents, extraCommit)
+    }
+    if message == "" {
+        message = "WIP on " + summary
+    } else {
+        message = "On " + branch + ": " + message
+    }
+    stash, err := createCommit(workTree, parents, message)
+    if err != nil {
+        return err
+    }
+    err = updateRef(STASH_REF, stash, "", REASON_STASH, message)
+    if err != nil {
+        return err
+    }
+
+    // everything is saved, put the work tree and index back to HEAD
+    headFiles, err := treeFiles(hc.Tree)
+    if err != nil {
+        return err
+    }
+    err = writeIndex(filesIndex(headFiles))
+    if err != nil {
+        return err
+    }
+    err = checkoutFiles(indexFiles(idx), headFiles, true)
+    if err != nil {
+        return err
+    }
+    for p := range extra {
+        os.Remove(BaseDir + p)
+        removeEmptyParents(p)
+    }
+    fmt.Println("Saved working directory and index state " + message)
+    return nil
+}
+
+// stashApply merges stash@{n} into the work tree, and with restoreIndex
+// into the index as well, refusing before it touches anything when local
+// changes or untracked files are in the way
+func stashApply(n int, restoreIndex bool) (error) {
+    w, err := stashCommit(n)
+    if err != nil {
+        return err
+    }
+    base, err := commitFiles(w.Parents[0])
+    if err != nil {
+        return err
+    }
+    stashed, err := treeFiles(w.Tree)
+    if err != nil {
+        return err
+    }
+    idx, err := readIndex()
+    if err != nil {
+        return err
+    }
+    ours := indexFiles(idx)
+    merged, conflicts, err := mergeFiles(base, ours, stashed, STASH_LABEL_OURS, STASH_LABEL_THEIRS)
+    if err != nil {
+        return err
+    }
+
+    // the index keeps what it had plus files the stash adds, unless asked
+    // to replay the stashed index too
+    newIndex := map[string]models.IndexEntry{}
+    for p, entry := range ours {
+        newIndex[p] = entry
+    }
+    if restoreIndex {
+        stashedIndex, err := commitFiles(w.Parents[1])
+        if err != nil {
+            return err
+        }
+        replayed, indexConflicts, err := mergeFiles(base, ours, stashedIndex, STASH_LABEL_OURS, STASH_LABEL_THEIRS)
+        if err != nil {
+            return err
+        }
+        if len(indexConflicts) > 0 {
+            return fmt.Errorf("Conflicts in the stashed index, try again without --index: %w", ErrConflict)
+        }
+        newIndex = replayed
+    }
+    for p, entry := range merged {
+        if _, ok := newIndex[p]; !ok {
+            newIndex[p] = entry
+        }
+    }
+
+    extra := map[string]models.IndexEntry{}
+    if len(w.Parents) > 2 {
+        extra, err = commitFiles(w.Parents[2])
+        if err != nil {
+            return err
+        }
+        for p := range extra {
+            if _, err := os.Lstat(BaseDir + p); err == nil {
+                return fmt.Errorf("Untracked file \"%s\" already exists, nothing was applied: %w", p, ErrDirtyWorkTree)
+            }
+        }
+    }
+
+    err = checkoutFiles(ours, merged, false)
+    if err != nil {
+        return err
+    }
+    for p, entry := range extra {
+        err = writeWorkFile(p, entry, false)
+        if err != nil {
+            return err
+        }
+    }
+    err = writeIndex(filesIndex(newIndex))
+    if err != nil {
+        return err
+    }
+    if len(conflicts) > 0 {
+        return conflictError(conflicts)
+    }
+    return nil
+}
+
+// stashDrop removes stash@{n}, moving the stash ref to the next entry
+func stashDrop(n int) (error) {
+    w, err := stashCommit(n)
+    if err != nil {
+        return err
+    }
+    lock, err := acquireLock(BaseDir + GOVERSE_DIR + STASH_REF, REF_LOCK_TIMEOUT)
+    if err != nil {
+        return err
+    }
+    entries, err := readReflog(STASH_REF)
+    if err != nil {
+        lock.rollback()
+        return err
+    }
+    if n >= len(entries) {
+        lock.rollback()
+        return fmt.Errorf("No stash entry stash@{%d}: %w", n, ErrObjectNotFound)
+    }
+    entries = append(entries[:len(entries)-1-n], entries[len(entries)-n:]...)
+
+    if len(entries) == 0 {
+        err = os.Remove(BaseDir + GOVERSE_DIR + STASH_REF)
+        if err == nil {
+            err = deleteReflog(STASH_REF)
+        }
+        lock.rollback()
+    } else {
+        err = writeReflog(STASH_REF, entries)
+        if err != nil {
+            lock.rollback()
+            return err
+        }
+        err = lock.commit([]byte(entries[len(entries)-1].New + "\n"))
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Dropped stash@{%d} (%s)\n", n, truncHash(w.Hash))
+    return nil
+}
+
+func stashList() (error) {
+    entries, err := readReflog(STASH_REF)
+    if err != nil {
+        return err
+    }
+    for i := len(entries) - 1; i >= 0; i-- {
+        fmt.Printf("stash@{%d}: %s\n", len(entries)-1-i, entries[i].Message)
+    }
+    return nil
+}
+
+// stashShow prints a diffstat of a stash entry, or with "-p" the whole patch
+func stashShow(args []string) (error) {
+    n, patch := 0, false
+    for _, arg := range args {
+        if arg == "-p" || arg == "--patch" {
+            patch = true
+            continue
+        }
+        var err error
+        n, err = stashIndex(arg)
+        if err != nil {
+            return err
+        }
+    }
+    w, err := stashCommit(n)
+    if err != nil {
+        return err
+    }
+    base, err := commitFiles(w.Parents[0])
+    if err != nil {
+        return err
+    }
+    stashed, err := treeFiles(w.Tree)
+    if err != nil {
+        return err
+    }
+    return writeFilesDiff(os.Stdout, base, stashed, patch)
+}
+
+// commitFiles is treeFiles of a commit's tree
+func commitFiles(hash string) (map[string]models.IndexEntry, error) {
+    c, err := deserializeCommit(hash)
+    if err != nil {
+        return nil, err
+    }
+    return treeFiles(c.Tree)
+}
+
+// filesIndex turns a path -> entry map back into an index
+func filesIndex(files map[string]models.IndexEntry) (models.Index) {
+    idx := models.Index{ Entries: []models.IndexEntry{} }
+    for _, entry := range files {
+        idx.Entries = append(idx.Entries, entry)
+    }
+    sort.Slice(idx.Entries, func(i, j int) bool {
+        return idx.Entries[i].Path < idx.Entries[j].Path
+    })
+    return idx
+}
+
+// workTreeSnapshot stores the current content of every file in the index,
+// leaving out the ones deleted from the work tree
+func workTreeSnapshot(idx models.Index) (map[string]models.IndexEntry, error) {
+    files := map[string]models.IndexEntry{}
+    for _, entry := range idx.Entries {
+        snapshot := models.Index{}
+        err := stagePath(&snapshot, entry.Path)
+        if err != nil {
+            return nil, err
+        }
+        for _, staged := range snapshot.Entries {
+            files[staged.Path] = staged
+        }
+    }
+    return files, nil
+}
+
+// untrackedFiles stores every file in the work tree the index does not know
+func untrackedFiles(idx models.Index) (map[string]models.IndexEntry, error) {
+    tracked := indexFiles(idx)
+    files := map[string]models.IndexEntry{}
+    root := filepath.Clean(BaseDir)
+    err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) (error) {
+        if err != nil {
+            return err
+        }
+        if entry.IsDir() {
+            if entry.Name() == GOVERSE {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        rel, err := filepath.Rel(root, name)
+        if err != nil {
+            return err
+        }
+        rel = filepath.ToSlash(rel)
+        if _, ok := tracked[rel]; ok {
+            return nil
+        }
+        snapshot := models.Index{}
+        err = stagePath(&snapshot, rel)
+        if err != nil {
+            return err
+        }
+        for _, staged := range snapshot.Entries {
+            files[staged.Path] = staged
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, &PathError{"list untracked files", root, err}
+    }
+    return files, nil
+}
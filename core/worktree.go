@@ -0,0 +1,309 @@
+package core
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+
+    "goverse/internal/models"
+)
+
+///////////////
+// WORK TREE //
+///////////////
+
+// Commands that change which commit is checked out describe the files before
+// and after as path -> entry maps and let checkoutFiles rewrite the work tree
+
+// treeFiles flattens a tree into its files, "" is the empty tree
+func treeFiles(tree string) (map[string]models.IndexEntry, error) {
+    files := map[string]models.IndexEntry{}
+    if tree == "" {
+        return files, nil
+    }
+    idx, err := indexFromTree(tree)
+    if err != nil {
+        return nil, err
+    }
+    return indexFiles(idx), nil
+}
+
+func indexFiles(idx models.Index) (map[string]models.IndexEntry) {
+    files := map[string]models.IndexEntry{}
+    for _, entry := range idx.Entries {
+        files[entry.Path] = entry
+    }
+    return files
+}
+
+// fileMode turns a stored mode back into permission bits
+func fileMode(mode string) (os.FileMode) {
+    bits, err := strconv.ParseUint(mode, 8, 32)
+    if err != nil {
+        return 0644
+    }
+    return os.FileMode(bits).Perm()
+}
+
+// workMode is the canonical mode stored for a work tree entry of type mode,
+// "" for sockets, devices, FIFOs and anything else a tree cannot hold
+func workMode(mode os.FileMode) (string) {
+    switch {
+    case mode & os.ModeSymlink != 0:
+        return SYMLINK_MODE
+    case mode.IsDir():
+        return DIR_MODE
+    case !mode.IsRegular():
+        return ""
+    case mode & 0111 != 0:
+        return EXECUTABLE_MODE
+    }
+    return FILE_MODE
+}
+
+// readWorkContent is what the blob for the entry at full holds, a file's
+// content or a symlink's target
+func readWorkContent(full string, info os.FileInfo) ([]byte, error) {
+    if info.Mode() & os.ModeSymlink != 0 {
+        target, err := os.Readlink(full)
+        if err != nil {
+            return nil, &PathError{"read link", full, err}
+        }
+        return []byte(target), nil
+    }
+    content, err := os.ReadFile(full)
+    if err != nil {
+        return nil, &PathError{"read file", full, err}
+    }
+    return content, nil
+}
+
+// beyondSymlink says whether a directory above rel is a symlink, which
+// would make rel a path into whatever the link points at
+func beyondSymlink(rel string) (bool) {
+    for parent := path.Dir(rel); parent != "."; parent = path.Dir(parent) {
+        if info, err := os.Lstat(BaseDir + parent); err == nil && info.Mode() & os.ModeSymlink != 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// workFileHash is the blob hash of a file or symlink in the work tree, ""
+// if it is missing or something else
+func workFileHash(rel string) (string) {
+    info, err := os.Lstat(BaseDir + rel)
+    if err != nil || beyondSymlink(rel) {
+        return ""
+    }
+    if mode := workMode(info.Mode()); mode == "" || mode == DIR_MODE {
+        return ""
+    }
+    content, err := readWorkContent(BaseDir + rel, info)
+    if err != nil {
+        return ""
+    }
+    hash, err := hashBlob(models.Blob{ Content: content })
+    if err != nil {
+        return ""
+    }
+    return hash
+}
+
+// checkoutFiles rewrites the work tree from the files in old to those in new.
+// Without force it refuses, touching nothing, when that would lose a change
+// not in either side, and it keeps local edits to files old and new agree on
+func checkoutFiles(old map[string]models.IndexEntry, new map[string]models.IndexEntry, force bool) (error) {
+    paths := []string{}
+    for p := range old {
+        paths = append(paths, p)
+    }
+    for p := range new {
+        if _, ok := old[p]; !ok {
+            paths = append(paths, p)
+        }
+    }
+    sort.Strings(paths)
+    for _, p := range paths {
+        err := checkPath(p)
+        if err != nil {
+            return err
+        }
+    }
+
+    if !force {
+        dirty := []string{}
+        for _, p := range paths {
+            before, inOld := old[p]
+            after, inNew := new[p]
+            if inOld && inNew && before.Hash == after.Hash {
+                continue
+            }
+            // an untracked file in the way of a new file's directory
+            if parent := blockedParent(p); inNew && parent != "" {
+                if _, tracked := old[parent]; !tracked {
+                    if len(dirty) == 0 || dirty[len(dirty)-1] != parent {
+                        dirty = append(dirty, parent)
+                    }
+                    continue
+                }
+            }
+            current := workFileHash(p)
+            if current == "" {
+                if _, err := os.Lstat(BaseDir + p); err == nil && inNew {
+                    // something that is not a file sits where one goes
+                    dirty = append(dirty, p)
+                }
+                continue
+            }
+            if (inOld && current == before.Hash) || (inNew && current == after.Hash) {
+                continue
+            }
+            dirty = append(dirty, p)
+        }
+        if len(dirty) > 0 {
+            return fmt.Errorf("Local changes to %s would be overwritten: %w", strings.Join(dirty, ", "), ErrDirtyWorkTree)
+        }
+    }
+
+    for _, p := range paths {
+        // below a symlink the file belongs to wherever the link points
+        if _, inNew := new[p]; inNew || beyondSymlink(p) {
+            continue
+        }
+        err := os.Remove(BaseDir + p)
+        if err != nil && !errors.Is(err, fs.ErrNotExist) {
+            return &PathError{"remove", BaseDir + p, err}
+        }
+        removeEmptyParents(p)
+    }
+    for _, p := range paths {
+        after, inNew := new[p]
+        if !inNew {
+            continue
+        }
+        before, inOld := old[p]
+        if !force && inOld && before.Hash == after.Hash && before.Mode == after.Mode {
+            continue
+        }
+        err := writeWorkFile(p, after, force)
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// changedFiles narrows old and new to the paths where they differ, so a
+// forced checkout between the two leaves local edits to the rest alone
+func changedFiles(old map[string]models.IndexEntry, new map[string]models.IndexEntry) (map[string]models.IndexEntry, map[string]models.IndexEntry) {
+    oldChanged, newChanged := map[string]models.IndexEntry{}, map[string]models.IndexEntry{}
+    for p, before := range old {
+        if after, ok := new[p]; !ok || after.Hash != before.Hash || after.Mode != before.Mode {
+            oldChanged[p] = before
+        }
+    }
+    for p, after := range new {
+        if before, ok := old[p]; !ok || after.Hash != before.Hash || after.Mode != before.Mode {
+            newChanged[p] = after
+        }
+    }
+    return oldChanged, newChanged
+}
+
+// writeWorkFile materializes one blob at rel, replacing whatever is there
+func writeWorkFile(rel string, entry models.IndexEntry, force bool) (error) {
+    full := BaseDir + rel
+    if info, err := os.Lstat(full); err == nil && workFileHash(rel) == entry.Hash {
+        // the content is right if the kind is, a link can only be replaced
+        isLink := workMode(info.Mode()) == SYMLINK_MODE
+        if isLink && entry.Mode == SYMLINK_MODE {
+            return nil
+        }
+        if !isLink && entry.Mode != SYMLINK_MODE {
+            err := os.Chmod(full, fileMode(entry.Mode))
+            if err != nil {
+                return &PathError{"chmod", full, err}
+            }
+            return nil
+        }
+    }
+    content, err := readTypedObject(entry.Hash, BLOB)
+    if err != nil {
+        return err
+    }
+    return writeWorkContent(rel, entry.Mode, content, force)
+}
+
+// writeWorkContent puts content at rel as a file with mode or, for
+// SYMLINK_MODE, as a symlink to content. A file or symlink in the way of
+// one of its directories is only removed with force
+func writeWorkContent(rel string, mode string, content []byte, force bool) (error) {
+    err := checkPath(rel)
+    if err != nil {
+        return err
+    }
+    full := BaseDir + rel
+    for parent := blockedParent(rel); parent != ""; parent = blockedParent(rel) {
+        if !force {
+            return fmt.Errorf("\"%s\" is in the way of %s: %w", parent, rel, ErrDirtyWorkTree)
+        }
+        err = os.Remove(BaseDir + parent)
+        if err != nil {
+            return &PathError{"remove", BaseDir + parent, err}
+        }
+    }
+    err = os.MkdirAll(path.Dir(full), 0755)
+    if err != nil {
+        return &PathError{"create dir", path.Dir(full), err}
+    }
+    // writing through an old symlink would change its target instead
+    if info, err := os.Lstat(full); err == nil && (info.IsDir() || mode == SYMLINK_MODE || info.Mode() & os.ModeSymlink != 0) {
+        err = os.RemoveAll(full)
+        if err != nil {
+            return &PathError{"remove", full, err}
+        }
+    }
+    if mode == SYMLINK_MODE {
+        err = os.Symlink(string(content), full)
+        if err != nil {
+            return &PathError{"create symlink", full, err}
+        }
+        return nil
+    }
+    err = os.WriteFile(full, content, fileMode(mode))
+    if err != nil {
+        return &PathError{"write file", full, err}
+    }
+    // WriteFile leaves the mode of an existing file alone
+    err = os.Chmod(full, fileMode(mode))
+    if err != nil {
+        return &PathError{"chmod", full, err}
+    }
+    return nil
+}
+
+// blockedParent is the first directory above rel that the work tree has
+// something else at, "" when nothing is in the way
+func blockedParent(rel string) (string) {
+    for parent := path.Dir(rel); parent != "."; parent = path.Dir(parent) {
+        if info, err := os.Lstat(BaseDir + parent); err == nil && !info.IsDir() {
+            return parent
+        }
+    }
+    return ""
+}
+
+// removeEmptyParents deletes the directories above rel that are left empty
+func removeEmptyParents(rel string) {
+    for parent := path.Dir(rel); parent != "."; parent = path.Dir(parent) {
+        if os.Remove(BaseDir + parent) != nil {
+            return
+        }
+    }
+}
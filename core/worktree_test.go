@@ -0,0 +1,300 @@
+package core
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "goverse/internal/models"
+)
+
+func TestCheckEntryName(t *testing.T) {
+    tests := []struct {
+        name string
+        ok   bool
+    }{
+        {"file.txt", true},
+        {".hidden", true},
+        {"..dots", true},
+        {"", false},
+        {".", false},
+        {"..", false},
+        {".goverse", false},
+        {".GOVERSE", false},
+        {"a/b", false},
+        {"../escape", false},
+        {"nul\x00", false},
+    }
+    for _, tt := range tests {
+        err := checkEntryName(tt.name)
+        if (err == nil) != tt.ok {
+            t.Errorf("checkEntryName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+        }
+        if err != nil && !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("checkEntryName(%q) = %v, want ErrUnsafePath", tt.name, err)
+        }
+    }
+}
+
+func TestCheckPath(t *testing.T) {
+    tests := []struct {
+        path string
+        ok   bool
+    }{
+        {"a", true},
+        {"dir/sub/file", true},
+        {"../x", false},
+        {"dir/../../x", false},
+        {"/etc/passwd", false},
+        {"dir//x", false},
+        {".goverse/head", false},
+        {"dir/.goverse/head", false},
+        {"dir/", false},
+    }
+    for _, tt := range tests {
+        err := checkPath(tt.path)
+        if (err == nil) != tt.ok {
+            t.Errorf("checkPath(%q) = %v, want ok %v", tt.path, err, tt.ok)
+        }
+    }
+}
+
+func TestCheckoutFilesRefusesUnsafePaths(t *testing.T) {
+    dir := testRepo(t)
+    blob := storeTestBlob(t, "pwned\n")
+    for _, p := range []string{"../escape_gv", ".goverse/head", "a/../../escape_gv"} {
+        files := map[string]models.IndexEntry{
+            p: { Path: p, Mode: FILE_MODE, Hash: blob },
+        }
+        err := checkoutFiles(nil, files, true)
+        if !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("checkoutFiles(%q) = %v, want ErrUnsafePath", p, err)
+        }
+    }
+    mustNotExist(t, filepath.Join(filepath.Dir(filepath.Clean(dir)), "escape_gv"))
+    head, err := os.ReadFile(dir + HEAD_FILE)
+    if err != nil || string(head) == "pwned\n" {
+        t.Fatalf("HEAD was overwritten: %q, %v", head, err)
+    }
+}
+
+// a hostile tree has to be stopped while the pack is read, before anything
+// refers to it, and again if it is already in the object store
+func TestUnsafeTreeInPackIsRejected(t *testing.T) {
+    testRepo(t)
+    blob := storeTestBlob(t, "pwned\n")
+    for _, name := range []string{"..", "../escape_gv", ".goverse", "a/b"} {
+        tree := storeRawTree(t, models.TreeEntry{ Name: name, Mode: FILE_MODE, Hash: blob, IsBlob: true })
+        if _, err := deserializeTree(tree); !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("deserializeTree with %q = %v, want ErrUnsafePath", name, err)
+        }
+        kind, payload, err := readObject(tree)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := hashObject(kind, payload); !errors.Is(err, ErrUnsafePath) {
+            t.Errorf("hashObject with %q = %v, want ErrUnsafePath", name, err)
+        }
+    }
+}
+
+func TestCloneOfHostileRepositoryStaysInside(t *testing.T) {
+    src := testRepo(t)
+    blob := storeTestBlob(t, "pwned\n")
+    tree := storeRawTree(t, models.TreeEntry{ Name: "../escape_gv", Mode: FILE_MODE, Hash: blob, IsBlob: true })
+    commit, err := storeCommit(models.Commit{ Tree: tree, Message: "evil", Author: "x <x@x>", Timestamp: "2024-01-01T00:00:00Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = writeRef(BRANCHES_PREFIX + DEFAULT_BRANCH, commit)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    out := t.TempDir()
+    err = Clone([]string{ src, filepath.Join(out, "cl1") })
+    if !errors.Is(err, ErrUnsafePath) {
+        t.Fatalf("clone = %v, want ErrUnsafePath", err)
+    }
+    mustNotExist(t, filepath.Join(out, "escape_gv"))
+}
+
+func TestCloneOfHostileBundleStaysInside(t *testing.T) {
+    testRepo(t)
+    blob := storeTestBlob(t, "pwned\n")
+    tree := storeRawTree(t, models.TreeEntry{ Name: "../escape_gv", Mode: FILE_MODE, Hash: blob, IsBlob: true })
+    commit, err := storeCommit(models.Commit{ Tree: tree, Message: "evil", Author: "x <x@x>", Timestamp: "2024-01-01T00:00:00Z" })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // written by hand, bundle create would refuse to walk the tree
+    out := t.TempDir()
+    bundle := filepath.Join(out, "evil.bundle")
+    f, err := os.Create(bundle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    w := bufio.NewWriter(f)
+    fmt.Fprintln(w, BUNDLE_SIGNATURE)
+    err = writeRefs(w, map[string]string{ BRANCHES_PREFIX + "main": commit })
+    if err == nil {
+        err = writePack(w, []string{ blob, tree, commit })
+    }
+    if err == nil {
+        err = w.Flush()
+    }
+    f.Close()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    err = Clone([]string{ bundle, filepath.Join(out, "cl1") })
+    if !errors.Is(err, ErrUnsafePath) {
+        t.Fatalf("clone = %v, want ErrUnsafePath", err)
+    }
+    mustNotExist(t, filepath.Join(out, "escape_gv"))
+}
+
+func TestStagePath(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "file\n")
+    writeTestFile(t, "run.sh", "run\n")
+    writeTestFile(t, "private", "private\n")
+    writeTestFile(t, "realdir/inside", "inside\n")
+    err := os.Chmod(BaseDir + "run.sh", 0755)
+    if err == nil {
+        err = os.Chmod(BaseDir + "private", 0600)
+    }
+    if err == nil {
+        err = os.Symlink("nowhere", BaseDir + "link")
+    }
+    if err == nil {
+        err = os.Symlink("realdir", BaseDir + "dirlink")
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = Add(".")
+    if err != nil {
+        t.Fatal(err)
+    }
+    idx, err := readIndex()
+    if err != nil {
+        t.Fatal(err)
+    }
+    files := indexFiles(idx)
+
+    tests := []struct {
+        path    string
+        mode    string
+        content string
+    }{
+        {"file", FILE_MODE, "file\n"},
+        {"run.sh", EXECUTABLE_MODE, "run\n"},
+        // only the exec bit is kept
+        {"private", FILE_MODE, "private\n"},
+        // links are stored as their target, even a dangling one
+        {"link", SYMLINK_MODE, "nowhere"},
+        {"dirlink", SYMLINK_MODE, "realdir"},
+        {"realdir/inside", FILE_MODE, "inside\n"},
+    }
+    for _, tt := range tests {
+        hash, _ := hashBlob(models.Blob{ Content: []byte(tt.content) })
+        if got, ok := files[tt.path]; !ok || got.Mode != tt.mode || got.Hash != hash {
+            t.Errorf("%s staged as %+v, want mode %s holding %q", tt.path, got, tt.mode, tt.content)
+        }
+    }
+    // a link to a directory is never followed
+    if _, ok := files["dirlink/inside"]; ok {
+        t.Error("staged a file through a symlinked directory")
+    }
+    if len(files) != len(tests) {
+        t.Errorf("staged %d files, want %d", len(files), len(tests))
+    }
+}
+
+func TestCheckoutFileKinds(t *testing.T) {
+    testRepo(t)
+    files := map[string]models.IndexEntry{
+        "file": { Path: "file", Mode: FILE_MODE, Hash: storeTestBlob(t, "file\n") },
+        "run.sh": { Path: "run.sh", Mode: EXECUTABLE_MODE, Hash: storeTestBlob(t, "run\n") },
+        "link": { Path: "link", Mode: SYMLINK_MODE, Hash: storeTestBlob(t, "file") },
+    }
+    err := checkoutFiles(nil, files, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for p, entry := range files {
+        info, err := os.Lstat(BaseDir + p)
+        if err != nil || workMode(info.Mode()) != entry.Mode || workFileHash(p) != entry.Hash {
+            t.Errorf("%s was written as %v, %v, want mode %s", p, info, err, entry.Mode)
+        }
+    }
+    if target, err := os.Readlink(BaseDir + "link"); err != nil || target != "file" {
+        t.Errorf("link points at %q, %v, want file", target, err)
+    }
+
+    // the exec bit comes and goes with the mode, the content staying put
+    swapped := map[string]models.IndexEntry{
+        "file": { Path: "file", Mode: EXECUTABLE_MODE, Hash: files["file"].Hash },
+        "run.sh": { Path: "run.sh", Mode: FILE_MODE, Hash: files["run.sh"].Hash },
+        "link": files["link"],
+    }
+    err = checkoutFiles(files, swapped, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, p := range []string{ "file", "run.sh" } {
+        info, err := os.Lstat(BaseDir + p)
+        if err != nil || workMode(info.Mode()) != swapped[p].Mode {
+            t.Errorf("%s has mode %v, %v, want %s", p, info.Mode(), err, swapped[p].Mode)
+        }
+    }
+}
+
+func TestCheckoutKeepsFileInParentsPlace(t *testing.T) {
+    for _, force := range []bool{ false, true } {
+        testRepo(t)
+        // an untracked file sits where the new tree has a directory
+        writeTestFile(t, "dir", "precious\n")
+        files := map[string]models.IndexEntry{
+            "dir/sub": { Path: "dir/sub", Mode: FILE_MODE, Hash: storeTestBlob(t, "sub\n") },
+        }
+        err := checkoutFiles(nil, files, force)
+        if force {
+            if err != nil || readTestFile(t, "dir/sub") != "sub\n" {
+                t.Errorf("forced checkout = %v, want dir/sub written", err)
+            }
+            continue
+        }
+        if !errors.Is(err, ErrDirtyWorkTree) {
+            t.Errorf("checkout over an untracked dir = %v, want ErrDirtyWorkTree", err)
+        }
+        if got := readTestFile(t, "dir"); got != "precious\n" {
+            t.Errorf("the untracked file became %q", got)
+        }
+        // writing the file on its own refuses too
+        err = writeWorkFile("dir/sub", files["dir/sub"], false)
+        if !errors.Is(err, ErrDirtyWorkTree) {
+            t.Errorf("writeWorkFile = %v, want ErrDirtyWorkTree", err)
+        }
+    }
+
+    // a tracked file the new tree drops is cleared out of the way
+    testRepo(t)
+    writeTestFile(t, "dir", "tracked\n")
+    old := map[string]models.IndexEntry{
+        "dir": { Path: "dir", Mode: FILE_MODE, Hash: storeTestBlob(t, "tracked\n") },
+    }
+    new := map[string]models.IndexEntry{
+        "dir/sub": { Path: "dir/sub", Mode: FILE_MODE, Hash: storeTestBlob(t, "sub\n") },
+    }
+    err := checkoutFiles(old, new, false)
+    if err != nil || readTestFile(t, "dir/sub") != "sub\n" {
+        t.Errorf("checkout replacing a tracked file with a dir = %v", err)
+    }
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+package core
+
+import (
+    "os"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+func TestStagePathSkipsSpecialFiles(t *testing.T) {
+    testRepo(t)
+    writeTestFile(t, "file", "file\n")
+    err := syscall.Mkfifo(BaseDir + "fifo", 0644)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var warned string
+    printed, err := captureStdout(t, func() (error) {
+        var err error
+        warned, err = captureOutput(t, &os.Stderr, func() (error) {
+            return Add(".")
+        })
+        return err
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(warned, "warning: skipping fifo") {
+        t.Errorf("stderr has %q, want a warning about fifo", warned)
+    }
+    if strings.Contains(printed, "warning") {
+        t.Errorf("the warning went to stdout: %q", printed)
+    }
+    idx, err := readIndex()
+    if err != nil {
+        t.Fatal(err)
+    }
+    files := indexFiles(idx)
+    if _, ok := files["fifo"]; ok || len(files) != 1 {
+        t.Errorf("staged %v, want only file", files)
+    }
+}
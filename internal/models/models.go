@@ -15,15 +15,19 @@ import (
 // Conceptual structs
 type Commit struct {
     Hash      string
+    Tree      string
+    Parents   []string
     Message   string
     Author    string
     Timestamp string
 }
 
 type Tag struct {
-    Name    string
-    Version string
-    Commit  string
+    Name      string
+    Version   string
+    Commit    string
+    Tagger    string
+    Timestamp string
 }
 
 // Storage structs
@@ -43,3 +47,46 @@ type TreeEntry struct {
 type Tree struct {
     Entries []TreeEntry
 }
+
+// Staging structs
+type IndexEntry struct {
+    Path string
+    Mode string
+    Hash string
+}
+
+type Index struct {
+    Entries []IndexEntry
+}
+
+// History structs
+type ReflogEntry struct {
+    Old       string
+    New       string
+    Identity  string
+    Timestamp string
+    Reason    string
+    Message   string
+}
+
+type BlameLine struct {
+    Hash      string
+    Author    string
+    Timestamp string
+    Path      string
+    OrigLine  int
+    FinalLine int
+    Content   string
+}
+
+// Graph structs
+type GraphCell struct {
+    Symbol string
+    Lane   int
+}
+
+type GraphRow struct {
+    Cells  []GraphCell
+    Commit Commit
+    Refs   []string
+}